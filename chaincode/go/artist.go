@@ -0,0 +1,316 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Artist registry ====
+// Artists are records of their own, shared by every gallery of the channel and stored under a
+// composite key built from artistPrefix and the artist ID, a slug chosen at creation such as
+// "claude-monet". A picture keeps only the ID of its artist, so a change of the record shows on
+// every picture without rewriting them. The artist~name index, see index.go, links an artist
+// to its pictures, and an artist with pictures cannot be deleted.
+//
+// Every gallery reads the record and refers to it, but only members of the organisation that
+// registered the artist update or delete it.
+//
+// Identifiers of authority files make the records comparable between institutions: the
+// Getty Union List of Artist Names (ULAN, e.g. 500019484) and Wikidata (e.g. Q296).
+
+const artistPrefix = "artist"
+
+type artist struct {
+	ObjectType     string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	BirthYear      int       `json:"birthYear,omitempty"` //0 if unknown
+	DeathYear      int       `json:"deathYear,omitempty"` //0 if living or unknown
+	Nationality    string    `json:"nationality,omitempty"`
+	ULANID         string    `json:"ulanId,omitempty"`
+	WikidataID     string    `json:"wikidataId,omitempty"`
+	CreatedBy      identity  `json:"createdBy"`
+	LastModifiedBy *identity `json:"lastModifiedBy,omitempty"`
+}
+
+var (
+	artistIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	ulanIDPattern   = regexp.MustCompile(`^500[0-9]{6}$`)
+	wikidataPattern = regexp.MustCompile(`^Q[1-9][0-9]*$`)
+)
+
+// ===========================================================================================
+// getArtist reads and decodes an artist, returning a NOT_FOUND error of the artistId argument
+// if it does not exist
+// ===========================================================================================
+func getArtist(stub shim.ChaincodeStubInterface, artistID string) (*artist, error) {
+	artistKey, err := stub.CreateCompositeKey(artistPrefix, []string{artistID})
+	if err != nil {
+		return nil, err
+	}
+	artistAsBytes, err := stub.GetState(artistKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get artist: %s", err)
+	} else if artistAsBytes == nil {
+		return nil, newError(codeNotFound, "artistId", "Artist does not exist: %s", artistID)
+	}
+
+	a := &artist{}
+	err = json.Unmarshal(artistAsBytes, a)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode artist: %s", artistID)
+	}
+	return a, nil
+}
+
+func putArtist(stub shim.ChaincodeStubInterface, a *artist) error {
+	artistKey, err := stub.CreateCompositeKey(artistPrefix, []string{a.ID})
+	if err != nil {
+		return err
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	a.LastModifiedBy = &caller
+	artistJSONasBytes, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(artistKey, artistJSONasBytes)
+}
+
+// ===========================================================================================
+// checkArtistEditor verifies the caller may change an artist: it must be a member of the
+// organisation that registered it
+// ===========================================================================================
+func checkArtistEditor(stub shim.ChaincodeStubInterface, a *artist) error {
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if caller.MSPID != a.CreatedBy.MSPID {
+		return newError(codeForbidden, "", "Only members of %s, which registered artist %s, may change it", a.CreatedBy.MSPID, a.ID)
+	}
+	return nil
+}
+
+// artistPictures returns the names of the pictures of an artist, from the artist~name index
+func artistPictures(stub shim.ChaincodeStubInterface, artistID string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(artistIndex, []string{artistID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	names := []string{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, compositeKeyParts[1])
+	}
+	return names, nil
+}
+
+// ===========================================================================================
+// artistFromArgs builds an artist from the arguments of createArtist and updateArtist
+// ===========================================================================================
+func artistFromArgs(stub shim.ChaincodeStubInterface, args []string) (*artist, error) {
+
+	//   0                1              2       3          4             5            6
+	// "claude-monet", "Claude Monet", "1840", "1926", "French", "500019484", "Q296"
+	// all but the ID and the name may be empty or left out, see registry.go
+	optional := func(i int) string {
+		if i < len(args) {
+			return strings.TrimSpace(args[i])
+		}
+		return ""
+	}
+	a := &artist{
+		ObjectType:  "artist",
+		ID:          args[0],
+		Name:        strings.TrimSpace(args[1]),
+		Nationality: optional(4),
+		ULANID:      optional(5),
+		WikidataID:  optional(6),
+	}
+	if !artistIDPattern.MatchString(a.ID) {
+		return nil, newError(codeInvalidArgument, "artistId", "Artist ID must be lowercase letters and digits separated by dashes, e.g. claude-monet")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	a.BirthYear, _ = strconv.Atoi(optional(2))
+	a.DeathYear, _ = strconv.Atoi(optional(3))
+	if a.BirthYear < 0 || a.BirthYear > now.Year() {
+		return nil, newError(codeInvalidArgument, "birthYear", "Birth year must be between 1 and the current year")
+	}
+	if a.DeathYear < 0 || a.DeathYear > now.Year() {
+		return nil, newError(codeInvalidArgument, "deathYear", "Death year must be between 1 and the current year")
+	}
+	if a.BirthYear != 0 && a.DeathYear != 0 && a.DeathYear < a.BirthYear {
+		return nil, newError(codeInvalidArgument, "deathYear", "Death year %d is before birth year %d", a.DeathYear, a.BirthYear)
+	}
+	if a.ULANID != "" && !ulanIDPattern.MatchString(a.ULANID) {
+		return nil, newError(codeInvalidArgument, "ulanId", "ULAN ID must be 9 digits starting with 500, e.g. 500019484")
+	}
+	if a.WikidataID != "" && !wikidataPattern.MatchString(a.WikidataID) {
+		return nil, newError(codeInvalidArgument, "wikidataId", "Wikidata ID must be a Q number, e.g. Q296")
+	}
+	return a, nil
+}
+
+// ===========================================================================================
+// createArtist - a gallery member registers an artist pictures can refer to
+// ===========================================================================================
+func (t *SimpleChaincode) createArtist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start createArtist ", args[0])
+
+	a, err := artistFromArgs(stub, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	artistKey, err := stub.CreateCompositeKey(artistPrefix, []string{a.ID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	artistAsBytes, err := stub.GetState(artistKey)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to get artist: %s", err))
+	} else if artistAsBytes != nil {
+		return errorResponse(newError(codeAlreadyExists, "artistId", "This artist already exists: %s", a.ID))
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	a.CreatedBy = caller
+
+	err = putArtist(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventArtistCreated, nil, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end createArtist (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// readArtist - read an artist
+// ===========================================================================================
+func (t *SimpleChaincode) readArtist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "claude-monet"
+	a, err := getArtist(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	artistJSONasBytes, err := json.Marshal(a)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(artistJSONasBytes)
+}
+
+// ===========================================================================================
+// updateArtist - replace the details of an artist, which members of the registering
+// organisation do
+// ===========================================================================================
+func (t *SimpleChaincode) updateArtist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start updateArtist ", args[0])
+
+	a, err := artistFromArgs(stub, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	previous, err := getArtist(stub, a.ID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkArtistEditor(stub, previous)
+	if err != nil {
+		return errorResponse(err)
+	}
+	a.CreatedBy = previous.CreatedBy
+	err = putArtist(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventArtistUpdated, nil, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end updateArtist (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// deleteArtist - remove an artist registered by mistake, which members of the registering
+// organisation do. Artists with pictures, retired ones included, are kept so every picture
+// refers to an existing artist.
+// ===========================================================================================
+func (t *SimpleChaincode) deleteArtist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "claude-monet"
+	artistID := args[0]
+	fmt.Println("- start deleteArtist ", artistID)
+
+	a, err := getArtist(stub, artistID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkArtistEditor(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+	names, err := artistPictures(stub, artistID)
+	if err != nil {
+		return errorResponse(err)
+	} else if len(names) > 0 {
+		return errorResponse(newError(codeConflict, "artistId", "Artist %s has %d pictures, e.g. %s", artistID, len(names), names[0]))
+	}
+
+	artistKey, err := stub.CreateCompositeKey(artistPrefix, []string{artistID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.DelState(artistKey)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+	}
+
+	err = emitEvent(stub, eventArtistDeleted, nil, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end deleteArtist (success)")
+	return shim.Success(nil)
+}
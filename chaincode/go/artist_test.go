@@ -0,0 +1,164 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// createArtist registers an artist with its name only, as a Louvre member
+func createArtist(t *testing.T, stub *shim.MockStub, id, name string) {
+	t.Helper()
+	checkInvoke(t, stub, louvreUser, "createArtist", id, name)
+}
+
+func readTestArtist(t *testing.T, stub *shim.MockStub, id string) artist {
+	t.Helper()
+	a := artist{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "readArtist", id), &a)
+	if err != nil {
+		t.Fatal("Failed to decode artist:", err)
+	}
+	return a
+}
+
+func TestCreateArtist(t *testing.T) {
+	stub := newTestStub()
+	checkInvoke(t, stub, guggenheimUser, "createArtist", "claude-monet", " Claude Monet ", "1840", "1926", "French", "500019484", "Q296")
+	if event := lastEvent(t, stub); event.Type != eventArtistCreated || len(event.Pictures) != 0 {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+
+	a := readTestArtist(t, stub, "claude-monet")
+	expected := artist{
+		ObjectType:     "artist",
+		ID:             "claude-monet",
+		Name:           "Claude Monet",
+		BirthYear:      1840,
+		DeathYear:      1926,
+		Nationality:    "French",
+		ULANID:         "500019484",
+		WikidataID:     "Q296",
+		CreatedBy:      a.CreatedBy,
+		LastModifiedBy: a.LastModifiedBy,
+	}
+	if a != expected || a.CreatedBy != guggenheimUser.id || a.LastModifiedBy == nil || *a.LastModifiedBy != guggenheimUser.id {
+		t.Fatalf("Unexpected artist %+v", a)
+	}
+
+	// details other than the name may be empty or left out
+	checkInvoke(t, stub, louvreUser, "createArtist", "anonymous-master", "Master of the Female Half-Lengths", "", "", "Flemish")
+	if a := readTestArtist(t, stub, "anonymous-master"); a.BirthYear != 0 || a.DeathYear != 0 || a.Nationality != "Flemish" || a.ULANID != "" {
+		t.Fatalf("Unexpected artist %+v", a)
+	}
+
+	nextYear := fmt.Sprint(time.Now().Year() + 1)
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+		field   string
+	}{
+		{"not a gallery", outsiderUser, []string{"henri-matisse", "Henri Matisse"}, "createArtist is reserved to gallery members, not OutsiderMSP", ""},
+		{"existing artist", louvreUser, []string{"claude-monet", "Claude Monet"}, "This artist already exists: claude-monet", "artistId"},
+		{"invalid ID", louvreUser, []string{"Henri Matisse", "Henri Matisse"}, "Artist ID must be lowercase letters and digits separated by dashes", "artistId"},
+		{"empty name", louvreUser, []string{"henri-matisse", " "}, "Argument 2 (name) must be a non-empty string", "name"},
+		{"non-numeric year", louvreUser, []string{"henri-matisse", "Henri Matisse", "circa 1869"}, "Argument 3 (birthYear) must be a year or empty", "birthYear"},
+		{"future year", louvreUser, []string{"henri-matisse", "Henri Matisse", "1869", nextYear}, "Death year must be between 1 and the current year", "deathYear"},
+		{"death before birth", louvreUser, []string{"henri-matisse", "Henri Matisse", "1954", "1869"}, "Death year 1869 is before birth year 1954", "deathYear"},
+		{"invalid ULAN ID", louvreUser, []string{"henri-matisse", "Henri Matisse", "1869", "1954", "French", "17300"}, "ULAN ID must be 9 digits starting with 500", "ulanId"},
+		{"invalid Wikidata ID", louvreUser, []string{"henri-matisse", "Henri Matisse", "1869", "1954", "French", "500017300", "5589"}, "Wikidata ID must be a Q number", "wikidataId"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, test.message, append([]string{"createArtist"}, test.args...)...)
+			if e.Field != test.field {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+	checkInvokeError(t, stub, outsiderUser, "Artist does not exist: henri-matisse", "readArtist", "henri-matisse")
+}
+
+func TestUpdateArtist(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, guggenheimUser, "picture2", "red")
+
+	// the pictures keep the artist ID only, they are not rewritten
+	checkInvoke(t, stub, louvreAdmin, "updateArtist", "claude-monet", "Oscar-Claude Monet", "1840", "1926", "French", "500019484", "Q296")
+	event := lastEvent(t, stub)
+	if event.Type != eventArtistUpdated || len(event.Pictures) != 0 {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	a := readTestArtist(t, stub, "claude-monet")
+	if a.Name != "Oscar-Claude Monet" || a.BirthYear != 1840 || a.CreatedBy != louvreUser.id || *a.LastModifiedBy != louvreAdmin.id {
+		t.Fatalf("Unexpected artist %+v", a)
+	}
+	if pic := checkPicture(t, stub, "picture2"); pic.ArtistID != "claude-monet" || *pic.LastModifiedBy != guggenheimUser.id {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+
+	checkInvoke(t, stub, louvreUser, "updateArtist", "claude-monet", "Oscar-Claude Monet", "1840", "1926", "French")
+	if a := readTestArtist(t, stub, "claude-monet"); a.ULANID != "" || a.WikidataID != "" || a.CreatedBy != louvreUser.id {
+		t.Fatalf("Unexpected artist %+v", a)
+	}
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+		code    string
+	}{
+		{"other organisation", guggenheimUser, []string{"claude-monet", "Claude Monet"}, "Only members of LouvreMSP, which registered artist claude-monet, may change it", codeForbidden},
+		{"admin of another organisation", guggenheimAdmin, []string{"claude-monet", "Claude Monet"}, "Only members of LouvreMSP, which registered artist claude-monet, may change it", codeForbidden},
+		{"missing artist", louvreUser, []string{"henri-matisse", "Henri Matisse"}, "Artist does not exist: henri-matisse", codeNotFound},
+		{"not a gallery", outsiderUser, []string{"claude-monet", "Claude Monet"}, "updateArtist is reserved to gallery members, not OutsiderMSP", codeForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, test.message, append([]string{"updateArtist"}, test.args...)...)
+			if e.Code != test.code {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+}
+
+func TestDeleteArtist(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createArtist(t, stub, "henri-matisse", "Henri Matisse")
+
+	e := checkInvokeError(t, stub, louvreUser, "Artist claude-monet has 1 pictures, e.g. picture1", "deleteArtist", "claude-monet")
+	if e.Code != codeConflict || e.Field != "artistId" {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	// retiring the picture keeps it linked to the artist
+	checkInvoke(t, stub, louvreUser, "retirePicture", "picture1", statusDestroyed, "Fire in storage")
+	checkInvokeError(t, stub, louvreUser, "Artist claude-monet has 1 pictures", "deleteArtist", "claude-monet")
+
+	checkInvokeError(t, stub, guggenheimAdmin, "Only members of LouvreMSP, which registered artist henri-matisse, may change it", "deleteArtist", "henri-matisse")
+	checkInvoke(t, stub, louvreAdmin, "deleteArtist", "henri-matisse")
+	if event := lastEvent(t, stub); event.Type != eventArtistDeleted {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	if stub.State[compositeKey(t, stub, artistPrefix, "henri-matisse")] != nil {
+		t.Fatal("The artist was not deleted")
+	}
+	checkInvokeError(t, stub, louvreUser, "Artist does not exist: henri-matisse", "deleteArtist", "henri-matisse")
+	checkInvokeError(t, stub, louvreUser, "Artist does not exist: henri-matisse", "initPicture", "picture2", "red", "50", "henri-matisse", "The Dance", "1910", "Oil on canvas", "260 x 391 cm", "GE-9673")
+	e = checkInvokeError(t, stub, outsiderUser, "Artist does not exist: henri-matisse", "getPicturesByArtist", "henri-matisse")
+	if e.Code != codeNotFound || e.Field != "artistId" {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
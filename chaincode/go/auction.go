@@ -0,0 +1,714 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Auctions ====
+// The owner of a picture puts it up for auction, which takes the picture off the market
+// (status atAuction) until the auction is closed or cancelled. Two kinds are supported:
+//
+//  - english: bids are public and every bid must beat the highest one so far.
+//  - sealed:  bidders only commit to the hex SHA-256 of "<amount>:<salt>", the salt being
+//             random and at least minSaltLength characters long, before the bidding
+//             deadline, then reveal amount and salt before the reveal deadline. Amounts stay
+//             unknown to everyone, seller included, until bidding is over. Revealed amounts
+//             are private, see private.go: they come in the bid transient field and are
+//             written to the collections of the bidder and of the seller, the auction and its
+//             events only record the hash of each revealed bid.
+//
+// Closing an auction after its deadline hands the picture to the highest bid at or above the
+// reserve price, using the same owner change and sale record as an accepted sale offer. Any
+// gallery member closes an english auction, whose bids are public. The revealed amounts of a
+// sealed auction are read from the collection of the seller, so members of the seller's
+// organisation close it. If they have not within auctionSettlementPeriod after the reveal
+// deadline, anyone may close it, and it lapses without a sale: a seller cannot keep the
+// picture at auction by never closing. Auctions are stored under a composite key built from
+// auctionPrefix and the auction ID, which is the ID of the createAuction transaction.
+
+const auctionPrefix = "auction"
+
+const (
+	auctionEnglish = "english"
+	auctionSealed  = "sealed"
+)
+
+const (
+	auctionOpen      = "open"
+	auctionClosed    = "closed"
+	auctionLapsed    = "lapsed" //sealed auction the seller did not close in time, without a sale
+	auctionCancelled = "cancelled"
+)
+
+// auctionSettlementPeriod is the time the seller's organisation has after the reveal deadline
+// of a sealed auction to close it, before the auction may lapse
+const auctionSettlementPeriod = 7 * 24 * time.Hour
+
+type auction struct {
+	ObjectType   string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID           string    `json:"id"`
+	Picture      string    `json:"picture"`
+	Seller       identity  `json:"seller"`
+	Type         string    `json:"type"`
+	Currency     string    `json:"currency"`
+	ReservePrice string    `json:"reservePrice"`
+	BiddingEnds  string    `json:"biddingEnds"`          //RFC3339
+	RevealEnds   string    `json:"revealEnds,omitempty"` //RFC3339, sealed auctions only
+	Status       string    `json:"status"`
+	Bids         []bid     `json:"bids"`
+	Winner       *identity `json:"winner,omitempty"` //the price is in the sale record, see sale.go
+}
+
+type bid struct {
+	Bidder     identity `json:"bidder"`
+	Amount     string   `json:"amount,omitempty"`     //english bids
+	Commitment string   `json:"commitment,omitempty"` //sealed bids, hex SHA-256 of "<amount>:<salt>"
+	BidHash    string   `json:"bidHash,omitempty"`    //sealed bids once revealed, hash of the privateBid
+	PlacedAt   string   `json:"placedAt"`             //RFC3339
+}
+
+// privateBid is the amount of a revealed sealed bid, in the collections of the bidder and the seller
+type privateBid struct {
+	ObjectType string   `json:"docType"`
+	Auction    string   `json:"auction"`
+	Bidder     identity `json:"bidder"`
+	Amount     string   `json:"amount"`
+	Salt       string   `json:"salt"`
+}
+
+// amountInCents converts an amount validated by validatePrice to an integer for comparisons
+func amountInCents(amount string) int64 {
+	parts := strings.SplitN(amount, ".", 2)
+	units, _ := strconv.ParseInt(parts[0], 10, 64)
+	var cents int64
+	if len(parts) == 2 {
+		cents, _ = strconv.ParseInt((parts[1] + "0")[:2], 10, 64)
+	}
+	return units*100 + cents
+}
+
+// bidCommitment returns the commitment a sealed bidder submits for an amount and salt
+func bidCommitment(amount, salt string) string {
+	hash := sha256.Sum256([]byte(amount + ":" + salt))
+	return hex.EncodeToString(hash[:])
+}
+
+// getTransientBid decodes the bid transient field, see private.go, of a bidder in a sealed auction
+func getTransientBid(stub shim.ChaincodeStubInterface, a *auction, bidder identity) (*privateBid, error) {
+	b := &privateBid{}
+	ok, err := getTransientJSON(stub, transientBid, b)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, newError(codeInvalidArgument, transientBid, "The amount must be given in the bid transient field")
+	}
+	err = validatePrice(b.Amount, a.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSalt(transientBid, b.Salt)
+	if err != nil {
+		return nil, err
+	}
+	b.ObjectType = "auctionBid"
+	b.Auction = a.ID
+	b.Bidder = bidder
+	return b, nil
+}
+
+// putPrivateBid writes a bid to the collections of the bidder and the seller and returns its hash
+func putPrivateBid(stub shim.ChaincodeStubInterface, a *auction, b *privateBid) (string, error) {
+	bidKey, err := stub.CreateCompositeKey(auctionBidPrefix, []string{a.ID, b.Bidder.MSPID, b.Bidder.Subject})
+	if err != nil {
+		return "", err
+	}
+	return putPrivate(stub, []string{b.Bidder.MSPID, a.Seller.MSPID}, bidKey, b)
+}
+
+// ===========================================================================================
+// getPrivateBid reads a bid from the collection of an organisation and checks it against the
+// hash recorded in the auction. It returns nil if the collection does not hold the bid.
+// ===========================================================================================
+func getPrivateBid(stub shim.ChaincodeStubInterface, mspID string, a *auction, placed bid) (*privateBid, error) {
+	bidKey, err := stub.CreateCompositeKey(auctionBidPrefix, []string{a.ID, placed.Bidder.MSPID, placed.Bidder.Subject})
+	if err != nil {
+		return nil, err
+	}
+	bidAsBytes, err := stub.GetPrivateData(privateCollection(mspID), bidKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get private data: %s", err)
+	} else if bidAsBytes == nil {
+		return nil, nil
+	}
+	hash := sha256.Sum256(bidAsBytes)
+	if hex.EncodeToString(hash[:]) != placed.BidHash {
+		return nil, fmt.Errorf("Bid of %s in auction %s does not match its hash", placed.Bidder, a.ID)
+	}
+
+	b := &privateBid{}
+	err = json.Unmarshal(bidAsBytes, b)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode bid of %s in auction %s", placed.Bidder, a.ID)
+	}
+	return b, nil
+}
+
+func parseDeadline(value, argName string) (time.Time, error) {
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC3339 timestamp: %s", argName, value)
+	}
+	return deadline.UTC(), nil
+}
+
+func getAuction(stub shim.ChaincodeStubInterface, auctionID string) (*auction, error) {
+	auctionKey, err := stub.CreateCompositeKey(auctionPrefix, []string{auctionID})
+	if err != nil {
+		return nil, err
+	}
+	auctionAsBytes, err := stub.GetState(auctionKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get auction: %s", err)
+	} else if auctionAsBytes == nil {
+		return nil, newError(codeNotFound, "auctionId", "Auction does not exist: %s", auctionID)
+	}
+
+	a := &auction{}
+	err = json.Unmarshal(auctionAsBytes, a)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode auction: %s", auctionID)
+	}
+	return a, nil
+}
+
+func putAuction(stub shim.ChaincodeStubInterface, a *auction) error {
+	auctionKey, err := stub.CreateCompositeKey(auctionPrefix, []string{a.ID})
+	if err != nil {
+		return err
+	}
+	auctionJSONasBytes, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(auctionKey, auctionJSONasBytes)
+}
+
+// getOpenAuctionForBid loads an auction of the given type and checks bids are still accepted
+func getOpenAuctionForBid(stub shim.ChaincodeStubInterface, auctionID, auctionType string) (*auction, identity, time.Time, error) {
+	a, err := getAuction(stub, auctionID)
+	if err != nil {
+		return nil, identity{}, time.Time{}, err
+	}
+	if a.Type != auctionType {
+		return nil, identity{}, time.Time{}, newError(codeConflict, "auctionId", "Auction %s is not a %s auction", auctionID, auctionType)
+	}
+	if a.Status != auctionOpen {
+		return nil, identity{}, time.Time{}, newError(codeConflict, "auctionId", "Auction %s is %s", auctionID, a.Status)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, identity{}, time.Time{}, err
+	}
+	biddingEnds, err := parseDeadline(a.BiddingEnds, "biddingEnds")
+	if err != nil {
+		return nil, identity{}, time.Time{}, err
+	}
+	if !now.Before(biddingEnds) {
+		return nil, identity{}, time.Time{}, newError(codeConflict, "auctionId", "Bidding for auction %s ended at %s", auctionID, a.BiddingEnds)
+	}
+	bidder, _, err := getCaller(stub)
+	if err != nil {
+		return nil, identity{}, time.Time{}, err
+	}
+	if bidder == a.Seller {
+		return nil, identity{}, time.Time{}, newError(codeForbidden, "", "The seller cannot bid in its own auction")
+	}
+	return a, bidder, now, nil
+}
+
+// ===========================================================================================
+// createAuction - the owner of a picture puts it up for auction
+// ===========================================================================================
+func (t *SimpleChaincode) createAuction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1          2        3              4                         5
+	// "name", "english", "EUR", "100000.00", "2018-06-30T18:00:00Z", "2018-07-02T18:00:00Z" (reveal deadline, sealed only)
+	pictureName := args[0]
+	auctionType := args[1]
+	currency := args[2]
+	reservePrice := args[3]
+	err := validatePrice(reservePrice, currency)
+	if err != nil {
+		return errorResponse(err)
+	}
+	biddingEnds, err := parseDeadline(args[4], "5th argument")
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !biddingEnds.After(now) {
+		return errorResponse(newError(codeInvalidArgument, "biddingEnds", "5th argument must be in the future"))
+	}
+
+	var revealEnds string
+	switch auctionType {
+	case auctionEnglish:
+		if len(args) != 5 {
+			return errorResponse(newError(codeInvalidArgument, "revealEnds", "Incorrect number of arguments. Expecting 5 for an english auction"))
+		}
+	case auctionSealed:
+		if len(args) != 6 {
+			return errorResponse(newError(codeInvalidArgument, "revealEnds", "Incorrect number of arguments. Expecting 6 for a sealed auction"))
+		}
+		revealDeadline, err := parseDeadline(args[5], "6th argument")
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !revealDeadline.After(biddingEnds) {
+			return errorResponse(newError(codeInvalidArgument, "revealEnds", "6th argument must be after the bidding deadline"))
+		}
+		revealEnds = revealDeadline.Format(time.RFC3339)
+	default:
+		return errorResponse(newError(codeInvalidArgument, "type", "2nd argument must be english or sealed"))
+	}
+	fmt.Println("- start createAuction ", pictureName, auctionType)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkNotStolen(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkAvailable(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	a := &auction{
+		ObjectType:   "auction",
+		ID:           stub.GetTxID(),
+		Picture:      pictureName,
+		Seller:       pic.Owner,
+		Type:         auctionType,
+		Currency:     currency,
+		ReservePrice: reservePrice,
+		BiddingEnds:  biddingEnds.Format(time.RFC3339),
+		RevealEnds:   revealEnds,
+		Status:       auctionOpen,
+		Bids:         []bid{},
+	}
+	err = putAuction(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// the picture is off the market until the auction ends, pending offers are withdrawn
+	pic.Status = statusAtAuction
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = deleteTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventAuctionCreated, []string{pictureName}, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end createAuction (success)")
+	return shim.Success([]byte(a.ID))
+}
+
+// ===========================================================================================
+// placeBid - bid in an english auction, the bid must beat the reserve and the highest bid
+// ===========================================================================================
+func (t *SimpleChaincode) placeBid(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1
+	// "auctionID", "120000.00"
+	a, bidder, now, err := getOpenAuctionForBid(stub, args[0], auctionEnglish)
+	if err != nil {
+		return errorResponse(err)
+	}
+	amount := args[1]
+	err = validatePrice(amount, a.Currency)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if amountInCents(amount) < amountInCents(a.ReservePrice) {
+		return errorResponse(newError(codeInvalidArgument, "amount", "Bid is below the reserve price of %s %s", a.ReservePrice, a.Currency))
+	}
+	// english bids are appended in order, so the last one is the highest
+	if len(a.Bids) > 0 && amountInCents(amount) <= amountInCents(a.Bids[len(a.Bids)-1].Amount) {
+		return errorResponse(newError(codeInvalidArgument, "amount", "Bid must be higher than %s %s", a.Bids[len(a.Bids)-1].Amount, a.Currency))
+	}
+
+	a.Bids = append(a.Bids, bid{Bidder: bidder, Amount: amount, PlacedAt: now.Format(time.RFC3339)})
+	err = putAuction(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventBidPlaced, []string{a.Picture}, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// commitBid - commit to a bid in a sealed auction. A bidder may replace its commitment
+// until bidding ends.
+// ===========================================================================================
+func (t *SimpleChaincode) commitBid(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1
+	// "auctionID", "<hex sha256 of amount:salt>"
+	a, bidder, now, err := getOpenAuctionForBid(stub, args[0], auctionSealed)
+	if err != nil {
+		return errorResponse(err)
+	}
+	commitment := strings.ToLower(args[1])
+
+	committed := bid{Bidder: bidder, Commitment: commitment, PlacedAt: now.Format(time.RFC3339)}
+	replaced := false
+	for i := range a.Bids {
+		if a.Bids[i].Bidder == bidder {
+			a.Bids[i] = committed
+			replaced = true
+		}
+	}
+	if !replaced {
+		a.Bids = append(a.Bids, committed)
+	}
+
+	err = putAuction(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventBidCommitted, []string{a.Picture}, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// revealBid - reveal the amount and salt of a sealed bid to the seller, between the bidding
+// and the reveal deadlines. Bids that are never revealed cannot win.
+// ===========================================================================================
+func (t *SimpleChaincode) revealBid(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "auctionID"
+	// with the transient field bid: {"amount":"120000.00","salt":"..."}
+	a, err := getAuction(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if a.Type != auctionSealed {
+		return errorResponse(newError(codeConflict, "auctionId", "Auction %s is not a sealed auction", a.ID))
+	}
+	if a.Status != auctionOpen {
+		return errorResponse(newError(codeConflict, "auctionId", "Auction %s is %s", a.ID, a.Status))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	biddingEnds, err := parseDeadline(a.BiddingEnds, "biddingEnds")
+	if err != nil {
+		return errorResponse(err)
+	}
+	revealEnds, err := parseDeadline(a.RevealEnds, "revealEnds")
+	if err != nil {
+		return errorResponse(err)
+	}
+	if now.Before(biddingEnds) || !now.Before(revealEnds) {
+		return errorResponse(newError(codeConflict, "auctionId", "Bids for auction %s can be revealed from %s until %s", a.ID, a.BiddingEnds, a.RevealEnds))
+	}
+
+	bidder, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	revealed, err := getTransientBid(stub, a, bidder)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for i := range a.Bids {
+		if a.Bids[i].Bidder != bidder {
+			continue
+		}
+		if a.Bids[i].Commitment != bidCommitment(revealed.Amount, revealed.Salt) {
+			return errorResponse(newError(codeInvalidArgument, transientBid, "Amount and salt do not match the committed bid"))
+		}
+		a.Bids[i].BidHash, err = putPrivateBid(stub, a, revealed)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = putAuction(stub, a)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = emitEvent(stub, eventBidRevealed, []string{a.Picture}, a)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(nil)
+	}
+	return errorResponse(newError(codeNotFound, "auctionId", "No committed bid from %s in auction %s", bidder, a.ID))
+}
+
+// ===========================================================================================
+// closeAuction - settle an auction once its deadline has passed. The highest bid at or above
+// the reserve wins, ties going to the earliest bid; without such a bid the picture stays with
+// the seller. Members of the seller's organisation close a sealed auction, its collection
+// holding the amounts of the revealed bids, or anyone once the settlement period is over and
+// the auction lapses.
+// ===========================================================================================
+func (t *SimpleChaincode) closeAuction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "auctionID"
+	a, err := getAuction(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if a.Status != auctionOpen {
+		return errorResponse(newError(codeConflict, "auctionId", "Auction %s is %s", a.ID, a.Status))
+	}
+	deadline := a.BiddingEnds
+	if a.Type == auctionSealed {
+		deadline = a.RevealEnds
+	}
+	ends, err := parseDeadline(deadline, "deadline")
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if now.Before(ends) {
+		return errorResponse(newError(codeConflict, "auctionId", "Auction %s cannot be closed before %s", a.ID, deadline))
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lapsed := false
+	if a.Type == auctionSealed && caller.MSPID != a.Seller.MSPID {
+		lapses := ends.Add(auctionSettlementPeriod)
+		if now.Before(lapses) {
+			return errorResponse(newError(codeForbidden, "", "Auction %s is closed by members of %s, which holds its bids, or by anyone once it lapses at %s", a.ID, a.Seller.MSPID, lapses.Format(time.RFC3339)))
+		}
+		lapsed = true
+	}
+	fmt.Println("- start closeAuction ", a.ID)
+
+	var winner *bid
+	var winnerSalt string
+	for i := 0; i < len(a.Bids) && !lapsed; i++ {
+		placed := a.Bids[i]
+		salt := ""
+		if a.Type == auctionSealed {
+			// unrevealed sealed bids have no amount and are skipped
+			if placed.BidHash == "" {
+				continue
+			}
+			b, err := getPrivateBid(stub, a.Seller.MSPID, a, placed)
+			if err != nil {
+				return errorResponse(err)
+			} else if b == nil {
+				return errorResponse(fmt.Errorf("Bid of %s in auction %s not found in %s", placed.Bidder, a.ID, privateCollection(a.Seller.MSPID)))
+			}
+			placed.Amount = b.Amount
+			salt = b.Salt
+		}
+		if amountInCents(placed.Amount) < amountInCents(a.ReservePrice) {
+			continue
+		}
+		if winner == nil || amountInCents(placed.Amount) > amountInCents(winner.Amount) ||
+			amountInCents(placed.Amount) == amountInCents(winner.Amount) && placed.PlacedAt < winner.PlacedAt {
+			winner = &placed
+			winnerSalt = salt
+		}
+	}
+
+	pic, err := getPicture(stub, a.Picture)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// a picture reported stolen during the auction is not sold, see stolen.go
+	flag, err := releasePicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	} else if flag != nil {
+		winner = nil
+	}
+	if winner != nil {
+		// hand the picture over exactly as an accepted sale offer does
+		err = changeOwner(stub, pic, winner.Bidder)
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, err = recordSale(stub, a.Picture, a.Seller, winner.Bidder, winner.Amount, a.Currency, winnerSalt)
+		if err != nil {
+			return errorResponse(err)
+		}
+		a.Winner = &winner.Bidder
+	} else {
+		err = putPicture(stub, pic)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	a.Status = auctionClosed
+	if lapsed {
+		a.Status = auctionLapsed
+	}
+	err = putAuction(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventAuctionClosed, []string{a.Picture}, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end closeAuction (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// cancelAuction - the seller withdraws an auction nobody has bid in yet
+// ===========================================================================================
+func (t *SimpleChaincode) cancelAuction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "auctionID"
+	a, err := getAuction(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if a.Status != auctionOpen {
+		return errorResponse(newError(codeConflict, "auctionId", "Auction %s is %s", a.ID, a.Status))
+	}
+	err = checkIdentityOrAdmin(stub, a.Seller)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(a.Bids) > 0 {
+		return errorResponse(newError(codeConflict, "auctionId", "Auction %s already has bids and cannot be cancelled", a.ID))
+	}
+
+	pic, err := getPicture(stub, a.Picture)
+	if err != nil {
+		return errorResponse(err)
+	}
+	_, err = releasePicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	a.Status = auctionCancelled
+	err = putAuction(stub, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventAuctionCancelled, []string{a.Picture}, a)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// readAuction - read an auction. Sealed bids only show their commitment until revealed.
+// ===========================================================================================
+func (t *SimpleChaincode) readAuction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "auctionID"
+	a, err := getAuction(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	auctionJSONasBytes, err := json.Marshal(a)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(auctionJSONasBytes)
+}
+
+// ===========================================================================================
+// readAuctionBids - read the revealed amounts of the sealed bids of an auction held by the
+// organisation of the caller: every bid for the seller's, its own bids for a bidder's. The
+// amounts of english bids are in the auction.
+// ===========================================================================================
+func (t *SimpleChaincode) readAuctionBids(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "auctionID"
+	a, err := getAuction(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	bids := []privateBid{}
+	for _, placed := range a.Bids {
+		if placed.BidHash == "" || (caller.MSPID != a.Seller.MSPID && caller.MSPID != placed.Bidder.MSPID) {
+			continue
+		}
+		b, err := getPrivateBid(stub, caller.MSPID, a, placed)
+		if err != nil {
+			return errorResponse(err)
+		} else if b != nil {
+			bids = append(bids, *b)
+		}
+	}
+
+	bidsJSONasBytes, err := json.Marshal(bids)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(bidsJSONasBytes)
+}
@@ -0,0 +1,356 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func deadline(d time.Duration) string {
+	return time.Now().Add(d).UTC().Format(time.RFC3339)
+}
+
+func readTestAuction(t *testing.T, stub *shim.MockStub, auctionID string) *auction {
+	t.Helper()
+	a := &auction{}
+	err := json.Unmarshal(checkInvoke(t, stub, louvreUser, "readAuction", auctionID), a)
+	if err != nil {
+		t.Fatal("Failed to decode auction:", err)
+	}
+	return a
+}
+
+// bidTransient is the bid transient field of revealBid
+func bidTransient(amount, salt string) map[string]string {
+	return map[string]string{transientBid: fmt.Sprintf(`{"amount":%q,"salt":%q}`, amount, salt)}
+}
+
+// readTestBids reads the revealed bid amounts of an auction held by the organisation of the caller
+func readTestBids(t *testing.T, stub *shim.MockStub, caller testClient, auctionID string) string {
+	t.Helper()
+	bids := []privateBid{}
+	err := json.Unmarshal(checkInvoke(t, stub, caller, "readAuctionBids", auctionID), &bids)
+	if err != nil {
+		t.Fatal("Failed to decode bids:", err)
+	}
+	summary := []string{}
+	for _, b := range bids {
+		summary = append(summary, b.Bidder.MSPID+":"+b.Amount)
+	}
+	return strings.Join(summary, ",")
+}
+
+// checkNoAmount fails if an auction, as read or in an event, shows a bid amount
+func checkNoAmount(t *testing.T, auctionJSON []byte, amounts ...string) {
+	t.Helper()
+	for _, amount := range amounts {
+		if strings.Contains(string(auctionJSON), amount) {
+			t.Fatalf("Bid amount %s is public: %s", amount, auctionJSON)
+		}
+	}
+}
+
+// endBidding moves the bidding deadline of an auction in the past, leaving the reveal
+// period of sealed auctions open
+func endBidding(t *testing.T, stub *shim.MockStub, auctionID string) {
+	t.Helper()
+	err := inTransaction(stub, func() error {
+		a, err := getAuction(stub, auctionID)
+		if err != nil {
+			return err
+		}
+		a.BiddingEnds = deadline(-time.Hour)
+		return putAuction(stub, a)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// endReveal moves the reveal deadline of a sealed auction in the past
+func endReveal(t *testing.T, stub *shim.MockStub, auctionID string) {
+	t.Helper()
+	moveReveal(t, stub, auctionID, -time.Minute)
+}
+
+// endSettlement moves the reveal deadline of a sealed auction before the settlement period,
+// after which anyone may close it
+func endSettlement(t *testing.T, stub *shim.MockStub, auctionID string) {
+	t.Helper()
+	moveReveal(t, stub, auctionID, -auctionSettlementPeriod-time.Minute)
+}
+
+func moveReveal(t *testing.T, stub *shim.MockStub, auctionID string, d time.Duration) {
+	t.Helper()
+	err := inTransaction(stub, func() error {
+		a, err := getAuction(stub, auctionID)
+		if err != nil {
+			return err
+		}
+		a.BiddingEnds = deadline(d - time.Hour)
+		a.RevealEnds = deadline(d)
+		return putAuction(stub, a)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateAuctionErrors(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	ends := deadline(time.Hour)
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+	}{
+		{"too few arguments", louvreUser, []string{"picture1", "english", "EUR", "100.00"}, "Incorrect number of arguments. Expecting 5 or 6"},
+		{"invalid reserve", louvreUser, []string{"picture1", "english", "EUR", "100.001", ends}, "Argument 4 (reservePrice) must be a decimal amount"},
+		{"invalid deadline", louvreUser, []string{"picture1", "english", "EUR", "100.00", "tomorrow"}, "Argument 5 (biddingEnds) must be an RFC3339 timestamp"},
+		{"past deadline", louvreUser, []string{"picture1", "english", "EUR", "100.00", deadline(-time.Hour)}, "5th argument must be in the future"},
+		{"english with reveal deadline", louvreUser, []string{"picture1", "english", "EUR", "100.00", ends, ends}, "Expecting 5 for an english auction"},
+		{"sealed without reveal deadline", louvreUser, []string{"picture1", "sealed", "EUR", "100.00", ends}, "Expecting 6 for a sealed auction"},
+		{"reveal before bidding ends", louvreUser, []string{"picture1", "sealed", "EUR", "100.00", ends, ends}, "6th argument must be after the bidding deadline"},
+		{"unknown type", louvreUser, []string{"picture1", "dutch", "EUR", "100.00", ends}, "2nd argument must be english or sealed"},
+		{"missing picture", louvreUser, []string{"picture2", "english", "EUR", "100.00", ends}, "Picture does not exist: picture2"},
+		{"not the owner", guggenheimUser, []string{"picture1", "english", "EUR", "100.00", ends}, "Not allowed to manage picture picture1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkInvokeError(t, stub, test.caller, test.message, append([]string{"createAuction"}, test.args...)...)
+		})
+	}
+}
+
+func TestEnglishAuction(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "english", "EUR", "100.00", deadline(time.Hour)))
+	if event := lastEvent(t, stub); event.Type != eventAuctionCreated {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	// the picture is off the market and the pending offer withdrawn
+	if status := checkPicture(t, stub, "picture1").Status; status != statusAtAuction {
+		t.Fatalf("Unexpected status %s", status)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "No pending transfer offer for picture: picture1", "acceptTransfer", "picture1")
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available", "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available", "createAuction", "picture1", "english", "EUR", "100.00", deadline(time.Hour))
+
+	checkInvokeError(t, stub, guggenheimUser, "Incorrect number of arguments. Expecting 2", "placeBid", auctionID)
+	checkInvokeError(t, stub, guggenheimUser, "Auction does not exist: unknown", "placeBid", "unknown", "120.00")
+	checkInvokeError(t, stub, louvreUser, "The seller cannot bid in its own auction", "placeBid", auctionID, "120.00")
+	checkInvokeError(t, stub, guggenheimUser, "Bid is below the reserve price of 100.00 EUR", "placeBid", auctionID, "99.99")
+	checkInvokeError(t, stub, guggenheimUser, "Argument 2 (amount) must be a decimal amount", "placeBid", auctionID, "cheap")
+	checkInvokeError(t, stub, guggenheimUser, "is not a sealed auction", "commitBid", auctionID, bidCommitment("120.00", "salt"))
+
+	checkInvoke(t, stub, guggenheimUser, "placeBid", auctionID, "120.00")
+	if event := lastEvent(t, stub); event.Type != eventBidPlaced {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	// every bid must beat the highest one, which every bidder sees
+	checkInvokeError(t, stub, louvreAdmin, "Bid must be higher than 120.00 EUR", "placeBid", auctionID, "111.11")
+	e := checkInvokeError(t, stub, louvreAdmin, "Bid must be higher than 120.00 EUR", "placeBid", auctionID, "120")
+	if e.Code != codeInvalidArgument || e.Field != "amount" {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	checkInvoke(t, stub, louvreAdmin, "placeBid", auctionID, "150.00")
+	checkInvoke(t, stub, guggenheimUser, "placeBid", auctionID, "200.50")
+	a := readTestAuction(t, stub, auctionID)
+	if len(a.Bids) != 3 || a.Bids[1].Bidder != louvreAdmin.id || a.Bids[2].Amount != "200.50" {
+		t.Fatalf("Unexpected bids %+v", a.Bids)
+	}
+
+	checkInvokeError(t, stub, louvreUser, "already has bids and cannot be cancelled", "cancelAuction", auctionID)
+	checkInvokeError(t, stub, louvreUser, "cannot be closed before", "closeAuction", auctionID)
+
+	endBidding(t, stub, auctionID)
+	checkInvokeError(t, stub, louvreAdmin, "Bidding for auction "+auctionID+" ended", "placeBid", auctionID, "300.00")
+	// any gallery member closes an english auction, its bids being public
+	checkInvoke(t, stub, guggenheimUser, "closeAuction", auctionID)
+	if event := lastEvent(t, stub); event.Type != eventAuctionClosed {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Owner != guggenheimUser.id || pic.Status != statusAvailable {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	a = readTestAuction(t, stub, auctionID)
+	if a.Status != auctionClosed || a.Winner == nil || *a.Winner != guggenheimUser.id {
+		t.Fatalf("Unexpected auction %+v", a)
+	}
+	sales := readSales(t, stub, "getSalesForPicture", "picture1")
+	if len(sales) != 1 || sales[0].Buyer != guggenheimUser.id || readTestSalePrice(t, stub, guggenheimUser, sales[0]).Price != "200.50" {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	checkInvokeError(t, stub, louvreUser, "Auction "+auctionID+" is closed", "closeAuction", auctionID)
+}
+
+func TestSealedAuctionTie(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "sealed", "EUR", "100.00", deadline(time.Hour), deadline(2*time.Hour)))
+	checkInvoke(t, stub, guggenheimUser, "commitBid", auctionID, bidCommitment("150.00", "3b9e1f0c7d24a685"))
+	checkInvoke(t, stub, louvreAdmin, "commitBid", auctionID, bidCommitment("150", "a07c52e91d4f36b8"))
+	endBidding(t, stub, auctionID)
+	checkTransientInvoke(t, stub, louvreAdmin, bidTransient("150", "a07c52e91d4f36b8"), "revealBid", auctionID)
+	checkTransientInvoke(t, stub, guggenheimUser, bidTransient("150.00", "3b9e1f0c7d24a685"), "revealBid", auctionID)
+
+	// the earliest of equal bids wins
+	endReveal(t, stub, auctionID)
+	checkInvoke(t, stub, louvreUser, "closeAuction", auctionID)
+	if a := readTestAuction(t, stub, auctionID); a.Winner == nil || *a.Winner != guggenheimUser.id {
+		t.Fatalf("Unexpected auction %+v", a)
+	}
+}
+
+func TestAuctionWithoutWinner(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "english", "EUR", "100.00", deadline(time.Hour)))
+	endBidding(t, stub, auctionID)
+	checkInvoke(t, stub, louvreAdmin, "closeAuction", auctionID)
+
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Owner != louvreUser.id || pic.Status != statusAvailable {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if a := readTestAuction(t, stub, auctionID); a.Status != auctionClosed || a.Winner != nil {
+		t.Fatalf("Unexpected auction %+v", a)
+	}
+}
+
+func TestSealedAuction(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "sealed", "EUR", "100.00", deadline(time.Hour), deadline(2*time.Hour)))
+
+	checkInvokeError(t, stub, guggenheimUser, "Incorrect number of arguments. Expecting 2", "commitBid", auctionID)
+	checkInvokeError(t, stub, guggenheimUser, "Argument 2 (commitment) must be a hex encoded SHA-256 hash", "commitBid", auctionID, "150.00")
+	checkInvokeError(t, stub, guggenheimUser, "is not a english auction", "placeBid", auctionID, "150.00")
+
+	// a bidder may replace its commitment while bidding is open
+	checkInvoke(t, stub, guggenheimUser, "commitBid", auctionID, bidCommitment("120.00", "3b9e1f0c7d24a685"))
+	checkInvoke(t, stub, guggenheimUser, "commitBid", auctionID, bidCommitment("300.00", "a07c52e91d4f36b8"))
+	if event := lastEvent(t, stub); event.Type != eventBidCommitted {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	checkInvoke(t, stub, louvreAdmin, "commitBid", auctionID, bidCommitment("250.00", "6d1f08b3e5a92c47"))
+	checkInvoke(t, stub, guggenheimAdmin, "commitBid", auctionID, bidCommitment("400.00", "e82c4a1f9b07d356"))
+	a := readTestAuction(t, stub, auctionID)
+	if len(a.Bids) != 3 || a.Bids[0].BidHash != "" {
+		t.Fatalf("Unexpected bids %+v", a.Bids)
+	}
+
+	checkInvokeError(t, stub, guggenheimUser, "Incorrect number of arguments. Expecting 1", "revealBid", auctionID, "300.00", "second")
+	checkTransientInvokeError(t, stub, guggenheimUser, bidTransient("300.00", "a07c52e91d4f36b8"), "can be revealed from", "revealBid", auctionID)
+
+	endBidding(t, stub, auctionID)
+	checkInvokeError(t, stub, guggenheimUser, "Bidding for auction "+auctionID+" ended", "commitBid", auctionID, bidCommitment("500.00", "1c5d9e3a7f20b864"))
+	checkInvokeError(t, stub, guggenheimUser, "The amount must be given in the bid transient field", "revealBid", auctionID)
+	e := checkTransientInvokeError(t, stub, guggenheimUser, bidTransient("300.00", "second"), "The bid transient field needs a random salt of at least 16 characters", "revealBid", auctionID)
+	if e.Code != codeInvalidArgument || e.Field != transientBid {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	checkTransientInvokeError(t, stub, guggenheimUser, bidTransient("120.00", "3b9e1f0c7d24a685"), "Amount and salt do not match the committed bid", "revealBid", auctionID)
+	checkTransientInvokeError(t, stub, louvreUser, bidTransient("300.00", "a07c52e91d4f36b8"), "No committed bid from", "revealBid", auctionID)
+	checkTransientInvoke(t, stub, guggenheimUser, bidTransient("300.00", "a07c52e91d4f36b8"), "revealBid", auctionID)
+	event := lastEvent(t, stub)
+	if event.Type != eventBidRevealed {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	detailsJSON, _ := json.Marshal(event.Details)
+	checkNoAmount(t, detailsJSON, "300.00")
+	checkTransientInvoke(t, stub, louvreAdmin, bidTransient("250.00", "6d1f08b3e5a92c47"), "revealBid", auctionID)
+	checkInvokeError(t, stub, louvreUser, "cannot be closed before", "closeAuction", auctionID)
+	// revealed amounts go to the seller, not to the ledger
+	if bids := readTestBids(t, stub, louvreUser, auctionID); bids != "GuggenheimMSP:300.00,LouvreMSP:250.00" {
+		t.Fatalf("Unexpected bids %s", bids)
+	}
+	if bids := readTestBids(t, stub, guggenheimAdmin, auctionID); bids != "GuggenheimMSP:300.00" {
+		t.Fatalf("Unexpected bids %s", bids)
+	}
+	checkInvokeError(t, stub, outsiderUser, "readAuctionBids is reserved to gallery members", "readAuctionBids", auctionID)
+	checkNoAmount(t, checkInvoke(t, stub, outsiderUser, "readAuction", auctionID), "300.00", "250.00")
+
+	// the highest unrevealed commitment cannot win
+	endReveal(t, stub, auctionID)
+	e = checkInvokeError(t, stub, guggenheimUser, "Auction "+auctionID+" is closed by members of LouvreMSP, which holds its bids, or by anyone once it lapses at", "closeAuction", auctionID)
+	if e.Code != codeForbidden {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	checkInvoke(t, stub, louvreUser, "closeAuction", auctionID)
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != guggenheimUser.id {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+	sales := readSales(t, stub, "getSalesForPicture", "picture1")
+	if len(sales) != 1 || readTestSalePrice(t, stub, guggenheimUser, sales[0]).Price != "300.00" {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+}
+
+func TestSealedAuctionLapse(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "sealed", "EUR", "100.00", deadline(time.Hour), deadline(2*time.Hour)))
+	checkInvoke(t, stub, guggenheimUser, "commitBid", auctionID, bidCommitment("300.00", "3b9e1f0c7d24a685"))
+	endBidding(t, stub, auctionID)
+	checkTransientInvoke(t, stub, guggenheimUser, bidTransient("300.00", "3b9e1f0c7d24a685"), "revealBid", auctionID)
+
+	// the seller never closes, so the auction lapses without a sale
+	endSettlement(t, stub, auctionID)
+	checkInvoke(t, stub, guggenheimUser, "closeAuction", auctionID)
+	if event := lastEvent(t, stub); event.Type != eventAuctionClosed {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Owner != louvreUser.id || pic.Status != statusAvailable {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if a := readTestAuction(t, stub, auctionID); a.Status != auctionLapsed || a.Winner != nil {
+		t.Fatalf("Unexpected auction %+v", a)
+	}
+	if sales := readSales(t, stub, "getSalesForPicture", "picture1"); len(sales) != 0 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	checkInvokeError(t, stub, louvreUser, "Auction "+auctionID+" is lapsed", "closeAuction", auctionID)
+}
+
+func TestCancelAuction(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "english", "EUR", "100.00", deadline(time.Hour)))
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "cancelAuction")
+	checkInvokeError(t, stub, guggenheimUser, "is not allowed to act on behalf of", "cancelAuction", auctionID)
+	checkInvoke(t, stub, louvreAdmin, "cancelAuction", auctionID)
+
+	if event := lastEvent(t, stub); event.Type != eventAuctionCancelled {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	if status := checkPicture(t, stub, "picture1").Status; status != statusAvailable {
+		t.Fatalf("Unexpected status %s", status)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "Auction "+auctionID+" is cancelled", "placeBid", auctionID, "120.00")
+	checkInvokeError(t, stub, louvreUser, "Auction "+auctionID+" is cancelled", "cancelAuction", auctionID)
+}
+
+func TestReadAuction(t *testing.T) {
+	stub := newTestStub()
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments", "readAuction")
+	checkInvokeError(t, stub, louvreUser, "Auction does not exist: unknown", "readAuction", "unknown")
+}
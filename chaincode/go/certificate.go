@@ -0,0 +1,348 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Certificates of authenticity ====
+// An art expert, a client whose certificate carries the expertAttribute (see identity.go),
+// certifies a picture as described by its current record. The certificate is stored under a
+// composite key built from certificatePrefix and the ID of the issuing transaction, and keeps
+// a copy of the certified fields with their SHA-256 hash. Later changes to the record of the
+// picture therefore show when the certificate is verified, while a change of owner or status
+// does not affect it. Only the issuer, or an admin of its gallery, revokes a certificate;
+// the revocation is kept with its reason.
+//
+// picture~certificate lists the certificates of a picture. verifyCertificate reports every
+// problem it finds, so a paper certificate can be checked against the ledger:
+//
+//   - the certificate was revoked
+//   - it was not issued by the expected issuer, when one is given
+//   - the certified fields differ from the current record of the picture
+
+const certificatePrefix = "certificate"
+
+const pictureCertificateIndex = "picture~certificate" //picture name, certificate ID
+
+const (
+	certificateValid   = "valid"
+	certificateRevoked = "revoked"
+)
+
+// certifiedFields are the fields of a picture a certificate vouches for
+type certifiedFields struct {
+	Name            string `json:"name"`
+	ArtistID        string `json:"artistId"`
+	Title           string `json:"title"`
+	Year            int    `json:"year"`
+	Medium          string `json:"medium"`
+	Dimensions      string `json:"dimensions"`
+	InventoryNumber string `json:"inventoryNumber"`
+}
+
+type certificate struct {
+	ObjectType       string          `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID               string          `json:"id"`
+	Picture          string          `json:"picture"`
+	Issuer           identity        `json:"issuer"`
+	IssuedAt         string          `json:"issuedAt"`  //RFC3339 transaction timestamp
+	Statement        string          `json:"statement"` //opinion of the expert, e.g. the attribution and the examination made
+	Certified        certifiedFields `json:"certified"`
+	CertifiedHash    string          `json:"certifiedHash"` //hex SHA-256 of the JSON of certified
+	Status           string          `json:"status"`        //valid or revoked
+	RevokedBy        *identity       `json:"revokedBy,omitempty"`
+	RevokedAt        string          `json:"revokedAt,omitempty"`
+	RevocationReason string          `json:"revocationReason,omitempty"`
+}
+
+// certificateCheck is the result of verifyCertificate
+type certificateCheck struct {
+	Certificate   certificate `json:"certificate"`
+	PictureStatus string      `json:"pictureStatus"`
+	Valid         bool        `json:"valid"`
+	Problems      []string    `json:"problems"` //why the certificate is not valid, empty when it is
+}
+
+// certify returns the certified fields of a picture and their hash
+func certify(pic *picture) (certifiedFields, string, error) {
+	fields := certifiedFields{
+		Name:            pic.Name,
+		ArtistID:        pic.ArtistID,
+		Title:           pic.Title,
+		Year:            pic.Year,
+		Medium:          pic.Medium,
+		Dimensions:      pic.Dimensions,
+		InventoryNumber: pic.InventoryNumber,
+	}
+	fieldsJSONasBytes, err := json.Marshal(fields)
+	if err != nil {
+		return fields, "", err
+	}
+	hash := sha256.Sum256(fieldsJSONasBytes)
+	return fields, hex.EncodeToString(hash[:]), nil
+}
+
+func getCertificate(stub shim.ChaincodeStubInterface, certificateID string) (*certificate, error) {
+	certificateKey, err := stub.CreateCompositeKey(certificatePrefix, []string{certificateID})
+	if err != nil {
+		return nil, err
+	}
+	certificateAsBytes, err := stub.GetState(certificateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get certificate: %s", err)
+	} else if certificateAsBytes == nil {
+		return nil, newError(codeNotFound, "certificateId", "Certificate does not exist: %s", certificateID)
+	}
+
+	c := &certificate{}
+	err = json.Unmarshal(certificateAsBytes, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode certificate: %s", certificateID)
+	}
+	return c, nil
+}
+
+func putCertificate(stub shim.ChaincodeStubInterface, c *certificate) error {
+	certificateKey, err := stub.CreateCompositeKey(certificatePrefix, []string{c.ID})
+	if err != nil {
+		return err
+	}
+	certificateJSONasBytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(certificateKey, certificateJSONasBytes)
+}
+
+// ===========================================================================================
+// issueCertificate - an art expert certifies a picture as described by its current record.
+// The certificate ID is the ID of the transaction.
+// ===========================================================================================
+func (t *SimpleChaincode) issueCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0         1
+	// "name", "Autograph work, examined under UV and infrared in June 2018"
+	pictureName := args[0]
+	fmt.Println("- start issueCertificate ", pictureName)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	c := &certificate{
+		ObjectType: "certificate",
+		ID:         stub.GetTxID(),
+		Picture:    pictureName,
+		Issuer:     caller,
+		IssuedAt:   now.Format(time.RFC3339),
+		Statement:  strings.TrimSpace(args[1]),
+		Status:     certificateValid,
+	}
+	c.Certified, c.CertifiedHash, err = certify(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putCertificate(stub, c)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pictureIndexKey, err := stub.CreateCompositeKey(pictureCertificateIndex, []string{pictureName, c.ID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.PutState(pictureIndexKey, []byte{0x00})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventCertificateIssued, []string{pictureName}, c)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end issueCertificate (success)")
+	return shim.Success([]byte(c.ID))
+}
+
+// ===========================================================================================
+// revokeCertificate - the issuer of a certificate, or an admin of its gallery, withdraws
+// it with the reason for it
+// ===========================================================================================
+func (t *SimpleChaincode) revokeCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                1
+	// "certificateId", "New technical examination dates the canvas after 1926"
+	certificateID := args[0]
+	fmt.Println("- start revokeCertificate ", certificateID)
+
+	c, err := getCertificate(stub, certificateID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkIdentityOrAdmin(stub, c.Issuer)
+	if err != nil {
+		return errorResponse(newError(codeForbidden, "", "Not allowed to revoke certificate %s: %s", certificateID, err))
+	}
+	if c.Status == certificateRevoked {
+		return errorResponse(newError(codeConflict, "certificateId", "Certificate %s is already revoked", certificateID))
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	c.Status = certificateRevoked
+	c.RevokedBy = &caller
+	c.RevokedAt = now.Format(time.RFC3339)
+	c.RevocationReason = strings.TrimSpace(args[1])
+	err = putCertificate(stub, c)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventCertificateRevoked, []string{c.Picture}, c)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end revokeCertificate (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// getCertificates - list the certificates of a picture, revoked ones included
+// ===========================================================================================
+func (t *SimpleChaincode) getCertificates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	_, err := getPicture(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(pictureCertificateIndex, []string{args[0]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	certificates := []certificate{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		c, err := getCertificate(stub, compositeKeyParts[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		certificates = append(certificates, *c)
+	}
+
+	certificatesJSONasBytes, err := json.Marshal(certificates)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(certificatesJSONasBytes)
+}
+
+// ===========================================================================================
+// verifyCertificate - check a certificate is still valid: not revoked, issued by the expected
+// issuer when one is given, and vouching for the picture as currently recorded
+// ===========================================================================================
+func (t *SimpleChaincode) verifyCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                1                       2
+	// "certificateId", "LouvreMSP" (optional), "CN=Expert1@louvre..." (optional)
+	c, err := getCertificate(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	pic, err := getPicture(stub, c.Picture)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	problems := []string{}
+	if c.Status == certificateRevoked {
+		problems = append(problems, fmt.Sprintf("Revoked on %s: %s", c.RevokedAt, c.RevocationReason))
+	}
+	if len(args) > 1 && args[1] != "" && args[1] != c.Issuer.MSPID {
+		problems = append(problems, fmt.Sprintf("Issued by a member of %s, not %s", c.Issuer.MSPID, args[1]))
+	}
+	if len(args) > 2 && args[2] != "" && args[2] != c.Issuer.Subject {
+		problems = append(problems, fmt.Sprintf("Issued by %s, not %s", c.Issuer.Subject, args[2]))
+	}
+	current, currentHash, err := certify(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if currentHash != c.CertifiedHash {
+		problems = append(problems, "The picture record changed since the certificate was issued: "+strings.Join(changedFields(c.Certified, current), ", "))
+	}
+
+	check := certificateCheck{
+		Certificate:   *c,
+		PictureStatus: pic.Status,
+		Valid:         len(problems) == 0,
+		Problems:      problems,
+	}
+	checkJSONasBytes, err := json.Marshal(check)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(checkJSONasBytes)
+}
+
+// changedFields lists the JSON names of the certified fields that differ
+func changedFields(certified, current certifiedFields) []string {
+	changed := []string{}
+	if certified.ArtistID != current.ArtistID {
+		changed = append(changed, "artistId")
+	}
+	if certified.Title != current.Title {
+		changed = append(changed, "title")
+	}
+	if certified.Year != current.Year {
+		changed = append(changed, "year")
+	}
+	if certified.Medium != current.Medium {
+		changed = append(changed, "medium")
+	}
+	if certified.Dimensions != current.Dimensions {
+		changed = append(changed, "dimensions")
+	}
+	if certified.InventoryNumber != current.InventoryNumber {
+		changed = append(changed, "inventoryNumber")
+	}
+	return changed
+}
@@ -0,0 +1,138 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var (
+	louvreExpert      = newTestClientWithAttributes("LouvreMSP", "Expert1@louvre.artgalleries.com", "client", map[string]string{expertAttribute: "true"})
+	independentExpert = newTestClientWithAttributes("OutsiderMSP", "Expert1@outsider.example.com", "client", map[string]string{expertAttribute: "true"})
+)
+
+func readCertificateCheck(t *testing.T, stub *shim.MockStub, args ...string) certificateCheck {
+	t.Helper()
+	check := certificateCheck{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, append([]string{"verifyCertificate"}, args...)...), &check)
+	if err != nil {
+		t.Fatal("Failed to decode certificate check:", err)
+	}
+	return check
+}
+
+func TestIssueCertificate(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	certificateID := string(checkInvoke(t, stub, louvreExpert, "issueCertificate", "picture1", " Autograph work, examined under UV and infrared "))
+	event := lastEvent(t, stub)
+	if event.Type != eventCertificateIssued || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	// experts of any organisation may certify any picture
+	checkInvoke(t, stub, independentExpert, "issueCertificate", "picture1", "Autograph work")
+
+	certificates := []certificate{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "getCertificates", "picture1"), &certificates)
+	if err != nil || len(certificates) != 2 {
+		t.Fatalf("Unexpected certificates %+v (%v)", certificates, err)
+	}
+	for _, c := range certificates {
+		if c.ID == certificateID && (c.Issuer != louvreExpert.id || c.Status != certificateValid || c.Statement != "Autograph work, examined under UV and infrared" || c.Certified.Title != "Water Lilies" || c.Certified.ArtistID != "claude-monet" || c.CertifiedHash == "") {
+			t.Fatalf("Unexpected certificate %+v", c)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+		code    string
+	}{
+		{"not an expert", louvreUser, []string{"picture1", "Autograph work"}, "issueCertificate is reserved to art experts", codeForbidden},
+		{"admin without the attribute", louvreAdmin, []string{"picture1", "Autograph work"}, "has no artExpert attribute", codeForbidden},
+		{"missing picture", louvreExpert, []string{"picture2", "Autograph work"}, "Picture does not exist: picture2", codeNotFound},
+		{"empty statement", louvreExpert, []string{"picture1", " "}, "Argument 2 (statement) must be a non-empty string", codeInvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, test.message, append([]string{"issueCertificate"}, test.args...)...)
+			if e.Code != test.code {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+	checkInvokeError(t, stub, outsiderUser, "Picture does not exist: picture2", "getCertificates", "picture2")
+}
+
+func TestRevokeCertificate(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	certificateID := string(checkInvoke(t, stub, louvreExpert, "issueCertificate", "picture1", "Autograph work"))
+
+	checkInvokeError(t, stub, guggenheimAdmin, "Not allowed to revoke certificate "+certificateID, "revokeCertificate", certificateID, "Doubts")
+	checkInvokeError(t, stub, independentExpert, "Not allowed to revoke certificate "+certificateID, "revokeCertificate", certificateID, "Doubts")
+	checkInvokeError(t, stub, louvreUser, "Certificate does not exist: missing", "revokeCertificate", "missing", "Doubts")
+
+	// an admin of the gallery of the issuer may revoke it too
+	checkInvoke(t, stub, louvreAdmin, "revokeCertificate", certificateID, " New examination dates the canvas after 1926 ")
+	event := lastEvent(t, stub)
+	if event.Type != eventCertificateRevoked || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	check := readCertificateCheck(t, stub, certificateID)
+	c := check.Certificate
+	if c.Status != certificateRevoked || *c.RevokedBy != louvreAdmin.id || c.RevokedAt == "" || c.RevocationReason != "New examination dates the canvas after 1926" {
+		t.Fatalf("Unexpected certificate %+v", c)
+	}
+	if check.Valid || len(check.Problems) != 1 || !strings.HasPrefix(check.Problems[0], "Revoked on ") {
+		t.Fatalf("Unexpected check %+v", check)
+	}
+
+	e := checkInvokeError(t, stub, louvreExpert, "Certificate "+certificateID+" is already revoked", "revokeCertificate", certificateID, "Doubts")
+	if e.Code != codeConflict {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	certificateID := string(checkInvoke(t, stub, louvreExpert, "issueCertificate", "picture1", "Autograph work"))
+
+	// a change of owner or status leaves the certificate valid
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvoke(t, stub, guggenheimUser, "retirePicture", "picture1", statusLost, "Missing since the move")
+	check := readCertificateCheck(t, stub, certificateID)
+	if !check.Valid || len(check.Problems) != 0 || check.PictureStatus != statusLost {
+		t.Fatalf("Unexpected check %+v", check)
+	}
+	if check := readCertificateCheck(t, stub, certificateID, louvreExpert.id.MSPID, louvreExpert.id.Subject); !check.Valid {
+		t.Fatalf("Unexpected check %+v", check)
+	}
+	if check := readCertificateCheck(t, stub, certificateID, "", louvreExpert.id.Subject); !check.Valid {
+		t.Fatalf("Unexpected check %+v", check)
+	}
+
+	// a certificate presented as issued by someone else
+	check = readCertificateCheck(t, stub, certificateID, independentExpert.id.MSPID, independentExpert.id.Subject)
+	if check.Valid || len(check.Problems) != 2 || check.Problems[0] != "Issued by a member of LouvreMSP, not OutsiderMSP" {
+		t.Fatalf("Unexpected check %+v", check)
+	}
+
+	// the certificate refers to the artist by ID, renaming the artist leaves it valid
+	checkInvoke(t, stub, louvreUser, "updateArtist", "claude-monet", "Oscar-Claude Monet")
+	if check := readCertificateCheck(t, stub, certificateID); !check.Valid {
+		t.Fatalf("Unexpected check %+v", check)
+	}
+
+	checkInvokeError(t, stub, outsiderUser, "Certificate does not exist: missing", "verifyCertificate", "missing")
+}
@@ -0,0 +1,172 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Condition reports ====
+// Conservators examine a work every time it moves and record a condition report: the date of
+// the examination, a condition grade, notes, and optionally the SHA-256 hash of the full
+// report document kept off the ledger. Reports are only ever added. Each one is stored under
+// a composite key built from conditionReportPrefix, the picture name, the examination date
+// and the ID of the transaction, so the reports of a picture are read in date order.
+//
+// Members of the owning organisation, or of the custodian's while the work is on loan, add
+// reports, the caller being recorded as the conservator. The grade and date of the latest
+// examination are copied on the picture, so readPicture shows its current condition.
+
+const conditionReportPrefix = "condition"
+
+// condition grades, from best to worst
+const (
+	conditionExcellent = "excellent"
+	conditionGood      = "good"
+	conditionFair      = "fair"
+	conditionPoor      = "poor"
+)
+
+var conditionGrades = map[string]bool{
+	conditionExcellent: true,
+	conditionGood:      true,
+	conditionFair:      true,
+	conditionPoor:      true,
+}
+
+type conditionReport struct {
+	ObjectType   string   `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Picture      string   `json:"picture"`
+	TxID         string   `json:"txId"`
+	Date         string   `json:"date"` //YYYY-MM-DD of the examination
+	Conservator  identity `json:"conservator"`
+	Grade        string   `json:"grade"`
+	Notes        string   `json:"notes,omitempty"`
+	DocumentHash string   `json:"documentHash,omitempty"` //hex SHA-256 of the full report document
+	RecordedAt   string   `json:"recordedAt"`             //RFC3339 transaction timestamp
+}
+
+// ===========================================================================================
+// addConditionReport - a conservator of the owner, or of the custodian, records the condition
+// of a picture
+// ===========================================================================================
+func (t *SimpleChaincode) addConditionReport(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0             1        2         3                                     4
+	// "name", "2018-09-03", "good", "Slight craquelure, lower left corner", "<hex sha256>" (optional)
+	pictureName := args[0]
+	grade := args[2]
+	if !conditionGrades[grade] {
+		return errorResponse(newError(codeInvalidArgument, "grade", "Unknown condition grade: %s", grade))
+	}
+	fmt.Println("- start addConditionReport ", pictureName, args[1], grade)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !isHolder(caller, pic) {
+		return errorResponse(newError(codeForbidden, "", "Condition reports of picture %s are reserved to its owner and custodian", pictureName))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if args[1] > now.Format(loanDateLayout) {
+		return errorResponse(newError(codeInvalidArgument, "date", "The examination date cannot be in the future: %s", args[1]))
+	}
+
+	report := &conditionReport{
+		ObjectType:  "conditionReport",
+		Picture:     pictureName,
+		TxID:        stub.GetTxID(),
+		Date:        args[1],
+		Conservator: caller,
+		Grade:       grade,
+		Notes:       strings.TrimSpace(args[3]),
+		RecordedAt:  now.Format(time.RFC3339),
+	}
+	if len(args) > 4 {
+		report.DocumentHash = strings.ToLower(args[4])
+	}
+	reportKey, err := stub.CreateCompositeKey(conditionReportPrefix, []string{pictureName, report.Date, report.TxID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	reportJSONasBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.PutState(reportKey, reportJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// a report on an earlier examination leaves the current condition as it is
+	if report.Date >= pic.ConditionDate {
+		pic.ConditionGrade = report.Grade
+		pic.ConditionDate = report.Date
+		err = putPicture(stub, pic)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	err = emitEvent(stub, eventConditionReportAdded, []string{pictureName}, report)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end addConditionReport (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// getConditionReports - list the condition reports of a picture by examination date
+// ===========================================================================================
+func (t *SimpleChaincode) getConditionReports(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	_, err := getPicture(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(conditionReportPrefix, []string{args[0]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	reports := []conditionReport{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		report := conditionReport{}
+		err = json.Unmarshal(responseRange.Value, &report)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Failed to decode condition report: %s", responseRange.Key))
+		}
+		reports = append(reports, report)
+	}
+
+	reportsJSONasBytes, err := json.Marshal(reports)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(reportsJSONasBytes)
+}
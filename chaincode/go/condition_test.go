@@ -0,0 +1,118 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func readConditionReports(t *testing.T, stub *shim.MockStub, name string) []conditionReport {
+	t.Helper()
+	reports := []conditionReport{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "getConditionReports", name), &reports)
+	if err != nil {
+		t.Fatal("Failed to decode condition reports:", err)
+	}
+	return reports
+}
+
+func TestAddConditionReport(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	documentHash := imageHash("condition report 2018")
+
+	checkInvoke(t, stub, louvreUser, "addConditionReport", "picture1", loanDate(-10), conditionGood, " Slight craquelure, lower left corner ", strings.ToUpper(documentHash))
+	event := lastEvent(t, stub)
+	if event.Type != eventConditionReportAdded || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	if pic := checkPicture(t, stub, "picture1"); pic.ConditionGrade != conditionGood || pic.ConditionDate != loanDate(-10) {
+		t.Fatalf("Unexpected condition %s on %s", pic.ConditionGrade, pic.ConditionDate)
+	}
+
+	// a report on an earlier examination is kept in date order, the latest grade stays
+	checkInvoke(t, stub, louvreAdmin, "addConditionReport", "picture1", loanDate(-400), conditionFair, "")
+	if pic := checkPicture(t, stub, "picture1"); pic.ConditionGrade != conditionGood {
+		t.Fatalf("Unexpected condition %s", pic.ConditionGrade)
+	}
+	checkInvoke(t, stub, louvreUser, "addConditionReport", "picture1", loanDate(0), conditionPoor, "Water damage after the storm")
+	if pic := checkPicture(t, stub, "picture1"); pic.ConditionGrade != conditionPoor || pic.ConditionDate != loanDate(0) {
+		t.Fatalf("Unexpected condition %s on %s", pic.ConditionGrade, pic.ConditionDate)
+	}
+
+	reports := readConditionReports(t, stub, "picture1")
+	grades := []string{}
+	for _, r := range reports {
+		grades = append(grades, r.Grade)
+	}
+	if strings.Join(grades, ",") != "fair,good,poor" {
+		t.Fatalf("Unexpected reports %+v", reports)
+	}
+	if r := reports[1]; r.Conservator != louvreUser.id || r.Notes != "Slight craquelure, lower left corner" || r.DocumentHash != documentHash || r.RecordedAt == "" || r.TxID == "" {
+		t.Fatalf("Unexpected report %+v", r)
+	}
+	if r := reports[0]; r.Conservator != louvreAdmin.id || r.Notes != "" || r.DocumentHash != "" {
+		t.Fatalf("Unexpected report %+v", r)
+	}
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+		code    string
+		field   string
+	}{
+		{"unknown grade", louvreUser, []string{"picture1", loanDate(0), "mint", ""}, "Unknown condition grade: mint", codeInvalidArgument, "grade"},
+		{"future date", louvreUser, []string{"picture1", loanDate(2), conditionGood, ""}, "The examination date cannot be in the future", codeInvalidArgument, "date"},
+		{"invalid date", louvreUser, []string{"picture1", "03/09/2018", conditionGood, ""}, "Argument 2 (date) must be a date formatted as YYYY-MM-DD", codeInvalidArgument, "date"},
+		{"invalid document hash", louvreUser, []string{"picture1", loanDate(0), conditionGood, "", "report.pdf"}, "Argument 5 (documentHash) must be a hex encoded SHA-256 hash", codeInvalidArgument, "documentHash"},
+		{"other organisation", guggenheimUser, []string{"picture1", loanDate(0), conditionGood, ""}, "Condition reports of picture picture1 are reserved to its owner and custodian", codeForbidden, ""},
+		{"missing picture", louvreUser, []string{"picture2", loanDate(0), conditionGood, ""}, "Picture does not exist: picture2", codeNotFound, "name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, test.message, append([]string{"addConditionReport"}, test.args...)...)
+			if e.Code != test.code || e.Field != test.field {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+	if reports := readConditionReports(t, stub, "picture1"); len(reports) != 3 {
+		t.Fatalf("Unexpected reports %+v", reports)
+	}
+	checkInvokeError(t, stub, outsiderUser, "Picture does not exist: picture2", "getConditionReports", "picture2")
+}
+
+func TestConditionReportsDuringLoan(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	checkInvoke(t, stub, louvreUser, "addConditionReport", "picture1", loanDate(0), conditionExcellent, "Before shipping")
+	checkInvoke(t, stub, louvreUser, "approveLoan", loanID)
+
+	// the borrower reports on arrival, the owner keeps reporting too
+	checkInvoke(t, stub, guggenheimUser, "addConditionReport", "picture1", loanDate(0), conditionGood, "Scratch on the frame after transport")
+	if pic := checkPicture(t, stub, "picture1"); pic.ConditionGrade != conditionGood || pic.Custodian == nil {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	checkInvoke(t, stub, louvreAdmin, "addConditionReport", "picture1", loanDate(0), conditionGood, "Checked on site")
+
+	checkInvoke(t, stub, louvreUser, "recordReturn", loanID)
+	checkInvokeError(t, stub, guggenheimUser, "reserved to its owner and custodian", "addConditionReport", "picture1", loanDate(0), conditionGood, "")
+	// reports of the same day are ordered by transaction ID
+	conservators := map[identity]bool{}
+	reports := readConditionReports(t, stub, "picture1")
+	for _, r := range reports {
+		conservators[r.Conservator] = true
+	}
+	if len(reports) != 3 || !conservators[guggenheimUser.id] || !conservators[louvreAdmin.id] {
+		t.Fatalf("Unexpected reports %+v", reports)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Error responses ====
+// The message of every error response is a JSON envelope, so clients can branch on a stable
+// code instead of matching the text, which is meant for people and may change:
+//
+//   {"code":"NOT_FOUND","message":"Picture does not exist: picture1","field":"name"}
+//
+// field names the argument at fault, as declared in registry.go, and is omitted when the
+// error is not about a single argument. details is an object some errors add for the client
+// application, e.g. the alert of a refused transfer of a stolen picture, see stolen.go.
+// Failures of the ledger itself are reported as INTERNAL, the transaction may succeed when
+// submitted again.
+
+const (
+	codeNotFound        = "NOT_FOUND"        //the picture, offer, auction, ... does not exist
+	codeAlreadyExists   = "ALREADY_EXISTS"   //the record to create already exists
+	codeInvalidArgument = "INVALID_ARGUMENT" //an argument is missing, malformed or out of range
+	codeForbidden       = "FORBIDDEN"        //the caller may not perform the operation
+	codeConflict        = "CONFLICT"         //the records involved are not in a state allowing the operation
+	codeInternal        = "INTERNAL"         //reading or writing state failed
+)
+
+type chaincodeError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Field   string      `json:"field,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *chaincodeError) Error() string {
+	return e.Message
+}
+
+// newError formats the message of an error with a code, field may be empty
+func newError(code, field, format string, a ...interface{}) error {
+	return &chaincodeError{Code: code, Message: fmt.Sprintf(format, a...), Field: field}
+}
+
+// ===========================================================================================
+// errorResponse returns the error response of a handler. Errors not created by newError,
+// e.g. those returned by the stub, are reported as INTERNAL.
+// ===========================================================================================
+func errorResponse(err error) pb.Response {
+	e, ok := err.(*chaincodeError)
+	if !ok {
+		e = &chaincodeError{Code: codeInternal, Message: err.Error()}
+	}
+	errorJSONasBytes, jsonErr := json.Marshal(e)
+	if jsonErr != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Error(string(errorJSONasBytes))
+}
+
+// responseError decodes the error of a handler called by another one, e.g. to add context
+func responseError(response pb.Response) *chaincodeError {
+	e := &chaincodeError{}
+	err := json.Unmarshal([]byte(response.Message), e)
+	if err != nil || e.Code == "" {
+		return &chaincodeError{Code: codeInternal, Message: response.Message}
+	}
+	return e
+}
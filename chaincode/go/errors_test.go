@@ -0,0 +1,84 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestErrorCodes(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	createPicture(t, stub, louvreUser, "picture4", "blue")
+	checkInvoke(t, stub, louvreUser, "reportStolen", "picture4", "OCBC-2018-0412")
+
+	tests := []struct {
+		name   string
+		caller testClient
+		args   []string
+		code   string
+		field  string
+	}{
+		{"unknown function", louvreUser, []string{"paintPicture"}, codeInvalidArgument, ""},
+		{"argument count", louvreUser, []string{"readPicture"}, codeInvalidArgument, ""},
+		{"argument type", louvreUser, []string{"createAuction", "picture1", "english", "EUR", "cheap", "2030-01-01T00:00:00Z"}, codeInvalidArgument, "reservePrice"},
+		{"missing picture", louvreUser, []string{"readPicture", "picture3"}, codeNotFound, "name"},
+		{"missing picture to retire", louvreUser, []string{"retirePicture", "picture3", statusDestroyed, "Fire"}, codeNotFound, "name"},
+		{"missing artist", louvreUser, []string{"initPicture", "picture3", "blue", "35", "vincent-van-gogh", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5"}, codeNotFound, "artistId"},
+		{"missing offer", guggenheimUser, []string{"acceptTransfer", "picture1"}, codeNotFound, "name"},
+		{"existing picture", louvreUser, []string{"initPicture", "picture1", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5"}, codeAlreadyExists, "name"},
+		{"not the owner", guggenheimUser, []string{"retirePicture", "picture1", statusDestroyed, "Fire"}, codeForbidden, ""},
+		{"not a gallery", outsiderUser, []string{"initPicture", "picture3", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5"}, codeForbidden, ""},
+		{"pending offer", louvreUser, []string{"offerTransfer", "picture2", guggenheimAdmin.id.MSPID, guggenheimAdmin.id.Subject}, codeConflict, ""},
+		{"stolen picture offered", louvreUser, []string{"offerTransfer", "picture4", guggenheimUser.id.MSPID, guggenheimUser.id.Subject}, codeConflict, ""},
+		{"stolen picture transferred", louvreUser, []string{"transferPicture", "picture4", guggenheimUser.id.MSPID, guggenheimUser.id.Subject}, codeConflict, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, "", test.args...)
+			if e.Code != test.code || e.Field != test.field {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	response := errorResponse(newError(codeNotFound, "name", "Picture does not exist: %s", "picture1"))
+	if response.Status != shim.ERROR || response.Message != `{"code":"NOT_FOUND","message":"Picture does not exist: picture1","field":"name"}` {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+
+	// errors of the stub carry no code
+	response = errorResponse(errors.New("PutState failed"))
+	e := chaincodeError{}
+	err := json.Unmarshal([]byte(response.Message), &e)
+	if err != nil || e != (chaincodeError{Code: codeInternal, Message: "PutState failed"}) {
+		t.Fatalf("Unexpected error %s", response.Message)
+	}
+
+	if e := responseError(response); e.Code != codeInternal || e.Message != "PutState failed" {
+		t.Fatalf("Unexpected decoded error %+v", e)
+	}
+	if e := responseError(shim.Error("not an envelope")); e.Code != codeInternal || e.Message != "not an envelope" {
+		t.Fatalf("Unexpected decoded error %+v", e)
+	}
+}
+
+func TestTransferFailedErrorCode(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, guggenheimUser, "picture1", "red")
+
+	e := checkInvokeError(t, stub, louvreUser, "Transfer failed: Not allowed to manage picture picture1", "transferPicturesBasedOnGeneration", "red", "LouvreMSP", louvreUser.id.Subject)
+	if e.Code != codeForbidden {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
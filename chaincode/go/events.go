@@ -0,0 +1,158 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ==== Chaincode events ====
+// Every function that changes state emits one chaincode event, named after what happened,
+// so applications can listen for block events instead of polling readPicture. Fabric keeps
+// a single event per transaction, so functions that reuse others (e.g. the bulk transfer)
+// emit their own event last, replacing the ones set along the way.
+//
+// The payload of every event is a JSON object:
+//
+//	{
+//	  "type":      event name, e.g. "PictureTransferred",
+//	  "txId":      ID of the transaction,
+//	  "timestamp": RFC3339 transaction timestamp,
+//	  "actor":     {"mspId": ..., "subject": ...} of the submitting client,
+//	  "pictures":  names of the pictures affected, possibly empty,
+//	  "details":   event specific object, see below
+//	}
+//
+// Event names and their details:
+//
+//	PictureCreated        the new picture
+//	PictureTransferred    {"from": identity, "to": identity}
+//	PicturesTransferred   {"generation": ..., "to": identity}, pictures lists every picture moved
+//	PictureRetired        the retired picture, with its retirement status and reason
+//	PictureRecovered      the recovered picture, with its recovery reason
+//	TransferOffered       the offer, see transfer.go
+//	TransferRejected      the rejected offer
+//	TransferCancelled     the cancelled offer
+//	TransferOffersExpired no details, pictures lists the pictures whose offer expired
+//	SaleOffered           the offer, with the hash of its private price
+//	PictureSold           the sale record, see sale.go, with the hash of its private price
+//	AuctionCreated        the auction, see auction.go
+//	BidPlaced             the auction after the bid
+//	BidCommitted          the auction after the commitment
+//	BidRevealed           the auction after the reveal, the amount goes to the seller privately
+//	AuctionClosed         the closed auction, with the winner when sold
+//	AuctionCancelled      the cancelled auction
+//	LoanRequested         the loan, see loan.go
+//	LoanApproved          the approved loan
+//	PictureReturned       the returned loan
+//	PictureReportedStolen the stolen flag, see stolen.go
+//	StolenFlagCleared     the cleared flag, with who cleared it and why
+//	StolenTransferAttempted  the alert of a refused transfer, see reportTransferAttempt
+//	ArtistCreated         the artist, see artist.go
+//	ArtistUpdated         the updated artist
+//	ArtistDeleted         the deleted artist
+//	ExhibitionCreated     the new exhibition or collection, see exhibition.go
+//	ExhibitionPictureAdded   the exhibition or collection after the addition
+//	ExhibitionPictureRemoved the exhibition or collection after the removal
+//	PrivateDetailsUpdated {"privateDetailsHash": ...}, the details stay private, see private.go
+//	ImageFingerprintAdded the fingerprint, see fingerprint.go
+//	CertificateIssued     the certificate of authenticity, see certificate.go
+//	CertificateRevoked    the revoked certificate, with who revoked it and why
+//	ConditionReportAdded  the condition report, see condition.go
+//	PictureMoved          the new location, see location.go
+
+const (
+	eventPictureCreated           = "PictureCreated"
+	eventPictureTransferred       = "PictureTransferred"
+	eventPicturesTransferred      = "PicturesTransferred"
+	eventPictureRetired           = "PictureRetired"
+	eventPictureRecovered         = "PictureRecovered"
+	eventTransferOffered          = "TransferOffered"
+	eventTransferRejected         = "TransferRejected"
+	eventTransferCancelled        = "TransferCancelled"
+	eventTransferOffersExpired    = "TransferOffersExpired"
+	eventSaleOffered              = "SaleOffered"
+	eventPictureSold              = "PictureSold"
+	eventAuctionCreated           = "AuctionCreated"
+	eventBidPlaced                = "BidPlaced"
+	eventBidCommitted             = "BidCommitted"
+	eventBidRevealed              = "BidRevealed"
+	eventAuctionClosed            = "AuctionClosed"
+	eventAuctionCancelled         = "AuctionCancelled"
+	eventLoanRequested            = "LoanRequested"
+	eventLoanApproved             = "LoanApproved"
+	eventPictureReturned          = "PictureReturned"
+	eventPictureReportedStolen    = "PictureReportedStolen"
+	eventStolenFlagCleared        = "StolenFlagCleared"
+	eventStolenTransferAttempted  = "StolenTransferAttempted"
+	eventArtistCreated            = "ArtistCreated"
+	eventArtistUpdated            = "ArtistUpdated"
+	eventArtistDeleted            = "ArtistDeleted"
+	eventExhibitionCreated        = "ExhibitionCreated"
+	eventExhibitionPictureAdded   = "ExhibitionPictureAdded"
+	eventExhibitionPictureRemoved = "ExhibitionPictureRemoved"
+	eventPrivateDetailsUpdated    = "PrivateDetailsUpdated"
+	eventImageFingerprintAdded    = "ImageFingerprintAdded"
+	eventCertificateIssued        = "CertificateIssued"
+	eventCertificateRevoked       = "CertificateRevoked"
+	eventConditionReportAdded     = "ConditionReportAdded"
+	eventPictureMoved             = "PictureMoved"
+)
+
+type chaincodeEvent struct {
+	Type      string      `json:"type"`
+	TxID      string      `json:"txId"`
+	Timestamp string      `json:"timestamp"`
+	Actor     identity    `json:"actor"`
+	Pictures  []string    `json:"pictures"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// ownerChange is the details of a PictureTransferred event
+type ownerChange struct {
+	From identity `json:"from"`
+	To   identity `json:"to"`
+}
+
+// bulkTransfer is the details of a PicturesTransferred event
+type bulkTransfer struct {
+	Generation string   `json:"generation"`
+	To         identity `json:"to"`
+}
+
+// ===========================================================================================
+// emitEvent sets the event of the current transaction. It is only published once the
+// transaction is committed as valid.
+// ===========================================================================================
+func emitEvent(stub shim.ChaincodeStubInterface, eventType string, pictures []string, details interface{}) error {
+	actor, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	if pictures == nil {
+		pictures = []string{}
+	}
+
+	event := chaincodeEvent{
+		Type:      eventType,
+		TxID:      stub.GetTxID(),
+		Timestamp: now.Format(time.RFC3339),
+		Actor:     actor,
+		Pictures:  pictures,
+		Details:   details,
+	}
+	eventJSONasBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(eventType, eventJSONasBytes)
+}
@@ -0,0 +1,418 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Exhibitions and collections ====
+// A gallery groups pictures into exhibitions, held at a venue between two dates, and into
+// collections, which have neither. Both are stored as exhibitions under a composite key built
+// from exhibitionPrefix and the ID of the creating transaction; kind tells them apart. Only
+// members of the organising gallery add and remove works, which may belong to anyone.
+//
+// Two composite key indexes serve the queries, like generation~name serves the generation:
+// picture~exhibition lists the groupings of a picture, and month~exhibition holds an entry
+// for every month an exhibition is open, so a date range only reads the months it covers.
+// Past exhibitions may be recorded too, they are part of the exhibition history of a work.
+
+const exhibitionPrefix = "exhibition"
+
+const (
+	pictureExhibitionIndex = "picture~exhibition" //picture name, exhibition ID
+	exhibitionMonthIndex   = "month~exhibition"   //YYYY-MM, exhibition ID
+)
+
+const (
+	kindExhibition = "exhibition"
+	kindCollection = "collection"
+)
+
+// maxExhibitionMonths bounds the months an exhibition may span and a date range query may
+// read, long-term displays are better kept as collections
+const maxExhibitionMonths = 120
+
+type exhibition struct {
+	ObjectType string   `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID         string   `json:"id"`
+	Kind       string   `json:"kind"` //exhibition or collection
+	Title      string   `json:"title"`
+	Venue      string   `json:"venue,omitempty"`
+	StartDate  string   `json:"startDate,omitempty"` //YYYY-MM-DD, exhibitions only
+	EndDate    string   `json:"endDate,omitempty"`   //YYYY-MM-DD, last day open
+	Organiser  identity `json:"organiser"`
+	Pictures   []string `json:"pictures"` //picture names, in the order they were added
+}
+
+func getExhibition(stub shim.ChaincodeStubInterface, exhibitionID string) (*exhibition, error) {
+	exhibitionKey, err := stub.CreateCompositeKey(exhibitionPrefix, []string{exhibitionID})
+	if err != nil {
+		return nil, err
+	}
+	exhibitionAsBytes, err := stub.GetState(exhibitionKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get exhibition: %s", err)
+	} else if exhibitionAsBytes == nil {
+		return nil, newError(codeNotFound, "exhibitionId", "Exhibition does not exist: %s", exhibitionID)
+	}
+
+	e := &exhibition{}
+	err = json.Unmarshal(exhibitionAsBytes, e)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode exhibition: %s", exhibitionID)
+	}
+	return e, nil
+}
+
+func putExhibition(stub shim.ChaincodeStubInterface, e *exhibition) error {
+	exhibitionKey, err := stub.CreateCompositeKey(exhibitionPrefix, []string{e.ID})
+	if err != nil {
+		return err
+	}
+	exhibitionJSONasBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(exhibitionKey, exhibitionJSONasBytes)
+}
+
+// exhibitionMonths lists the months, as YYYY-MM, from the month of start to that of end
+func exhibitionMonths(start, end time.Time) []string {
+	months := []string{}
+	for month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(end); month = month.AddDate(0, 1, 0) {
+		months = append(months, month.Format("2006-01"))
+	}
+	return months
+}
+
+// ===========================================================================================
+// createExhibition - a gallery member opens an exhibition organised by its gallery
+// ===========================================================================================
+func (t *SimpleChaincode) createExhibition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                        1                 2             3
+	// "Monet, the late years", "Musee du Louvre", "2018-09-01", "2019-01-15"
+	startDate, _ := time.Parse(loanDateLayout, args[2])
+	endDate, _ := time.Parse(loanDateLayout, args[3])
+	if endDate.Before(startDate) {
+		return errorResponse(newError(codeInvalidArgument, "endDate", "The exhibition cannot end before it starts"))
+	}
+	months := exhibitionMonths(startDate, endDate)
+	if len(months) > maxExhibitionMonths {
+		return errorResponse(newError(codeInvalidArgument, "endDate", "An exhibition spans at most %d months, use a collection for a long-term display", maxExhibitionMonths))
+	}
+	fmt.Println("- start createExhibition ", args[0])
+
+	e, err := newExhibition(stub, kindExhibition, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	e.Venue = strings.TrimSpace(args[1])
+	e.StartDate = startDate.Format(loanDateLayout)
+	e.EndDate = endDate.Format(loanDateLayout)
+	err = putExhibition(stub, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	value := []byte{0x00}
+	for _, month := range months {
+		monthIndexKey, err := stub.CreateCompositeKey(exhibitionMonthIndex, []string{month, e.ID})
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = stub.PutState(monthIndexKey, value)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	err = emitEvent(stub, eventExhibitionCreated, nil, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end createExhibition (success)")
+	return shim.Success([]byte(e.ID))
+}
+
+// ===========================================================================================
+// createCollection - a gallery member creates a named collection of pictures
+// ===========================================================================================
+func (t *SimpleChaincode) createCollection(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "Impressionist landscapes"
+	fmt.Println("- start createCollection ", args[0])
+
+	e, err := newExhibition(stub, kindCollection, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putExhibition(stub, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventExhibitionCreated, nil, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end createCollection (success)")
+	return shim.Success([]byte(e.ID))
+}
+
+// newExhibition returns an empty exhibition or collection organised by the caller
+func newExhibition(stub shim.ChaincodeStubInterface, kind, title string) (*exhibition, error) {
+	organiser, _, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	return &exhibition{
+		ObjectType: "exhibition",
+		ID:         stub.GetTxID(),
+		Kind:       kind,
+		Title:      strings.TrimSpace(title),
+		Organiser:  organiser,
+		Pictures:   []string{},
+	}, nil
+}
+
+// getOrganisedExhibition reads an exhibition the caller's gallery organises
+func getOrganisedExhibition(stub shim.ChaincodeStubInterface, exhibitionID string) (*exhibition, error) {
+	e, err := getExhibition(stub, exhibitionID)
+	if err != nil {
+		return nil, err
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller.MSPID != e.Organiser.MSPID {
+		return nil, newError(codeForbidden, "", "Only members of %s may change %s %s", e.Organiser.MSPID, e.Kind, exhibitionID)
+	}
+	return e, nil
+}
+
+// ===========================================================================================
+// addToExhibition - the organiser adds a picture to an exhibition or a collection
+// ===========================================================================================
+func (t *SimpleChaincode) addToExhibition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1
+	// "exhibitionId", "name"
+	exhibitionID := args[0]
+	pictureName := args[1]
+	fmt.Println("- start addToExhibition ", exhibitionID, pictureName)
+
+	e, err := getOrganisedExhibition(stub, exhibitionID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkNotRetired(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, name := range e.Pictures {
+		if name == pictureName {
+			return errorResponse(newError(codeConflict, "name", "Picture %s is already in %s %s", pictureName, e.Kind, exhibitionID))
+		}
+	}
+
+	e.Pictures = append(e.Pictures, pictureName)
+	err = putExhibition(stub, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pictureIndexKey, err := stub.CreateCompositeKey(pictureExhibitionIndex, []string{pictureName, exhibitionID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.PutState(pictureIndexKey, []byte{0x00})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventExhibitionPictureAdded, []string{pictureName}, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end addToExhibition (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// removeFromExhibition - the organiser takes a picture out of an exhibition or a collection
+// ===========================================================================================
+func (t *SimpleChaincode) removeFromExhibition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1
+	// "exhibitionId", "name"
+	exhibitionID := args[0]
+	pictureName := args[1]
+	fmt.Println("- start removeFromExhibition ", exhibitionID, pictureName)
+
+	e, err := getOrganisedExhibition(stub, exhibitionID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pictures := []string{}
+	for _, name := range e.Pictures {
+		if name != pictureName {
+			pictures = append(pictures, name)
+		}
+	}
+	if len(pictures) == len(e.Pictures) {
+		return errorResponse(newError(codeNotFound, "name", "Picture %s is not in %s %s", pictureName, e.Kind, exhibitionID))
+	}
+
+	e.Pictures = pictures
+	err = putExhibition(stub, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pictureIndexKey, err := stub.CreateCompositeKey(pictureExhibitionIndex, []string{pictureName, exhibitionID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.DelState(pictureIndexKey)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+	}
+
+	err = emitEvent(stub, eventExhibitionPictureRemoved, []string{pictureName}, e)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end removeFromExhibition (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// readExhibition - read an exhibition or a collection
+// ===========================================================================================
+func (t *SimpleChaincode) readExhibition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "exhibitionId"
+	e, err := getExhibition(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	exhibitionJSONasBytes, err := json.Marshal(e)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(exhibitionJSONasBytes)
+}
+
+// exhibitionsByIndex reads the exhibitions of the index entries starting with the attributes,
+// once each, the exhibition ID being the last attribute of the entries
+func exhibitionsByIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string, seen map[string]bool) ([]exhibition, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	exhibitions := []exhibition{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		exhibitionID := compositeKeyParts[len(compositeKeyParts)-1]
+		if seen[exhibitionID] {
+			continue
+		}
+		seen[exhibitionID] = true
+		e, err := getExhibition(stub, exhibitionID)
+		if err != nil {
+			return nil, err
+		}
+		exhibitions = append(exhibitions, *e)
+	}
+	return exhibitions, nil
+}
+
+// ===========================================================================================
+// getExhibitionsForPicture - list the exhibitions and collections a picture is part of
+// ===========================================================================================
+func (t *SimpleChaincode) getExhibitionsForPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	exhibitions, err := exhibitionsByIndex(stub, pictureExhibitionIndex, []string{args[0]}, map[string]bool{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	exhibitionsJSONasBytes, err := json.Marshal(exhibitions)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(exhibitionsJSONasBytes)
+}
+
+// ===========================================================================================
+// getExhibitionsByDateRange - list the exhibitions open at least one day between two dates,
+// by start date, using the month~exhibition index
+// ===========================================================================================
+func (t *SimpleChaincode) getExhibitionsByDateRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0             1
+	// "2018-10-01", "2018-12-31"
+	from, _ := time.Parse(loanDateLayout, args[0])
+	to, _ := time.Parse(loanDateLayout, args[1])
+	if to.Before(from) {
+		return errorResponse(newError(codeInvalidArgument, "to", "The date range cannot end before it starts"))
+	}
+	months := exhibitionMonths(from, to)
+	if len(months) > maxExhibitionMonths {
+		return errorResponse(newError(codeInvalidArgument, "to", "A date range spans at most %d months", maxExhibitionMonths))
+	}
+
+	exhibitions := []exhibition{}
+	seen := map[string]bool{}
+	for _, month := range months {
+		monthExhibitions, err := exhibitionsByIndex(stub, exhibitionMonthIndex, []string{month}, seen)
+		if err != nil {
+			return errorResponse(err)
+		}
+		// the first and last months may hold exhibitions outside of the range
+		for _, e := range monthExhibitions {
+			if e.StartDate <= args[1] && e.EndDate >= args[0] {
+				exhibitions = append(exhibitions, e)
+			}
+		}
+	}
+	sort.SliceStable(exhibitions, func(i, j int) bool {
+		return exhibitions[i].StartDate < exhibitions[j].StartDate
+	})
+
+	exhibitionsJSONasBytes, err := json.Marshal(exhibitions)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(exhibitionsJSONasBytes)
+}
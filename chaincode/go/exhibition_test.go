@@ -0,0 +1,126 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func readTestExhibition(t *testing.T, stub *shim.MockStub, exhibitionID string) exhibition {
+	t.Helper()
+	e := exhibition{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "readExhibition", exhibitionID), &e)
+	if err != nil {
+		t.Fatal("Failed to decode exhibition:", err)
+	}
+	return e
+}
+
+// readExhibitionTitles runs an exhibition query and returns the titles found, in order
+func readExhibitionTitles(t *testing.T, stub *shim.MockStub, args ...string) string {
+	t.Helper()
+	exhibitions := []exhibition{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, args...), &exhibitions)
+	if err != nil {
+		t.Fatal("Failed to decode exhibitions:", err)
+	}
+	titles := []string{}
+	for _, e := range exhibitions {
+		titles = append(titles, e.Title)
+	}
+	return strings.Join(titles, ",")
+}
+
+func TestExhibitions(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, guggenheimUser, "picture2", "red")
+
+	exhibitionID := string(checkInvoke(t, stub, louvreUser, "createExhibition", "Monet, the late years", " Musee du Louvre ", "2018-09-01", "2019-01-15"))
+	if event := lastEvent(t, stub); event.Type != eventExhibitionCreated {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	collectionID := string(checkInvoke(t, stub, guggenheimUser, "createCollection", "Impressionist landscapes"))
+
+	// works of other galleries may be exhibited, only the organiser adds them
+	checkInvoke(t, stub, louvreAdmin, "addToExhibition", exhibitionID, "picture2")
+	if event := lastEvent(t, stub); event.Type != eventExhibitionPictureAdded || len(event.Pictures) != 1 || event.Pictures[0] != "picture2" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	checkInvoke(t, stub, louvreUser, "addToExhibition", exhibitionID, "picture1")
+	checkInvoke(t, stub, guggenheimUser, "addToExhibition", collectionID, "picture2")
+
+	e := readTestExhibition(t, stub, exhibitionID)
+	if e.Kind != kindExhibition || e.Venue != "Musee du Louvre" || e.Organiser != louvreUser.id || e.StartDate != "2018-09-01" || strings.Join(e.Pictures, ",") != "picture2,picture1" {
+		t.Fatalf("Unexpected exhibition %+v", e)
+	}
+	if c := readTestExhibition(t, stub, collectionID); c.Kind != kindCollection || c.Venue != "" || c.StartDate != "" || strings.Join(c.Pictures, ",") != "picture2" {
+		t.Fatalf("Unexpected collection %+v", c)
+	}
+	if titles := readExhibitionTitles(t, stub, "getExhibitionsForPicture", "picture2"); titles != "Monet, the late years,Impressionist landscapes" && titles != "Impressionist landscapes,Monet, the late years" {
+		t.Fatalf("Unexpected exhibitions of picture2 %q", titles)
+	}
+
+	checkInvokeError(t, stub, guggenheimUser, "Only members of LouvreMSP may change exhibition "+exhibitionID, "addToExhibition", exhibitionID, "picture2")
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is already in exhibition "+exhibitionID, "addToExhibition", exhibitionID, "picture1")
+	checkInvokeError(t, stub, louvreUser, "Picture does not exist: picture3", "addToExhibition", exhibitionID, "picture3")
+	checkInvokeError(t, stub, louvreUser, "Exhibition does not exist: missing", "addToExhibition", "missing", "picture1")
+
+	checkInvoke(t, stub, louvreUser, "removeFromExhibition", exhibitionID, "picture2")
+	if event := lastEvent(t, stub); event.Type != eventExhibitionPictureRemoved {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	if e := readTestExhibition(t, stub, exhibitionID); strings.Join(e.Pictures, ",") != "picture1" {
+		t.Fatalf("Unexpected pictures %q", e.Pictures)
+	}
+	if titles := readExhibitionTitles(t, stub, "getExhibitionsForPicture", "picture2"); titles != "Impressionist landscapes" {
+		t.Fatalf("Unexpected exhibitions of picture2 %q", titles)
+	}
+	e2 := checkInvokeError(t, stub, louvreUser, "Picture picture2 is not in exhibition "+exhibitionID, "removeFromExhibition", exhibitionID, "picture2")
+	if e2.Code != codeNotFound || e2.Field != "name" {
+		t.Fatalf("Unexpected error %+v", e2)
+	}
+
+	// retired works are not exhibited any more
+	checkInvoke(t, stub, guggenheimUser, "retirePicture", "picture2", statusLost, "Missing since the move")
+	checkInvokeError(t, stub, louvreUser, "Picture picture2 is retired", "addToExhibition", exhibitionID, "picture2")
+}
+
+func TestGetExhibitionsByDateRange(t *testing.T) {
+	stub := newTestStub()
+	checkInvoke(t, stub, louvreUser, "createExhibition", "Autumn", "Musee du Louvre", "2018-09-15", "2018-11-30")
+	checkInvoke(t, stub, guggenheimUser, "createExhibition", "Summer", "Guggenheim Bilbao", "2018-06-01", "2018-09-14")
+	checkInvoke(t, stub, louvreUser, "createExhibition", "Winter", "Musee du Louvre", "2018-12-01", "2019-02-28")
+	checkInvoke(t, stub, louvreUser, "createCollection", "Permanent")
+
+	tests := []struct {
+		name     string
+		from, to string
+		expected string
+	}{
+		{"one day", "2018-09-14", "2018-09-14", "Summer"},
+		{"same month", "2018-09-15", "2018-09-30", "Autumn"},
+		{"overlapping", "2018-09-01", "2018-12-01", "Summer,Autumn,Winter"},
+		{"later", "2019-01-01", "2019-12-31", "Winter"},
+		{"none", "2017-01-01", "2018-05-31", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if titles := readExhibitionTitles(t, stub, "getExhibitionsByDateRange", test.from, test.to); titles != test.expected {
+				t.Fatalf("Found %q, expected %q", titles, test.expected)
+			}
+		})
+	}
+
+	checkInvokeError(t, stub, outsiderUser, "The date range cannot end before it starts", "getExhibitionsByDateRange", "2018-12-01", "2018-09-01")
+	checkInvokeError(t, stub, outsiderUser, "A date range spans at most 120 months", "getExhibitionsByDateRange", "2000-01-01", "2018-09-01")
+	checkInvokeError(t, stub, louvreUser, "The exhibition cannot end before it starts", "createExhibition", "Spring", "Musee du Louvre", "2019-05-01", "2019-03-01")
+	checkInvokeError(t, stub, louvreUser, "An exhibition spans at most 120 months", "createExhibition", "Forever", "Musee du Louvre", "2000-01-01", "2018-09-01")
+	checkInvokeError(t, stub, outsiderUser, "createExhibition is reserved to gallery members, not OutsiderMSP", "createExhibition", "Spring", "Elsewhere", "2019-03-01", "2019-05-01")
+}
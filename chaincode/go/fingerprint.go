@@ -0,0 +1,302 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Image fingerprints ====
+// Images are never stored on the ledger, only fingerprints of the reference photographs of a
+// picture: the SHA-256 hash of the image file, which only matches that very file, and a 64
+// bit perceptual hash, which stays close for copies of the photograph that were resized,
+// recompressed or cropped a little, such as the one printed in a sale catalogue.
+//
+// A fingerprint is stored under a composite key built from fingerprintPrefix and its SHA-256
+// hash, so an image file belongs to a single picture. picture~fingerprint lists the
+// fingerprints of a picture and phash~fingerprint finds those of a perceptual hash.
+// verifyImage also finds perceptual hashes a few bits away, by reading the whole
+// phash~fingerprint index, as a Hamming distance cannot be looked up by key.
+
+const fingerprintPrefix = "fingerprint"
+
+const (
+	pictureFingerprintIndex = "picture~fingerprint" //picture name, SHA-256 hash
+	perceptualHashIndex     = "phash~fingerprint"   //perceptual hash, SHA-256 hash
+)
+
+// maxImageDistance bounds the Hamming distance verifyImage accepts between perceptual hashes,
+// beyond it unrelated images start to match
+const maxImageDistance = 12
+
+type imageFingerprint struct {
+	ObjectType     string   `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Picture        string   `json:"picture"`
+	SHA256         string   `json:"sha256"` //lowercase hex
+	PerceptualHash string   `json:"pHash"`  //16 lowercase hex digits
+	Description    string   `json:"description,omitempty"`
+	AddedBy        identity `json:"addedBy"`
+	AddedAt        string   `json:"addedAt"` //RFC3339 transaction timestamp
+}
+
+// imageMatch is a result of verifyImage
+type imageMatch struct {
+	Fingerprint imageFingerprint `json:"fingerprint"`
+	MatchedOn   string           `json:"matchedOn"` //sha256 or pHash
+	Distance    int              `json:"distance"`  //Hamming distance between the perceptual hashes, 0 when identical
+	Title       string           `json:"title"`
+	Owner       identity         `json:"owner"`
+	Status      string           `json:"status"`
+}
+
+// perceptualDistance returns the number of bits two perceptual hashes differ by
+func perceptualDistance(a, b string) int {
+	x, _ := strconv.ParseUint(a, 16, 64)
+	y, _ := strconv.ParseUint(b, 16, 64)
+	return bits.OnesCount64(x ^ y)
+}
+
+func getImageFingerprint(stub shim.ChaincodeStubInterface, sha256Hash string) (*imageFingerprint, error) {
+	fingerprintKey, err := stub.CreateCompositeKey(fingerprintPrefix, []string{sha256Hash})
+	if err != nil {
+		return nil, err
+	}
+	fingerprintAsBytes, err := stub.GetState(fingerprintKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get fingerprint: %s", err)
+	} else if fingerprintAsBytes == nil {
+		return nil, nil
+	}
+
+	f := &imageFingerprint{}
+	err = json.Unmarshal(fingerprintAsBytes, f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode fingerprint: %s", sha256Hash)
+	}
+	return f, nil
+}
+
+// fingerprintsByIndex reads the fingerprints of the index entries starting with the
+// attributes, the SHA-256 hash being the last attribute of the entries
+func fingerprintsByIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]imageFingerprint, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	fingerprints := []imageFingerprint{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		f, err := getImageFingerprint(stub, compositeKeyParts[len(compositeKeyParts)-1])
+		if err != nil {
+			return nil, err
+		} else if f == nil {
+			return nil, fmt.Errorf("Fingerprint of index entry %s not found", responseRange.Key)
+		}
+		fingerprints = append(fingerprints, *f)
+	}
+	return fingerprints, nil
+}
+
+// ===========================================================================================
+// addImageFingerprint - the owner of a picture, or an admin of its organisation, registers
+// the fingerprint of a reference photograph. Retired pictures take fingerprints too, a lost
+// or stolen work is the one most worth recognising.
+// ===========================================================================================
+func (t *SimpleChaincode) addImageFingerprint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1                  2                     3
+	// "name", "<hex sha256>", "c3e1f0f0e0c08080", "front, raking light" (optional)
+	pictureName := args[0]
+	sha256Hash := strings.ToLower(args[1])
+	perceptualHash := strings.ToLower(args[2])
+	fmt.Println("- start addImageFingerprint ", pictureName, sha256Hash)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	existing, err := getImageFingerprint(stub, sha256Hash)
+	if err != nil {
+		return errorResponse(err)
+	} else if existing != nil {
+		return errorResponse(newError(codeAlreadyExists, "sha256", "Image %s is already registered for picture %s", sha256Hash, existing.Picture))
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	f := &imageFingerprint{
+		ObjectType:     "imageFingerprint",
+		Picture:        pictureName,
+		SHA256:         sha256Hash,
+		PerceptualHash: perceptualHash,
+		AddedBy:        caller,
+		AddedAt:        now.Format(time.RFC3339),
+	}
+	if len(args) > 3 {
+		f.Description = strings.TrimSpace(args[3])
+	}
+	fingerprintKey, err := stub.CreateCompositeKey(fingerprintPrefix, []string{sha256Hash})
+	if err != nil {
+		return errorResponse(err)
+	}
+	fingerprintJSONasBytes, err := json.Marshal(f)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.PutState(fingerprintKey, fingerprintJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	value := []byte{0x00}
+	for _, entry := range [][]string{{pictureFingerprintIndex, pictureName, sha256Hash}, {perceptualHashIndex, perceptualHash, sha256Hash}} {
+		indexKey, err := stub.CreateCompositeKey(entry[0], entry[1:])
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = stub.PutState(indexKey, value)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	err = emitEvent(stub, eventImageFingerprintAdded, []string{pictureName}, f)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end addImageFingerprint (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// getImageFingerprints - list the fingerprints of a picture
+// ===========================================================================================
+func (t *SimpleChaincode) getImageFingerprints(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	_, err := getPicture(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	fingerprints, err := fingerprintsByIndex(stub, pictureFingerprintIndex, []string{args[0]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	fingerprintsJSONasBytes, err := json.Marshal(fingerprints)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(fingerprintsJSONasBytes)
+}
+
+// ===========================================================================================
+// verifyImage - find the pictures an image fingerprint belongs to. A SHA-256 hash matches the
+// very same file. A perceptual hash matches those within maxDistance bits, 0 by default,
+// closest first.
+// ===========================================================================================
+func (t *SimpleChaincode) verifyImage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                                1
+	// "<hex sha256>" or "c3e1f0f0e0c08080", "6" (optional, perceptual hashes only)
+	hash := strings.ToLower(args[0])
+	maxDistance := 0
+	if len(args) > 1 {
+		maxDistance, _ = strconv.Atoi(args[1])
+	}
+	if maxDistance < 0 || maxDistance > maxImageDistance {
+		return errorResponse(newError(codeInvalidArgument, "maxDistance", "The distance must be between 0 and %d bits", maxImageDistance))
+	}
+
+	var fingerprints []imageFingerprint
+	matchedOn := ""
+	switch {
+	case checkArgType(argHash, hash) == "":
+		if maxDistance > 0 {
+			return errorResponse(newError(codeInvalidArgument, "maxDistance", "A distance only applies to perceptual hashes"))
+		}
+		matchedOn = "sha256"
+		f, err := getImageFingerprint(stub, hash)
+		if err != nil {
+			return errorResponse(err)
+		} else if f != nil {
+			fingerprints = append(fingerprints, *f)
+		}
+	case checkArgType(argPerceptualHash, hash) == "":
+		matchedOn = "pHash"
+		// without a distance the index is read for the hash alone
+		attributes := []string{hash}
+		if maxDistance > 0 {
+			attributes = []string{}
+		}
+		var err error
+		fingerprints, err = fingerprintsByIndex(stub, perceptualHashIndex, attributes)
+		if err != nil {
+			return errorResponse(err)
+		}
+	default:
+		return errorResponse(newError(codeInvalidArgument, "hash", "Hash must be a hex encoded SHA-256 hash or 64 bit perceptual hash"))
+	}
+
+	matches := []imageMatch{}
+	for _, f := range fingerprints {
+		distance := perceptualDistance(hash, f.PerceptualHash)
+		if matchedOn == "sha256" {
+			distance = 0
+		} else if distance > maxDistance {
+			continue
+		}
+		pic, err := getPicture(stub, f.Picture)
+		if err != nil {
+			return errorResponse(err)
+		}
+		matches = append(matches, imageMatch{
+			Fingerprint: f,
+			MatchedOn:   matchedOn,
+			Distance:    distance,
+			Title:       pic.Title,
+			Owner:       pic.Owner,
+			Status:      pic.Status,
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+
+	matchesJSONasBytes, err := json.Marshal(matches)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(matchesJSONasBytes)
+}
@@ -0,0 +1,136 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// imageHash returns the SHA-256 hash of a fake image file
+func imageHash(image string) string {
+	hash := sha256.Sum256([]byte(image))
+	return hex.EncodeToString(hash[:])
+}
+
+// readImageMatches runs verifyImage and returns the matching pictures and distances, in order
+func readImageMatches(t *testing.T, stub *shim.MockStub, args ...string) []imageMatch {
+	t.Helper()
+	matches := []imageMatch{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, append([]string{"verifyImage"}, args...)...), &matches)
+	if err != nil {
+		t.Fatal("Failed to decode matches:", err)
+	}
+	return matches
+}
+
+func TestAddImageFingerprint(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, guggenheimUser, "picture2", "red")
+
+	front := imageHash("picture1 front")
+	checkInvoke(t, stub, louvreUser, "addImageFingerprint", "picture1", strings.ToUpper(front), "C3E1F0F0E0C08080", " front, raking light ")
+	event := lastEvent(t, stub)
+	if event.Type != eventImageFingerprintAdded || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	// an admin of the owning organisation may register one too, retired pictures included
+	checkInvoke(t, stub, louvreUser, "retirePicture", "picture1", statusLost, "Missing since the move")
+	checkInvoke(t, stub, louvreAdmin, "addImageFingerprint", "picture1", imageHash("picture1 back"), "0f0f0f0f0f0f0f0f")
+
+	fingerprints := []imageFingerprint{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "getImageFingerprints", "picture1"), &fingerprints)
+	if err != nil || len(fingerprints) != 2 {
+		t.Fatalf("Unexpected fingerprints %+v (%v)", fingerprints, err)
+	}
+	for _, f := range fingerprints {
+		if f.SHA256 == front && (f.PerceptualHash != "c3e1f0f0e0c08080" || f.Description != "front, raking light" || f.AddedBy != louvreUser.id || f.AddedAt == "") {
+			t.Fatalf("Unexpected fingerprint %+v", f)
+		}
+	}
+	if keys := indexKeys(t, stub, front); len(keys) != 3 {
+		t.Fatalf("Unexpected fingerprint keys %q", keys)
+	}
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+		code    string
+		field   string
+	}{
+		{"not the owner", guggenheimAdmin, []string{"picture1", imageHash("other"), "0000000000000000"}, "Not allowed to manage picture picture1", codeForbidden, ""},
+		{"missing picture", louvreUser, []string{"picture3", imageHash("other"), "0000000000000000"}, "Picture does not exist: picture3", codeNotFound, "name"},
+		{"same image", guggenheimUser, []string{"picture2", front, "c3e1f0f0e0c08080"}, "Image " + front + " is already registered for picture picture1", codeAlreadyExists, "sha256"},
+		{"invalid SHA-256", louvreUser, []string{"picture1", "abc", "0000000000000000"}, "Argument 2 (sha256) must be a hex encoded SHA-256 hash", codeInvalidArgument, "sha256"},
+		{"invalid perceptual hash", louvreUser, []string{"picture1", imageHash("other"), front}, "Argument 3 (pHash) must be a 64 bit perceptual hash as 16 hex digits", codeInvalidArgument, "pHash"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, test.message, append([]string{"addImageFingerprint"}, test.args...)...)
+			if e.Code != test.code || e.Field != test.field {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+	checkInvokeError(t, stub, outsiderUser, "Picture does not exist: picture3", "getImageFingerprints", "picture3")
+}
+
+func TestVerifyImage(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, guggenheimUser, "picture2", "red")
+	checkInvoke(t, stub, louvreUser, "addImageFingerprint", "picture1", imageHash("picture1 front"), "c3e1f0f0e0c08080")
+	checkInvoke(t, stub, guggenheimUser, "addImageFingerprint", "picture2", imageHash("picture2 front"), "c3e1f0f0e0c0ff80")
+
+	// the very same file
+	matches := readImageMatches(t, stub, imageHash("picture1 front"))
+	if len(matches) != 1 || matches[0].Fingerprint.Picture != "picture1" || matches[0].MatchedOn != "sha256" || matches[0].Owner != louvreUser.id || matches[0].Status != statusAvailable {
+		t.Fatalf("Unexpected matches %+v", matches)
+	}
+	if matches := readImageMatches(t, stub, imageHash("catalogue scan")); len(matches) != 0 {
+		t.Fatalf("Unexpected matches %+v", matches)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"same perceptual hash", []string{"C3E1F0F0E0C08080"}, "picture1:0"},
+		{"one bit away", []string{"c3e1f0f0e0c08081"}, ""},
+		{"within the distance", []string{"c3e1f0f0e0c08081", "1"}, "picture1:1"},
+		{"closest first", []string{"c3e1f0f0e0c08081", "12"}, "picture1:1,picture2:8"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := []string{}
+			for _, m := range readImageMatches(t, stub, test.args...) {
+				if m.MatchedOn != "pHash" {
+					t.Fatalf("Unexpected match %+v", m)
+				}
+				found = append(found, fmt.Sprintf("%s:%d", m.Fingerprint.Picture, m.Distance))
+			}
+			if strings.Join(found, ",") != test.expected {
+				t.Fatalf("Found %q, expected %q", found, test.expected)
+			}
+		})
+	}
+
+	checkInvokeError(t, stub, outsiderUser, "Hash must be a hex encoded SHA-256 hash or 64 bit perceptual hash", "verifyImage", "front.jpg")
+	checkInvokeError(t, stub, outsiderUser, "A distance only applies to perceptual hashes", "verifyImage", imageHash("picture1 front"), "2")
+	e := checkInvokeError(t, stub, outsiderUser, "The distance must be between 0 and 12 bits", "verifyImage", "c3e1f0f0e0c08080", "13")
+	if e.Code != codeInvalidArgument || e.Field != "maxDistance" {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
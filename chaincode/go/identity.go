@@ -0,0 +1,124 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/x509"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// galleryMSPs lists the organisations of the network allowed to own pictures
+var galleryMSPs = map[string]bool{
+	"LouvreMSP":     true,
+	"GuggenheimMSP": true,
+}
+
+// stolenRegistryMSPs lists the organisations keeping the stolen art registry, allowed to report
+// and clear the stolen flag of any picture, and alone to clear the flags they raise, see
+// stolen.go. The sample network defines them without peers.
+var stolenRegistryMSPs = map[string]bool{
+	"ArtLossRegisterMSP": true,
+}
+
+// expertAttribute marks the art experts allowed to issue certificates of authenticity, see
+// certificate.go. Fabric CA adds it to their enrollment certificate when they are registered
+// with --id.attrs 'artExpert=true:ecert', whatever their organisation.
+const expertAttribute = "artExpert"
+
+// identity records a client of the network as the MSP that issued its certificate
+// plus the certificate subject, e.g. LouvreMSP and "CN=User1@louvre.artgalleries.com,..."
+type identity struct {
+	MSPID   string `json:"mspId"`
+	Subject string `json:"subject"`
+}
+
+func (id identity) String() string {
+	return id.MSPID + "::" + id.Subject
+}
+
+// ===========================================================================================
+// getCaller returns the identity of the client submitting the transaction, as found by the
+// client identity library, and whether that client is an admin of its organisation
+// ===========================================================================================
+func getCaller(stub shim.ChaincodeStubInterface) (identity, bool, error) {
+	clientIdentity, err := cid.New(stub)
+	if err != nil {
+		return identity{}, false, newError(codeForbidden, "", "Failed to get client identity: %s", err)
+	}
+	mspID, err := clientIdentity.GetMSPID()
+	if err != nil {
+		return identity{}, false, newError(codeForbidden, "", "Failed to get client MSP ID: %s", err)
+	}
+	cert, err := clientIdentity.GetX509Certificate()
+	if err != nil {
+		return identity{}, false, newError(codeForbidden, "", "Failed to get client certificate: %s", err)
+	}
+
+	return identity{MSPID: mspID, Subject: cert.Subject.String()}, isAdminCertificate(cert), nil
+}
+
+// isAdminCertificate reports whether a certificate belongs to an organisation admin, which
+// only the "admin" OU of NodeOUs tells. The common name is chosen at enrollment, so an
+// Admin@<domain> name proves nothing. crypto-config.yaml enables NodeOUs, and cryptogen
+// gives admins that OU from release 1.4.3.
+func isAdminCertificate(cert *x509.Certificate) bool {
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// isExpert reports whether the certificate of the caller carries the expert attribute
+func isExpert(stub shim.ChaincodeStubInterface) (bool, error) {
+	clientIdentity, err := cid.New(stub)
+	if err != nil {
+		return false, newError(codeForbidden, "", "Failed to get client identity: %s", err)
+	}
+	value, found, err := clientIdentity.GetAttributeValue(expertAttribute)
+	if err != nil {
+		return false, newError(codeForbidden, "", "Failed to get client attributes: %s", err)
+	}
+	return found && value == "true", nil
+}
+
+// isHolder reports whether a client belongs to the organisation owning a picture or, while it
+// is on loan, to the organisation holding it
+func isHolder(id identity, pic *picture) bool {
+	return id.MSPID == pic.Owner.MSPID || (pic.Custodian != nil && id.MSPID == pic.Custodian.MSPID)
+}
+
+// ===========================================================================================
+// checkIdentityOrAdmin verifies the caller is the given identity or an admin of the
+// organisation that identity belongs to
+// ===========================================================================================
+func checkIdentityOrAdmin(stub shim.ChaincodeStubInterface, id identity) error {
+	caller, admin, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if caller == id {
+		return nil
+	}
+	if admin && caller.MSPID == id.MSPID && galleryMSPs[caller.MSPID] {
+		return nil
+	}
+	return newError(codeForbidden, "", "%s is not allowed to act on behalf of %s", caller, id)
+}
+
+// ===========================================================================================
+// checkOwnerOrAdmin verifies the caller may act on a picture: it must either be the current
+// owner or an admin of the owning organisation
+// ===========================================================================================
+func checkOwnerOrAdmin(stub shim.ChaincodeStubInterface, pic *picture) error {
+	err := checkIdentityOrAdmin(stub, pic.Owner)
+	if err != nil {
+		return newError(codeForbidden, "", "Not allowed to manage picture %s: %s", pic.Name, err)
+	}
+	return nil
+}
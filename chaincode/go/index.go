@@ -0,0 +1,169 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Secondary indexes ====
+// Rich queries need CouchDB. The composite key indexes below let any state database find
+// pictures by generation, owner, artist, status and location with range queries. Only the key matters,
+// the value of an index entry is the null character. initPicture and putPicture keep them up
+// to date, so every change of a picture goes through one of them.
+//
+// generation~name only holds the works still in the collection. Retiring a picture, see
+// retire.go, moves its entry to retired~generation~name, so generation queries and bulk
+// transfers leave retired works out unless asked for them.
+
+const (
+	generationIndex        = "generation~name"         //generation, picture name
+	retiredGenerationIndex = "retired~generation~name" //generation, picture name, for retired pictures
+	ownerIndex             = "owner~name"              //owner MSP ID, owner subject, picture name
+	artistIndex            = "artist~name"             //artist ID, picture name
+	statusIndex            = "status~name"             //status, picture name
+)
+
+// pictureStatuses lists the statuses getPicturesByStatus accepts
+var pictureStatuses = map[string]bool{
+	statusAvailable:     true,
+	statusAtAuction:     true,
+	statusOnLoan:        true,
+	statusDeaccessioned: true,
+	statusDestroyed:     true,
+	statusLost:          true,
+	statusStolen:        true,
+	statusRestituted:    true,
+}
+
+// pictureIndexKeys returns the generation, owner, artist and status index keys of a picture,
+// and its location key once it has a location, see location.go
+func pictureIndexKeys(stub shim.ChaincodeStubInterface, pic *picture) ([]string, error) {
+	generationIndexName := generationIndex
+	if retiredStatuses[pic.Status] {
+		generationIndexName = retiredGenerationIndex
+	}
+	indexAttributes := map[string][]string{
+		generationIndexName: {pic.Generation, pic.Name},
+		ownerIndex:          {pic.Owner.MSPID, pic.Owner.Subject, pic.Name},
+		artistIndex:         {pic.ArtistID, pic.Name},
+		statusIndex:         {pic.Status, pic.Name},
+	}
+	indexNames := []string{generationIndexName, ownerIndex, artistIndex, statusIndex}
+	if pic.Location != nil {
+		indexAttributes[locationIndex] = []string{pic.Location.Site, pic.Location.Building, pic.Location.Room, pic.Name}
+		indexNames = append(indexNames, locationIndex)
+	}
+	keys := []string{}
+	for _, indexName := range indexNames {
+		key, err := stub.CreateCompositeKey(indexName, indexAttributes[indexName])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ===========================================================================================
+// indexPicture writes the index entries of a picture and removes those of its previous
+// version that no longer apply. previous is nil for a new picture.
+// ===========================================================================================
+func indexPicture(stub shim.ChaincodeStubInterface, previous, pic *picture) error {
+	keys, err := pictureIndexKeys(stub, pic)
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, key := range keys {
+		current[key] = true
+	}
+
+	if previous != nil {
+		previousKeys, err := pictureIndexKeys(stub, previous)
+		if err != nil {
+			return err
+		}
+		for _, key := range previousKeys {
+			if current[key] {
+				continue
+			}
+			err = stub.DelState(key)
+			if err != nil {
+				return fmt.Errorf("Failed to delete state: %s", err)
+			}
+		}
+	}
+
+	value := []byte{0x00}
+	for _, key := range keys {
+		err = stub.PutState(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPicturesByIndex returns the pictures of the index entries starting with the attributes
+func getPicturesByIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string) pb.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	buffer, _, err := constructQueryResponseFromIndexIterator(stub, resultsIterator)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- getPicturesByIndex %s queryResult:\n%s\n", indexName, buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ===========================================================================================
+// getPicturesByOwner - find the pictures of an organisation or of one of its members using
+// the owner~name index. Unlike queryPicturesByOwner it works on LevelDB.
+// ===========================================================================================
+func (t *SimpleChaincode) getPicturesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1
+	// "LouvreMSP", "CN=User1@louvre..." (optional)
+	return getPicturesByIndex(stub, ownerIndex, args)
+}
+
+// ===========================================================================================
+// getPicturesByArtist - find the pictures of a registered artist using the artist~name
+// index, retired pictures included
+// ===========================================================================================
+func (t *SimpleChaincode) getPicturesByArtist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "claude-monet"
+	_, err := getArtist(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return getPicturesByIndex(stub, artistIndex, []string{args[0]})
+}
+
+// ===========================================================================================
+// getPicturesByStatus - find the pictures with a status using the status~name index,
+// e.g. the pictures currently on loan
+// ===========================================================================================
+func (t *SimpleChaincode) getPicturesByStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "onLoan"
+	if !pictureStatuses[args[0]] {
+		return errorResponse(newError(codeInvalidArgument, "status", "Unknown picture status: %s", args[0]))
+	}
+	return getPicturesByIndex(stub, statusIndex, []string{args[0]})
+}
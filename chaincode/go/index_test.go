@@ -0,0 +1,142 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// readPictureNames runs an index query and returns the names of the pictures found
+func readPictureNames(t *testing.T, stub *shim.MockStub, args ...string) string {
+	t.Helper()
+	records := []struct {
+		Key    string
+		Record picture
+	}{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, args...), &records)
+	if err != nil {
+		t.Fatal("Failed to decode pictures:", err)
+	}
+	names := []string{}
+	for _, record := range records {
+		names = append(names, record.Record.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// indexKeys lists the index entries of a picture found in state
+func indexKeys(t *testing.T, stub *shim.MockStub, name string) []string {
+	t.Helper()
+	keys := []string{}
+	for key := range stub.State {
+		if !strings.HasPrefix(key, "\x00") {
+			continue // not a composite key
+		}
+		_, attributes, err := stub.SplitCompositeKey(key)
+		if err == nil && len(attributes) > 0 && attributes[len(attributes)-1] == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPictureIndexes(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createArtist(t, stub, "henri-matisse", "Henri Matisse")
+	checkInvoke(t, stub, louvreAdmin, "initPicture", "picture2", "red", "50", "henri-matisse", "The Dance", "1910", "Oil on canvas", "260 x 391 cm", "GE-9673")
+	createPicture(t, stub, guggenheimUser, "picture3", "blue")
+
+	expected := []string{
+		compositeKey(t, stub, "generation~name", "blue", "picture1"),
+		compositeKey(t, stub, artistIndex, "claude-monet", "picture1"),
+		compositeKey(t, stub, ownerIndex, "LouvreMSP", louvreUser.id.Subject, "picture1"),
+		compositeKey(t, stub, statusIndex, statusAvailable, "picture1"),
+	}
+	sort.Strings(expected)
+	if keys := indexKeys(t, stub, "picture1"); strings.Join(keys, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Unexpected index entries %q", keys)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"organisation", []string{"getPicturesByOwner", "LouvreMSP"}, "picture1,picture2"},
+		{"member", []string{"getPicturesByOwner", "LouvreMSP", louvreAdmin.id.Subject}, "picture2"},
+		{"other organisation", []string{"getPicturesByOwner", "GuggenheimMSP"}, "picture3"},
+		{"unknown organisation", []string{"getPicturesByOwner", "OutsiderMSP"}, ""},
+		{"artist", []string{"getPicturesByArtist", "claude-monet"}, "picture1,picture3"},
+		{"other artist", []string{"getPicturesByArtist", "henri-matisse"}, "picture2"},
+		{"status", []string{"getPicturesByStatus", statusAvailable}, "picture1,picture2,picture3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if names := readPictureNames(t, stub, test.args...); names != test.expected {
+				t.Fatalf("Found %q, expected %q", names, test.expected)
+			}
+		})
+	}
+	e := checkInvokeError(t, stub, louvreUser, "Unknown picture status: missing", "getPicturesByStatus", "missing")
+	if e.Code != codeInvalidArgument || e.Field != "status" {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
+
+func TestPictureIndexesFollowChanges(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "blue")
+
+	// a transfer moves the picture to the owner~name entries of the new owner
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	if names := readPictureNames(t, stub, "getPicturesByOwner", "LouvreMSP"); names != "picture2" {
+		t.Fatalf("Unexpected Louvre pictures %q", names)
+	}
+	if names := readPictureNames(t, stub, "getPicturesByOwner", "GuggenheimMSP", guggenheimUser.id.Subject); names != "picture1" {
+		t.Fatalf("Unexpected Guggenheim pictures %q", names)
+	}
+	if stub.State[compositeKey(t, stub, ownerIndex, "LouvreMSP", louvreUser.id.Subject, "picture1")] != nil {
+		t.Fatal("The owner~name entry of the previous owner was not removed")
+	}
+
+	// so does a status change made by a loan
+	loanID := string(checkInvoke(t, stub, louvreUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	checkInvoke(t, stub, guggenheimUser, "approveLoan", loanID)
+	if names := readPictureNames(t, stub, "getPicturesByStatus", statusOnLoan); names != "picture1" {
+		t.Fatalf("Unexpected pictures on loan %q", names)
+	}
+	if names := readPictureNames(t, stub, "getPicturesByStatus", statusAvailable); names != "picture2" {
+		t.Fatalf("Unexpected available pictures %q", names)
+	}
+	checkInvoke(t, stub, guggenheimUser, "recordReturn", loanID)
+	if names := readPictureNames(t, stub, "getPicturesByStatus", statusOnLoan); names != "" {
+		t.Fatalf("Unexpected pictures on loan %q", names)
+	}
+
+	// a retired picture moves to the retired~generation~name index
+	checkInvoke(t, stub, guggenheimUser, "retirePicture", "picture1", statusDestroyed, "Fire in storage")
+	expected := []string{
+		compositeKey(t, stub, retiredGenerationIndex, "blue", "picture1"),
+		compositeKey(t, stub, artistIndex, "claude-monet", "picture1"),
+		compositeKey(t, stub, ownerIndex, "GuggenheimMSP", guggenheimUser.id.Subject, "picture1"),
+		compositeKey(t, stub, statusIndex, statusDestroyed, "picture1"),
+	}
+	sort.Strings(expected)
+	if keys := indexKeys(t, stub, "picture1"); strings.Join(keys, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Unexpected index entries %q", keys)
+	}
+	if names := readPictureNames(t, stub, "getPicturesByStatus", statusDestroyed); names != "picture1" {
+		t.Fatalf("Unexpected destroyed pictures %q", names)
+	}
+}
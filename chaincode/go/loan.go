@@ -0,0 +1,323 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Loans ====
+// A gallery requests the loan of a picture for a period, the owner approves it and the
+// borrower becomes the custodian of the picture while its owner stays the same. Custody
+// changes at approval, so the owner cannot approve a loan before its start date. The picture
+// is on loan, and cannot change owner, until the owner records its return.
+// Loans are stored under a composite key built from loanPrefix and the loan ID, which is the
+// ID of the requestLoan transaction.
+
+const loanPrefix = "loan"
+
+const loanDateLayout = "2006-01-02"
+
+const (
+	loanRequested = "requested"
+	loanActive    = "active"
+	loanReturned  = "returned"
+)
+
+type loan struct {
+	ObjectType  string   `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID          string   `json:"id"`
+	Picture     string   `json:"picture"`
+	Lender      identity `json:"lender"`
+	Borrower    identity `json:"borrower"`
+	StartDate   string   `json:"startDate"` //YYYY-MM-DD
+	EndDate     string   `json:"endDate"`   //YYYY-MM-DD, the return deadline
+	Status      string   `json:"status"`
+	RequestedAt string   `json:"requestedAt"`          //RFC3339
+	ApprovedAt  string   `json:"approvedAt,omitempty"` //RFC3339
+	ReturnedAt  string   `json:"returnedAt,omitempty"` //RFC3339
+}
+
+// overdue reports whether an active loan is past its return deadline
+func (l *loan) overdue(now time.Time) bool {
+	endDate, err := time.Parse(loanDateLayout, l.EndDate)
+	return err == nil && l.Status == loanActive && !now.Before(endDate.AddDate(0, 0, 1))
+}
+
+func getLoan(stub shim.ChaincodeStubInterface, loanID string) (*loan, error) {
+	loanKey, err := stub.CreateCompositeKey(loanPrefix, []string{loanID})
+	if err != nil {
+		return nil, err
+	}
+	loanAsBytes, err := stub.GetState(loanKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get loan: %s", err)
+	} else if loanAsBytes == nil {
+		return nil, newError(codeNotFound, "loanId", "Loan does not exist: %s", loanID)
+	}
+
+	l := &loan{}
+	err = json.Unmarshal(loanAsBytes, l)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode loan: %s", loanID)
+	}
+	return l, nil
+}
+
+func putLoan(stub shim.ChaincodeStubInterface, l *loan) error {
+	loanKey, err := stub.CreateCompositeKey(loanPrefix, []string{l.ID})
+	if err != nil {
+		return err
+	}
+	loanJSONasBytes, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(loanKey, loanJSONasBytes)
+}
+
+// ===========================================================================================
+// requestLoan - a gallery asks the owner of a picture to lend it for a period
+// ===========================================================================================
+func (t *SimpleChaincode) requestLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0           1              2
+	// "name", "2018-09-01", "2019-01-15"
+	pictureName := args[0]
+	startDate, _ := time.Parse(loanDateLayout, args[1])
+	endDate, _ := time.Parse(loanDateLayout, args[2])
+	if endDate.Before(startDate) {
+		return errorResponse(newError(codeInvalidArgument, "endDate", "The loan cannot end before it starts"))
+	}
+	fmt.Println("- start requestLoan ", pictureName)
+
+	borrower, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if pic.Owner == borrower {
+		return errorResponse(newError(codeConflict, "name", "Picture %s is already owned by %s", pictureName, borrower))
+	}
+	err = checkNotRetired(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	l := &loan{
+		ObjectType:  "loan",
+		ID:          stub.GetTxID(),
+		Picture:     pictureName,
+		Lender:      pic.Owner,
+		Borrower:    borrower,
+		StartDate:   startDate.Format(loanDateLayout),
+		EndDate:     endDate.Format(loanDateLayout),
+		Status:      loanRequested,
+		RequestedAt: now.Format(time.RFC3339),
+	}
+	err = putLoan(stub, l)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventLoanRequested, []string{pictureName}, l)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end requestLoan (success)")
+	return shim.Success([]byte(l.ID))
+}
+
+// ===========================================================================================
+// approveLoan - the owner of a picture approves a loan request, handing custody of the
+// picture to the borrower. The loan must have started and not ended yet.
+// ===========================================================================================
+func (t *SimpleChaincode) approveLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "loanID"
+	l, err := getLoan(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if l.Status != loanRequested {
+		return errorResponse(newError(codeConflict, "loanId", "Loan %s is %s", l.ID, l.Status))
+	}
+	pic, err := getPicture(stub, l.Picture)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if pic.Owner != l.Lender {
+		return errorResponse(newError(codeConflict, "loanId", "Picture %s changed owner since the loan was requested", l.Picture))
+	}
+	err = checkAvailable(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	startDate, err := time.Parse(loanDateLayout, l.StartDate)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if now.Before(startDate) {
+		return errorResponse(newError(codeConflict, "loanId", "Loan %s starts on %s and cannot be approved before", l.ID, l.StartDate))
+	}
+	endDate, err := time.Parse(loanDateLayout, l.EndDate)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !now.Before(endDate.AddDate(0, 0, 1)) {
+		return errorResponse(newError(codeConflict, "loanId", "Loan %s ended on %s", l.ID, l.EndDate))
+	}
+
+	borrower := l.Borrower
+	pic.Status = statusOnLoan
+	pic.Custodian = &borrower
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// a pending offer cannot be accepted while the picture is away
+	err = deleteTransferOffer(stub, l.Picture)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	l.Status = loanActive
+	l.ApprovedAt = now.Format(time.RFC3339)
+	err = putLoan(stub, l)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventLoanApproved, []string{l.Picture}, l)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// recordReturn - the owner of a picture confirms it is back, which ends the loan
+// ===========================================================================================
+func (t *SimpleChaincode) recordReturn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "loanID"
+	l, err := getLoan(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if l.Status != loanActive {
+		return errorResponse(newError(codeConflict, "loanId", "Loan %s is %s", l.ID, l.Status))
+	}
+	pic, err := getPicture(stub, l.Picture)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	_, err = releasePicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pic.Custodian = nil
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	l.Status = loanReturned
+	l.ReturnedAt = now.Format(time.RFC3339)
+	err = putLoan(stub, l)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventPictureReturned, []string{l.Picture}, l)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// activeLoan is an active loan as returned by getActiveLoans
+type activeLoan struct {
+	loan
+	Overdue bool `json:"overdue"` //the return deadline has passed
+}
+
+// ===========================================================================================
+// getActiveLoans - list the loans currently running, optionally only those where the given
+// organisation is the lender or the borrower. Overdue loans are flagged.
+// ===========================================================================================
+func (t *SimpleChaincode) getActiveLoans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "LouvreMSP" (optional)
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(loanPrefix, []string{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	loans := []activeLoan{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		l := loan{}
+		err = json.Unmarshal(responseRange.Value, &l)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if l.Status != loanActive {
+			continue
+		}
+		if len(args) == 1 && l.Lender.MSPID != args[0] && l.Borrower.MSPID != args[0] {
+			continue
+		}
+		loans = append(loans, activeLoan{loan: l, Overdue: l.overdue(now)})
+	}
+
+	loansJSONasBytes, err := json.Marshal(loans)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(loansJSONasBytes)
+}
@@ -0,0 +1,167 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func loanDate(days int) string {
+	return time.Now().UTC().AddDate(0, 0, days).Format(loanDateLayout)
+}
+
+func readActiveLoans(t *testing.T, stub *shim.MockStub, args ...string) []activeLoan {
+	t.Helper()
+	loans := []activeLoan{}
+	err := json.Unmarshal(checkInvoke(t, stub, louvreUser, append([]string{"getActiveLoans"}, args...)...), &loans)
+	if err != nil {
+		t.Fatal("Failed to decode loans:", err)
+	}
+	return loans
+}
+
+func TestRequestLoanErrors(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+	}{
+		{"too few arguments", guggenheimUser, []string{"picture1", loanDate(1)}, "Incorrect number of arguments. Expecting 3"},
+		{"invalid start date", guggenheimUser, []string{"picture1", "01/09/2018", loanDate(1)}, "Argument 2 (startDate) must be a date formatted as YYYY-MM-DD"},
+		{"invalid end date", guggenheimUser, []string{"picture1", loanDate(1), "soon"}, "Argument 3 (endDate) must be a date formatted as YYYY-MM-DD"},
+		{"ends before it starts", guggenheimUser, []string{"picture1", loanDate(10), loanDate(1)}, "The loan cannot end before it starts"},
+		{"not a gallery", outsiderUser, []string{"picture1", loanDate(1), loanDate(10)}, "requestLoan is reserved to gallery members, not OutsiderMSP"},
+		{"missing picture", guggenheimUser, []string{"picture2", loanDate(1), loanDate(10)}, "Picture does not exist: picture2"},
+		{"own picture", louvreUser, []string{"picture1", loanDate(1), loanDate(10)}, "is already owned by"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkInvokeError(t, stub, test.caller, test.message, append([]string{"requestLoan"}, test.args...)...)
+		})
+	}
+}
+
+func TestLoan(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	if event := lastEvent(t, stub); event.Type != eventLoanRequested {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	if loans := readActiveLoans(t, stub); len(loans) != 0 {
+		t.Fatalf("Requested loan listed as active: %+v", loans)
+	}
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "approveLoan")
+	checkInvokeError(t, stub, louvreUser, "Loan does not exist: unknown", "approveLoan", "unknown")
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture1", "approveLoan", loanID)
+	checkInvokeError(t, stub, louvreUser, "Loan "+loanID+" is requested", "recordReturn", loanID)
+
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimAdmin.id.MSPID, guggenheimAdmin.id.Subject)
+	checkInvoke(t, stub, louvreUser, "approveLoan", loanID)
+	if event := lastEvent(t, stub); event.Type != eventLoanApproved {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+
+	// the borrower holds the picture, the owner is unchanged and cannot give it away meanwhile
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Owner != louvreUser.id || pic.Status != statusOnLoan || pic.Custodian == nil || *pic.Custodian != guggenheimUser.id {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	checkInvokeError(t, stub, guggenheimAdmin, "No pending transfer offer for picture: picture1", "acceptTransfer", "picture1")
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available", "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available", "retirePicture", "picture1", statusLost, "Not returned")
+	checkInvokeError(t, stub, louvreUser, "Loan "+loanID+" is active", "approveLoan", loanID)
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 0 or 1", "getActiveLoans", "LouvreMSP", "GuggenheimMSP")
+	if loans := readActiveLoans(t, stub); len(loans) != 1 || loans[0].ID != loanID || loans[0].Overdue {
+		t.Fatalf("Unexpected loans %+v", loans)
+	}
+	if loans := readActiveLoans(t, stub, "GuggenheimMSP"); len(loans) != 1 {
+		t.Fatalf("Unexpected loans %+v", loans)
+	}
+	if loans := readActiveLoans(t, stub, "OutsiderMSP"); len(loans) != 0 {
+		t.Fatalf("Unexpected loans %+v", loans)
+	}
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "recordReturn")
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture1", "recordReturn", loanID)
+	checkInvoke(t, stub, louvreAdmin, "recordReturn", loanID)
+	if event := lastEvent(t, stub); event.Type != eventPictureReturned {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+
+	pic = checkPicture(t, stub, "picture1")
+	if pic.Status != statusAvailable || pic.Custodian != nil {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if loans := readActiveLoans(t, stub); len(loans) != 0 {
+		t.Fatalf("Returned loan listed as active: %+v", loans)
+	}
+	checkInvokeError(t, stub, louvreUser, "Loan "+loanID+" is returned", "recordReturn", loanID)
+}
+
+func TestApproveLoanAfterOwnerChange(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", louvreAdmin.id.MSPID, louvreAdmin.id.Subject)
+
+	checkInvokeError(t, stub, louvreAdmin, "changed owner since the loan was requested", "approveLoan", loanID)
+}
+
+func TestApproveEndedLoan(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(-10), loanDate(-1)))
+
+	checkInvokeError(t, stub, louvreUser, "ended on", "approveLoan", loanID)
+}
+
+func TestApproveLoanBeforeStart(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(2), loanDate(30)))
+
+	e := checkInvokeError(t, stub, louvreUser, "Loan "+loanID+" starts on "+loanDate(2)+" and cannot be approved before", "approveLoan", loanID)
+	if e.Code != codeConflict {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	if pic := checkPicture(t, stub, "picture1"); pic.Status != statusAvailable || pic.Custodian != nil {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+}
+
+func TestOverdueLoan(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(-10), loanDate(5)))
+	checkInvoke(t, stub, louvreUser, "approveLoan", loanID)
+
+	// the return deadline passes while the picture is away
+	err := inTransaction(stub, func() error {
+		l, err := getLoan(stub, loanID)
+		if err != nil {
+			return err
+		}
+		l.EndDate = loanDate(-1)
+		return putLoan(stub, l)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loans := readActiveLoans(t, stub); len(loans) != 1 || !loans[0].Overdue {
+		t.Fatalf("Unexpected loans %+v", loans)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Physical location ====
+// Where a picture physically is has nothing to do with who owns it: a work may sit in the
+// storage of its owner, hang at a borrower's or travel between the two. moveLocation records
+// the site, building, room and storage unit of a picture, or that it is in transit, with who
+// moved it and when. The location is kept on the picture, so getHistoryForPicture and
+// getProvenance show every move, and the location~name index, which putPicture maintains
+// like the other indexes of index.go, finds the works at a site or in transit.
+//
+// Members of the owning organisation, or of the custodian's while the work is on loan, move
+// it. Pictures recorded before the location model have no location until they are moved.
+
+const locationIndex = "location~name" //site, building, room, picture name
+
+// locationInTransit is the site of a picture in transit, which then has no building, room or
+// storage unit. It cannot be used as the name of an actual site.
+const locationInTransit = "in-transit"
+
+type location struct {
+	Site        string   `json:"site"` //e.g. "Musee du Louvre, Paris", or in-transit
+	Building    string   `json:"building,omitempty"`
+	Room        string   `json:"room,omitempty"`
+	StorageUnit string   `json:"storageUnit,omitempty"` //rack, crate or drawer, e.g. "Rack 12"
+	MovedBy     identity `json:"movedBy"`
+	MovedAt     string   `json:"movedAt"` //RFC3339 transaction timestamp
+}
+
+// samePlace reports whether two locations, possibly nil, designate the same place
+func samePlace(a, b *location) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Site == b.Site && a.Building == b.Building && a.Room == b.Room && a.StorageUnit == b.StorageUnit
+}
+
+// ===========================================================================================
+// moveLocation - a member of the owning or the custodian organisation records where a
+// picture now is, or that it is in transit
+// ===========================================================================================
+func (t *SimpleChaincode) moveLocation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                  1                      2                 3              4
+	// "name", "Musee du Louvre, Paris", "Richelieu wing" (optional), "Room 12" (optional), "Rack 3" (optional)
+	// "name", "in-transit"
+	pictureName := args[0]
+	newLocation := &location{Site: strings.TrimSpace(args[1])}
+	if len(args) > 2 {
+		newLocation.Building = strings.TrimSpace(args[2])
+	}
+	if len(args) > 3 {
+		newLocation.Room = strings.TrimSpace(args[3])
+	}
+	if len(args) > 4 {
+		newLocation.StorageUnit = strings.TrimSpace(args[4])
+	}
+	if newLocation.Site == locationInTransit && (newLocation.Building != "" || newLocation.Room != "" || newLocation.StorageUnit != "") {
+		return errorResponse(newError(codeInvalidArgument, "site", "A picture in transit has no building, room or storage unit"))
+	}
+	if newLocation.Building == "" && newLocation.Room != "" {
+		return errorResponse(newError(codeInvalidArgument, "building", "A room is given without its building"))
+	}
+	fmt.Println("- start moveLocation ", pictureName, newLocation.Site)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !isHolder(caller, pic) {
+		return errorResponse(newError(codeForbidden, "", "Only members of the owner or the custodian may move picture %s", pictureName))
+	}
+	if samePlace(pic.Location, newLocation) {
+		return errorResponse(newError(codeConflict, "site", "Picture %s is already at this location", pictureName))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	newLocation.MovedBy = caller
+	newLocation.MovedAt = now.Format(time.RFC3339)
+	pic.Location = newLocation
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventPictureMoved, []string{pictureName}, newLocation)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end moveLocation (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// getPicturesAtSite - find the pictures at a site, or in one of its buildings or rooms, using
+// the location~name index
+// ===========================================================================================
+func (t *SimpleChaincode) getPicturesAtSite(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                          1                           2
+	// "Musee du Louvre, Paris", "Richelieu wing" (optional), "Room 12" (optional)
+	if args[0] == locationInTransit {
+		return errorResponse(newError(codeInvalidArgument, "site", "Use getPicturesInTransit for the pictures in transit"))
+	}
+	return getPicturesByIndex(stub, locationIndex, args)
+}
+
+// ===========================================================================================
+// getPicturesInTransit - find the pictures in transit using the location~name index
+// ===========================================================================================
+func (t *SimpleChaincode) getPicturesInTransit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return getPicturesByIndex(stub, locationIndex, []string{locationInTransit})
+}
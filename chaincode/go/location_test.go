@@ -0,0 +1,180 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+func TestMoveLocation(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	if pic := checkPicture(t, stub, "picture1"); pic.Location != nil {
+		t.Fatalf("Unexpected location %+v", pic.Location)
+	}
+
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture1", " Musee du Louvre, Paris ", "Richelieu wing", "Room 12", "Rack 3")
+	event := lastEvent(t, stub)
+	if event.Type != eventPictureMoved || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	pic := checkPicture(t, stub, "picture1")
+	if l := pic.Location; l == nil || l.Site != "Musee du Louvre, Paris" || l.Building != "Richelieu wing" || l.Room != "Room 12" || l.StorageUnit != "Rack 3" || l.MovedBy != louvreUser.id || l.MovedAt == "" {
+		t.Fatalf("Unexpected location %+v", pic.Location)
+	}
+	if pic.Owner != louvreUser.id || pic.Custodian != nil {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+		code    string
+		field   string
+	}{
+		{"same place", louvreAdmin, []string{"picture1", "Musee du Louvre, Paris", "Richelieu wing", "Room 12", "Rack 3"}, "Picture picture1 is already at this location", codeConflict, "site"},
+		{"in transit with a room", louvreUser, []string{"picture1", locationInTransit, "", "Room 12"}, "A picture in transit has no building, room or storage unit", codeInvalidArgument, "site"},
+		{"room without building", louvreUser, []string{"picture1", "Musee du Louvre, Paris", " ", "Room 12"}, "A room is given without its building", codeInvalidArgument, "building"},
+		{"empty site", louvreUser, []string{"picture1", ""}, "Argument 2 (site) must be a non-empty string", codeInvalidArgument, "site"},
+		{"other organisation", guggenheimUser, []string{"picture1", locationInTransit}, "Only members of the owner or the custodian may move picture picture1", codeForbidden, ""},
+		{"missing picture", louvreUser, []string{"picture2", locationInTransit}, "Picture does not exist: picture2", codeNotFound, "name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkInvokeError(t, stub, test.caller, test.message, append([]string{"moveLocation"}, test.args...)...)
+			if e.Code != test.code || e.Field != test.field {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+
+	// another storage unit of the same room is another place
+	checkInvoke(t, stub, louvreAdmin, "moveLocation", "picture1", "Musee du Louvre, Paris", "Richelieu wing", "Room 12", "Rack 4")
+	if l := checkPicture(t, stub, "picture1").Location; l.StorageUnit != "Rack 4" || l.MovedBy != louvreAdmin.id {
+		t.Fatalf("Unexpected location %+v", l)
+	}
+}
+
+func TestGetPicturesAtSite(t *testing.T) {
+	stub := newTestStub()
+	for _, name := range []string{"picture1", "picture2", "picture3", "picture4", "picture5"} {
+		createPicture(t, stub, louvreUser, name, "blue")
+	}
+	louvre := "Musee du Louvre, Paris"
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture1", louvre, "Richelieu wing", "Room 12")
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture2", louvre, "Richelieu wing", "Room 14", "Rack 3")
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture3", louvre, "Denon wing")
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture4", "Lens")
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture5", locationInTransit)
+
+	queries := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"getPicturesAtSite", louvre}, "picture1,picture2,picture3"},
+		{[]string{"getPicturesAtSite", louvre, "Richelieu wing"}, "picture1,picture2"},
+		{[]string{"getPicturesAtSite", louvre, "Richelieu wing", "Room 14"}, "picture2"},
+		{[]string{"getPicturesAtSite", "Lens"}, "picture4"},
+		{[]string{"getPicturesAtSite", "Musee d'Orsay, Paris"}, ""},
+		{[]string{"getPicturesInTransit"}, "picture5"},
+	}
+	for _, query := range queries {
+		if names := readPictureNames(t, stub, query.args...); names != query.expected {
+			t.Fatalf("%v returned %s, expected %s", query.args, names, query.expected)
+		}
+	}
+
+	// a move replaces the index entry of the previous location
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture3", locationInTransit)
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture5", "Lens")
+	if names := readPictureNames(t, stub, "getPicturesInTransit"); names != "picture3" {
+		t.Fatalf("Unexpected pictures in transit %s", names)
+	}
+	if names := readPictureNames(t, stub, "getPicturesAtSite", louvre); names != "picture1,picture2" {
+		t.Fatalf("Unexpected pictures %s", names)
+	}
+	if names := readPictureNames(t, stub, "getPicturesAtSite", "Lens"); names != "picture4,picture5" {
+		t.Fatalf("Unexpected pictures %s", names)
+	}
+
+	checkInvokeError(t, stub, outsiderUser, "Use getPicturesInTransit for the pictures in transit", "getPicturesAtSite", locationInTransit)
+}
+
+func TestMoveLocationDuringLoan(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	checkInvoke(t, stub, louvreUser, "approveLoan", loanID)
+
+	// the borrower records the arrival, the owner still follows the picture
+	checkInvoke(t, stub, louvreUser, "moveLocation", "picture1", locationInTransit)
+	checkInvoke(t, stub, guggenheimUser, "moveLocation", "picture1", "Guggenheim Bilbao", "Main building", "Gallery 205")
+	if l := checkPicture(t, stub, "picture1").Location; l.Site != "Guggenheim Bilbao" || l.MovedBy != guggenheimUser.id {
+		t.Fatalf("Unexpected location %+v", l)
+	}
+	checkInvokeError(t, stub, outsiderUser, "moveLocation is reserved to gallery members", "moveLocation", "picture1", locationInTransit)
+
+	// once returned, the borrower no longer moves it, the location stays as last recorded
+	checkInvoke(t, stub, louvreUser, "recordReturn", loanID)
+	checkInvokeError(t, stub, guggenheimUser, "Only members of the owner or the custodian may move picture picture1", "moveLocation", "picture1", locationInTransit)
+	if pic := checkPicture(t, stub, "picture1"); pic.Location.Site != "Guggenheim Bilbao" || pic.Custodian != nil {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if names := readPictureNames(t, stub, "getPicturesAtSite", "Guggenheim Bilbao"); names != "picture1" {
+		t.Fatalf("Unexpected pictures %s", names)
+	}
+}
+
+func TestProvenanceOfMoves(t *testing.T) {
+	stub := &historyStub{MockStub: newTestStub(), history: map[string][]*queryresult.KeyModification{}}
+	start := time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC)
+	step := func(caller testClient, args ...string) {
+		t.Helper()
+		checkInvoke(t, stub.MockStub, caller, args...)
+		stub.record("picture1", start.AddDate(0, 0, len(stub.history["picture1"])))
+	}
+
+	createArtist(t, stub.MockStub, "claude-monet", "Claude Monet")
+	step(louvreUser, "initPicture", "picture1", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+	step(louvreUser, "moveLocation", "picture1", locationInTransit)
+	step(louvreUser, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	step(guggenheimUser, "moveLocation", "picture1", "Guggenheim Bilbao", "Main building")
+
+	response := new(SimpleChaincode).getProvenance(stub, []string{"picture1"})
+	if response.Status != shim.OK {
+		t.Fatal("getProvenance failed:", response.Message)
+	}
+	entries := []provenanceEntry{}
+	err := json.Unmarshal(response.Payload, &entries)
+	if err != nil {
+		t.Fatalf("Failed to decode provenance %s: %s", response.Payload, err)
+	}
+	events := []string{provenanceCreated, provenanceMoved, provenanceTransferred, provenanceMoved}
+	if len(entries) != len(events) {
+		t.Fatalf("Unexpected provenance %s", response.Payload)
+	}
+	for i, event := range events {
+		if entries[i].Event != event {
+			t.Fatalf("Unexpected entry %d: %+v", i, entries[i])
+		}
+	}
+	// a transfer keeps the location, it is only reported with the moves
+	if entries[2].Location != nil {
+		t.Fatalf("Unexpected entry %+v", entries[2])
+	}
+	if l := entries[3].Location; l == nil || l.Site != "Guggenheim Bilbao" || l.Building != "Main building" || l.MovedBy != guggenheimUser.id {
+		t.Fatalf("Unexpected entry %+v", entries[3])
+	}
+	if l := entries[1].Location; l == nil || l.Site != locationInTransit || entries[1].SubmittedBy == nil || *entries[1].SubmittedBy != louvreUser.id {
+		t.Fatalf("Unexpected entry %+v", entries[1])
+	}
+}
@@ -4,25 +4,128 @@
 
 // ====CHAINCODE EXECUTION SAMPLES (CLI) ==================
 
+// ==== Artists (see artist.go), pictures refer to an existing artist by its ID ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createArtist","claude-monet","Claude Monet","1840","1926","French","500019484","Q296"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createArtist","henri-matisse","Henri Matisse","1869","1954","French","500017300","Q5589"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createArtist","pablo-picasso","Pablo Picasso","1881","1973","Spanish","500009666","Q5593"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["updateArtist","claude-monet","Claude Monet","1840","1926","French","500019484","Q296"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["deleteArtist","pablo-picasso"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readArtist","claude-monet"]}'
+
 // ==== Invoke pictures ====
-// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["initPicture","picture1","blue","35","tom"]}'
-// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["initPicture","picture2","red","50","tom"]}'
-// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["initPicture","picture3","blue","70","tom"]}'
-// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["transferPicture","picture2","jerry"]}'
-// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["transferPicturesBasedOnGeneration","blue","jerry"]}'
-// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["delete","picture1"]}'
+// The owner of a new picture is the identity submitting initPicture (MSP ID plus certificate subject).
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["initPicture","picture1","blue","35","claude-monet","Water Lilies","1906","Oil on canvas","89.9 x 94.1 cm","RF 1963-5"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["initPicture","picture2","red","50","henri-matisse","The Dance","1910","Oil on canvas","260 x 391 cm","GE-9673"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["initPicture","picture3","blue","70","pablo-picasso","The Old Guitarist","1903","Oil on panel","122.9 x 82.6 cm","1926.253"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["transferPicture","picture2","GuggenheimMSP","CN=User1@guggenheim.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["transferPicturesBasedOnGeneration","blue","GuggenheimMSP","CN=User1@guggenheim.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US"]}'
+
+// ==== Retirement (see retire.go), pictures are never deleted ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["retirePicture","picture1","deaccessioned","Sold at public auction, board decision 2018-07"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["retirePicture","picture2","lost","Missing after the move"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["recoverPicture","picture2","Found in another storage room"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["retirePicture","picture2","restituted","Returned to the heirs of the collector"]}'
+
+// ==== Two-phase transfers (see transfer.go) ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["offerTransfer","picture3","GuggenheimMSP","CN=User1@guggenheim.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US","14"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["acceptTransfer","picture3"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["rejectTransfer","picture3"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["cancelTransfer","picture3"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["expireTransferOffers"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readTransferOffer","picture3"]}'
+
+// ==== Sales (see sale.go), settled when the buyer calls acceptTransfer, the price is private data ====
+// export PRICE=$(echo -n "{\"price\":\"1500000.00\",\"currency\":\"EUR\",\"salt\":\"$(openssl rand -hex 32)\"}" | base64 | tr -d \\n)
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["sellPicture","picture2","GuggenheimMSP","CN=User1@guggenheim.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US"]}' --transient "{\"price\":\"$PRICE\"}"
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getSalesForPicture","picture2"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getSalesByOwner","GuggenheimMSP"]}'
+
+// ==== Auctions (see auction.go), the auction ID is the ID of the createAuction transaction ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createAuction","picture3","english","EUR","100000.00","2018-06-30T18:00:00Z"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["placeBid","<auctionID>","120000.00"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createAuction","picture1","sealed","EUR","100000.00","2018-06-30T18:00:00Z","2018-07-02T18:00:00Z"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["commitBid","<auctionID>","<hex sha256 of amount:salt>"]}'
+// export BID=$(echo -n '{"amount":"120000.00","salt":"<salt>"}' | base64 | tr -d \\n)
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["revealBid","<auctionID>"]}' --transient "{\"bid\":\"$BID\"}"
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["closeAuction","<auctionID>"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["cancelAuction","<auctionID>"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readAuction","<auctionID>"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readAuctionBids","<auctionID>"]}'
+
+// ==== Loans (see loan.go), the loan ID is the ID of the requestLoan transaction ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["requestLoan","picture2","2018-09-01","2019-01-15"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["approveLoan","<loanID>"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["recordReturn","<loanID>"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getActiveLoans","LouvreMSP"]}'
+
+// ==== Stolen art registry (see stolen.go), a flagged picture has the stolen status ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["reportStolen","picture2","OCBC-2018-0412","Taken from the reserve during the move"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["clearStolenFlag","picture2","Recovered by the police, owner of record confirmed"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["reportTransferAttempt","picture2","GuggenheimMSP","CN=User1@guggenheim.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["checkTitle","picture2"]}'
+
+// ==== Exhibitions and collections (see exhibition.go), the ID is the ID of the creating transaction ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createExhibition","Monet, the late years","Musee du Louvre","2018-09-01","2019-01-15"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["createCollection","Impressionist landscapes"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["addToExhibition","<exhibitionID>","picture1"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["removeFromExhibition","<exhibitionID>","picture1"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readExhibition","<exhibitionID>"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getExhibitionsForPicture","picture1"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getExhibitionsByDateRange","2018-10-01","2018-12-31"]}'
+
+// ==== Private data (see private.go), prices and valuations go in the transient map, base64 encoded ====
+// export PRIVATE_DETAILS=$(echo -n "{\"insuranceValue\":\"2500000.00\",\"currency\":\"EUR\",\"insurer\":\"AXA ART\",\"valuationDate\":\"2018-06-01\",\"salt\":\"$(openssl rand -hex 32)\"}" | base64 | tr -d \\n)
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["setPicturePrivateDetails","picture1"]}' --transient "{\"privateDetails\":\"$PRIVATE_DETAILS\"}"
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readPicturePrivateDetails","picture1"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["readSalePrice","picture2","<saleTxID>"]}'
+
+// ==== Image fingerprints (see fingerprint.go), hashes of reference photographs, never the images ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["addImageFingerprint","picture1","<hex sha256 of the image file>","c3e1f0f0e0c08080","front, raking light"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getImageFingerprints","picture1"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["verifyImage","c3e1f0f0e0c08081","6"]}'
+
+// ==== Certificates of authenticity (see certificate.go), issued by clients with the artExpert attribute ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["issueCertificate","picture1","Autograph work, examined under UV and infrared in June 2018"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["revokeCertificate","<certificateID>","New technical examination dates the canvas after 1926"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getCertificates","picture1"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["verifyCertificate","<certificateID>","LouvreMSP"]}'
+
+// ==== Condition reports (see condition.go), the latest grade is shown by readPicture ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["addConditionReport","picture1","2018-09-03","good","Slight craquelure, lower left corner","<hex sha256 of the report document>"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getConditionReports","picture1"]}'
+
+// ==== Physical location (see location.go), independent of the owner ====
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["moveLocation","picture1","in-transit"]}'
+// peer chaincode invoke -C myc1 -n pictures -c '{"Args":["moveLocation","picture1","Guggenheim Bilbao","Main building","Gallery 205"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesAtSite","Guggenheim Bilbao"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesInTransit"]}'
+
+// ==== Function registry (see registry.go), arguments and roles of every function above ====
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["describeFunctions"]}'
 
 // ==== Query pictures ====
 // peer chaincode query -C myc1 -n pictures -c '{"Args":["readPicture","picture1"]}'
 // peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByRange","picture1","picture3"]}'
 // peer chaincode query -C myc1 -n pictures -c '{"Args":["getHistoryForPicture","picture1"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getProvenance","picture1"]}'
+
+// Index Query (see index.go), supported by LevelDB as well as CouchDB:
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByOwner","LouvreMSP","CN=User1@louvre.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByArtist","claude-monet"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByStatus","onLoan"]}'
 
 // Rich Query (Only supported if CouchDB is used as state database):
-// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPicturesByOwner","tom"]}'
-// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPictures","{\"selector\":{\"owner\":\"tom\"}}"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPicturesByOwner","LouvreMSP","CN=User1@louvre.artgalleries.com,OU=client,L=San Francisco,ST=California,C=US"]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPictures","{\"filters\":[{\"field\":\"owner.mspId\",\"operator\":\"eq\",\"value\":\"LouvreMSP\"}]}"]}'
+
+// Range Query with Pagination, the result holds the bookmark of the next page:
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByRangeWithPagination","picture1","picture9","3",""]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByGenerationWithPagination","blue","3",""]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["getPicturesByGenerationWithPagination","blue","3","","include"]}'
 
 // Rich Query with Pagination (Only supported if CouchDB is used as state database):
-// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPicturesWithPagination","{\"selector\":{\"owner\":\"tom\"}}","3",""]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPicturesWithPagination","{\"filters\":[{\"field\":\"owner.mspId\",\"operator\":\"eq\",\"value\":\"LouvreMSP\"}]}","3",""]}'
+// peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPicturesByOwnerWithPagination","LouvreMSP","","3",""]}'
 
 // INDEXES TO SUPPORT COUCHDB RICH QUERIES
 //
@@ -33,8 +136,9 @@
 // CouchDB index JSON syntax as documented at:
 // http://docs.couchdb.org/en/2.1.1/api/database/find.html#db-index
 //
-// This pictures02 example chaincode demonstrates a packaged
-// index which you can find in META-INF/statedb/couchdb/indexes/indexOwner.json.
+// This chaincode packages an index for each of its parameterized queries in
+// META-INF/statedb/couchdb/indexes: indexOwner.json, indexGeneration.json, indexArtist.json,
+// indexStatus.json, and indexSizeSortDesc.json and indexYearSortDesc.json for sorted filters.
 // For deployment of chaincode to production environments, it is recommended
 // to define any indexes alongside chaincode so that the chaincode and supporting indexes
 // are deployed automatically as a unit, once the chaincode has been installed on a peer and
@@ -63,19 +167,31 @@
 // Index for docType, owner.
 //
 // Example curl command line to define index in the CouchDB channel_chaincode database
-// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"owner\"]},\"name\":\"indexOwner\",\"ddoc\":\"indexOwnerDoc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"owner.mspId\",\"owner.subject\"]},\"name\":\"indexOwner\",\"ddoc\":\"indexOwnerDoc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
 //
 
-// Index for docType, owner, size (descending order).
+// Index for docType, size (descending order).
 //
 // Example curl command line to define index in the CouchDB channel_chaincode database
-// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[{\"size\":\"desc\"},{\"docType\":\"desc\"},{\"owner\":\"desc\"}]},\"ddoc\":\"indexSizeSortDoc\", \"name\":\"indexSizeSortDesc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[{\"size\":\"desc\"},{\"docType\":\"desc\"}]},\"ddoc\":\"indexSizeSortDoc\", \"name\":\"indexSizeSortDesc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
+
+// Index for docType, year (descending order).
+//
+// Example curl command line to define index in the CouchDB channel_chaincode database
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[{\"year\":\"desc\"},{\"docType\":\"desc\"}]},\"ddoc\":\"indexYearSortDoc\", \"name\":\"indexYearSortDesc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
+
+// Indexes for docType, generation / artist / status.
+//
+// Example curl command lines to define the indexes in the CouchDB channel_chaincode database
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"generation\"]},\"name\":\"indexGeneration\",\"ddoc\":\"indexGenerationDoc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"artistId\"]},\"name\":\"indexArtist\",\"ddoc\":\"indexArtistDoc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"status\"]},\"name\":\"indexStatus\",\"ddoc\":\"indexStatusDoc\",\"type\":\"json\"}" http://hostname:port/myc1_pictures/_index
 
-// Rich Query with index design doc and index name specified (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPictures","{\"selector\":{\"docType\":\"picture\",\"owner\":\"tom\"}, \"use_index\":[\"_design/indexOwnerDoc\", \"indexOwner\"]}"]}'
+// Filter with a range and a sort, served by indexYearSortDesc (Only supported if CouchDB is used as state database):
+//   peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPictures","{\"filters\":[{\"field\":\"year\",\"operator\":\"gte\",\"value\":1870},{\"field\":\"year\",\"operator\":\"lt\",\"value\":1900}],\"sort\":[{\"field\":\"year\",\"order\":\"desc\"}],\"limit\":20}"]}'
 
-// Rich Query with index design doc specified only (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPictures","{\"selector\":{\"docType\":{\"$eq\":\"picture\"},\"owner\":{\"$eq\":\"tom\"},\"size\":{\"$gt\":0}},\"fields\":[\"docType\",\"owner\",\"size\"],\"sort\":[{\"size\":\"desc\"}],\"use_index\":\"_design/indexSizeSortDoc\"}"]}'
+// Filter with an "in" condition, sorted by size (Only supported if CouchDB is used as state database):
+//   peer chaincode query -C myc1 -n pictures -c '{"Args":["queryPictures","{\"filters\":[{\"field\":\"status\",\"operator\":\"in\",\"value\":[\"available\",\"onLoan\"]}],\"sort\":[{\"field\":\"size\",\"order\":\"desc\"}]}"]}'
 
 package main
 
@@ -96,11 +212,47 @@ type SimpleChaincode struct {
 }
 
 type picture struct {
-	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
-	Generation      string `json:"generation"`
-	Size       int    `json:"size"`
-	Owner      string `json:"owner"`
+	ObjectType         string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name               string    `json:"name"`    //the fieldtags are needed to keep case from bouncing around
+	Generation         string    `json:"generation"`
+	Size               int       `json:"size"`
+	Owner              identity  `json:"owner"`
+	ArtistID           string    `json:"artistId"` //ID of the artist record, see artist.go
+	Title              string    `json:"title"`
+	Year               int       `json:"year"`
+	Medium             string    `json:"medium"`
+	Dimensions         string    `json:"dimensions"` //free text as catalogued, e.g. "89.9 x 94.1 cm"
+	InventoryNumber    string    `json:"inventoryNumber"`
+	Status             string    `json:"status"`                       //availability for transfers, see the status constants
+	Custodian          *identity `json:"custodian,omitempty"`          //who holds the picture when it is not its owner, e.g. a borrower
+	LastModifiedBy     *identity `json:"lastModifiedBy,omitempty"`     //client that submitted the last change, for getProvenance
+	RetirementReason   string    `json:"retirementReason,omitempty"`   //why a retired picture left the collection, see retire.go
+	RetiredAt          string    `json:"retiredAt,omitempty"`          //RFC3339 transaction timestamp of the retirement
+	RecoveryReason     string    `json:"recoveryReason,omitempty"`     //how a lost or stolen picture was last recovered, see retire.go
+	RecoveredAt        string    `json:"recoveredAt,omitempty"`        //RFC3339 transaction timestamp of the last recovery
+	PrivateDetailsHash string    `json:"privateDetailsHash,omitempty"` //hash of the private details in the owner's collection, see private.go
+	ConditionGrade     string    `json:"conditionGrade,omitempty"`     //grade of the latest condition report, see condition.go
+	ConditionDate      string    `json:"conditionDate,omitempty"`      //YYYY-MM-DD of the latest condition report
+	Location           *location `json:"location,omitempty"`           //where the picture physically is, see location.go
+}
+
+// picture statuses. Only available pictures may change owner by transfer, offer or sale.
+// Retired pictures have one of the statuses of retire.go.
+const (
+	statusAvailable = "available"
+	statusAtAuction = "atAuction"
+	statusOnLoan    = "onLoan"
+)
+
+// checkAvailable verifies a picture is not held by an auction, a loan or similar process
+func checkAvailable(pic *picture) error {
+	if pic.Status != statusAvailable {
+		return newError(codeConflict, "", "Picture %s is not available, its status is %s", pic.Name, pic.Status)
+	}
+	if pic.Custodian != nil {
+		return newError(codeConflict, "", "Picture %s is in the custody of %s", pic.Name, pic.Custodian)
+	}
+	return nil
 }
 
 // ===================================================================================
@@ -125,33 +277,24 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "initPicture" { //create a new picture
-		return t.initPicture(stub, args)
-	} else if function == "transferPicture" { //change owner of a specific picture
-		return t.transferPicture(stub, args)
-	} else if function == "transferPicturesBasedOnGeneration" { //transfer all pictures of a certain generation
-		return t.transferPicturesBasedOnGeneration(stub, args)
-	} else if function == "delete" { //delete a picture
-		return t.delete(stub, args)
-	} else if function == "readPicture" { //read a picture
-		return t.readPicture(stub, args)
-	} else if function == "queryPicturesByOwner" { //find pictures for owner X using rich query
-		return t.queryPicturesByOwner(stub, args)
-	} else if function == "queryPictures" { //find pictures based on an ad hoc rich query
-		return t.queryPictures(stub, args)
-	} else if function == "getHistoryForPicture" { //get history of values for a picture
-		return t.getHistoryForPicture(stub, args)
-	} else if function == "getPicturesByRange" { //get pictures based on range query
-		return t.getPicturesByRange(stub, args)
-	} else if function == "getPicturesByRangeWithPagination" {
-		return t.getPicturesByRangeWithPagination(stub, args)
-	} else if function == "queryPicturesWithPagination" {
-		return t.queryPicturesWithPagination(stub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	// Look the function up in the registry, see registry.go
+	f := lookupFunction(function)
+	if f == nil {
+		fmt.Println("invoke did not find func: " + function) //error
+		return errorResponse(newError(codeInvalidArgument, "", "Received unknown function invocation"))
+	}
+
+	// ==== Input sanitation ====
+	err := f.checkArgs(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = f.checkRole(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return f.handler(t, stub, args)
 }
 
 // ============================================================
@@ -160,58 +303,84 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *SimpleChaincode) initPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var err error
 
-	//   0       1       2     3
-	// "asdf", "blue", "35", "bob"
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
+	//   0       1       2       3                4                5       6                 7                  8
+	// "asdf", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5"
+	// argument count, non-empty strings and integers are checked by Invoke, see registry.go
 
 	// ==== Input sanitation ====
 	fmt.Println("- start init picture")
-	if len(args[0]) <= 0 {
-		return shim.Error("1st argument must be a non-empty string")
-	}
-	if len(args[1]) <= 0 {
-		return shim.Error("2nd argument must be a non-empty string")
-	}
-	if len(args[2]) <= 0 {
-		return shim.Error("3rd argument must be a non-empty string")
-	}
-	if len(args[3]) <= 0 {
-		return shim.Error("4th argument must be a non-empty string")
-	}
 	pictureName := args[0]
 	generation := strings.ToLower(args[1])
-	owner := strings.ToLower(args[3])
-	size, err := strconv.Atoi(args[2])
+	size, _ := strconv.Atoi(args[2])
+	artistID := args[3]
+	title := strings.TrimSpace(args[4])
+	year, _ := strconv.Atoi(args[5])
+	now, err := txTime(stub)
 	if err != nil {
-		return shim.Error("3rd argument must be a numeric string")
+		return errorResponse(err)
+	}
+	if year <= 0 || year > now.Year() {
+		return errorResponse(newError(codeInvalidArgument, "year", "6th argument (year) must be a year between 1 and the current year"))
+	}
+	medium := strings.TrimSpace(args[6])
+	dimensions := strings.TrimSpace(args[7])
+	inventoryNumber := strings.TrimSpace(args[8])
+
+	// ==== The submitting client becomes the owner ====
+	owner, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	// ==== Check if picture already exists ====
 	pictureAsBytes, err := stub.GetState(pictureName)
 	if err != nil {
-		return shim.Error("Failed to get picture: " + err.Error())
+		return errorResponse(fmt.Errorf("Failed to get picture: %s", err))
 	} else if pictureAsBytes != nil {
 		fmt.Println("This picture already exists: " + pictureName)
-		return shim.Error("This picture already exists: " + pictureName)
+		return errorResponse(newError(codeAlreadyExists, "name", "This picture already exists: %s", pictureName))
+	}
+
+	// ==== The artist must be registered, see artist.go ====
+	artist, err := getArtist(stub, artistID)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	// ==== Create picture object and marshal to JSON ====
 	objectType := "picture"
-	picture := &picture{objectType, pictureName, generation, size, owner}
+	picture := &picture{
+		ObjectType:      objectType,
+		Name:            pictureName,
+		Generation:      generation,
+		Size:            size,
+		Owner:           owner,
+		ArtistID:        artist.ID,
+		Title:           title,
+		Year:            year,
+		Medium:          medium,
+		Dimensions:      dimensions,
+		InventoryNumber: inventoryNumber,
+		Status:          statusAvailable,
+		LastModifiedBy:  &owner,
+	}
+	// ==== Optional private details, from the transient map, see private.go ====
+	_, err = storePrivateDetails(stub, picture)
+	if err != nil {
+		return errorResponse(err)
+	}
 	pictureJSONasBytes, err := json.Marshal(picture)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	//Alternatively, build the picture json string manually if you don't want to use struct marshalling
-	//pictureJSONasString := `{"docType":"Picture",  "name": "` + pictureName + `", "generation": "` + generation + `", "size": ` + strconv.Itoa(size) + `, "owner": "` + owner + `"}`
+	//pictureJSONasString := `{"docType":"Picture",  "name": "` + pictureName + `", "generation": "` + generation + `", "size": ` + strconv.Itoa(size) + `, "owner": {"mspId": "` + owner.MSPID + `", "subject": "` + owner.Subject + `"}, "artistId": "` + artistID + `", ...}`
 	//pictureJSONasBytes := []byte(str)
 
 	// === Save picture to state ===
 	err = stub.PutState(pictureName, pictureJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	//  ==== Index the picture to enable generation-based range queries, e.g. return all blue pictures ====
@@ -219,15 +388,17 @@ func (t *SimpleChaincode) initPicture(stub shim.ChaincodeStubInterface, args []s
 	//  The key is a composite key, with the elements that you want to range query on listed first.
 	//  In our case, the composite key is based on indexName~generation~name.
 	//  This will enable very efficient state range queries based on composite keys matching indexName~generation~*
-	indexName := "generation~name"
-	generationNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{picture.Generation, picture.Name})
+	//  Only the key name is needed, no need to store a duplicate copy of the picture.
+	//  The picture is indexed by owner, artist and status the same way, see index.go
+	err = indexPicture(stub, nil, picture)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventPictureCreated, []string{picture.Name}, picture)
+	if err != nil {
+		return errorResponse(err)
 	}
-	//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the picture.
-	//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
-	value := []byte{0x00}
-	stub.PutState(generationNameIndexKey, value)
 
 	// ==== Picture saved and indexed. Return success ====
 	fmt.Println("- end init picture")
@@ -235,115 +406,149 @@ func (t *SimpleChaincode) initPicture(stub shim.ChaincodeStubInterface, args []s
 }
 
 // ===============================================
-// readPicture - read a picture from chaincode state
+// getPicture - read and decode a picture from chaincode state
 // ===============================================
-func (t *SimpleChaincode) readPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the picture to query")
+func getPicture(stub shim.ChaincodeStubInterface, name string) (*picture, error) {
+	pictureAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get picture: %s", err)
+	} else if pictureAsBytes == nil {
+		return nil, newError(codeNotFound, "name", "Picture does not exist: %s", name)
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetState(name) //get the picture from chaincode state
+	pic := &picture{}
+	err = json.Unmarshal(pictureAsBytes, pic)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Picture does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", name)
 	}
-
-	return shim.Success(valAsbytes)
+	return pic, nil
 }
 
-// ==================================================
-// delete - remove a picture key/value pair from state
-// ==================================================
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var jsonResp string
-	var pictureJSON picture
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// ===============================================
+// putPicture - encode a picture and write it to chaincode state, updating the owner,
+// artist and status indexes from the version it replaces. The submitting client is
+// recorded on the picture, as the history of a key does not keep it. A picture moving
+// to another organisation loses the hash of the private details it cannot read.
+// ===============================================
+func putPicture(stub shim.ChaincodeStubInterface, pic *picture) error {
+	previous, err := getPicture(stub, pic.Name)
+	if err != nil {
+		return err
 	}
-	pictureName := args[0]
-
-	// to maintain the generation~name index, we need to read the picture first and get its generation
-	valAsbytes, err := stub.GetState(pictureName) //get the picture from chaincode state
+	if pic.Owner.MSPID != previous.Owner.MSPID {
+		pic.PrivateDetailsHash = ""
+	}
+	caller, _, err := getCaller(stub)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + pictureName + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Picture does not exist: " + pictureName + "\"}"
-		return shim.Error(jsonResp)
+		return err
 	}
-
-	err = json.Unmarshal([]byte(valAsbytes), &pictureJSON)
+	pic.LastModifiedBy = &caller
+	pictureJSONasBytes, err := json.Marshal(pic)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to decode JSON of: " + pictureName + "\"}"
-		return shim.Error(jsonResp)
+		return err
 	}
-
-	err = stub.DelState(pictureName) //remove the picture from chaincode state
+	err = stub.PutState(pic.Name, pictureJSONasBytes)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return err
 	}
+	return indexPicture(stub, previous, pic)
+}
 
-	// maintain the index
-	indexName := "generation~name"
-	generationNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{pictureJSON.Generation, pictureJSON.Name})
+// ===============================================
+// txTime returns the transaction timestamp. Unlike the local clock, it is the same
+// on every endorsing peer, so it is safe to use for validation and records.
+// ===============================================
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
 	if err != nil {
-		return shim.Error(err.Error())
+		return time.Time{}, fmt.Errorf("Failed to get transaction timestamp: %s", err)
 	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
 
-	//  Delete index entry to state.
-	err = stub.DelState(generationNameIndexKey)
+// ===============================================
+// readPicture - read a picture from chaincode state
+// ===============================================
+func (t *SimpleChaincode) readPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var name string
+	var err error
+
+	name = args[0]
+	valAsbytes, err := stub.GetState(name) //get the picture from chaincode state
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return errorResponse(fmt.Errorf("Failed to get state for %s: %s", name, err))
+	} else if valAsbytes == nil {
+		return errorResponse(newError(codeNotFound, "name", "Picture does not exist: %s", name))
 	}
-	return shim.Success(nil)
+
+	return shim.Success(valAsbytes)
 }
 
 // ===========================================================
-// transfer a picture by setting a new owner name on the picture
+// transfer a picture by setting a new owner identity on the picture.
+// Only the current owner or an admin of the owning organisation may transfer it.
 // ===========================================================
 func (t *SimpleChaincode) transferPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0       1
-	// "name", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
+	//   0            1                2
+	// "name", "GuggenheimMSP", "CN=User1@guggenheim..."
 	pictureName := args[0]
-	newOwner := strings.ToLower(args[1])
+	newOwner := identity{MSPID: args[1], Subject: args[2]}
+	if !galleryMSPs[newOwner.MSPID] {
+		return errorResponse(newError(codeInvalidArgument, "newOwnerMspId", "Unknown organisation for the new owner: %s", newOwner.MSPID))
+	}
 	fmt.Println("- start transferPicture ", pictureName, newOwner)
 
-	pictureAsBytes, err := stub.GetState(pictureName)
+	pictureToTransfer, err := getPicture(stub, pictureName)
 	if err != nil {
-		return shim.Error("Failed to get picture:" + err.Error())
-	} else if pictureAsBytes == nil {
-		return shim.Error("Picture does not exist")
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pictureToTransfer)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// the refusal of a stolen picture comes with an alert, see stolen.go
+	flag, err := getActiveStolenFlag(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if flag != nil {
+		return blockStolenTransfer(pictureToTransfer.Owner, newOwner, []stolenFlag{*flag})
+	}
+	err = checkAvailable(pictureToTransfer)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	pictureToTransfer := picture{}
-	err = json.Unmarshal(pictureAsBytes, &pictureToTransfer) //unmarshal it aka JSON.parse()
+	previousOwner := pictureToTransfer.Owner
+	err = changeOwner(stub, pictureToTransfer, newOwner)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
-	pictureToTransfer.Owner = newOwner //change the owner
 
-	pictureJSONasBytes, _ := json.Marshal(pictureToTransfer)
-	err = stub.PutState(pictureName, pictureJSONasBytes) //rewrite the picture
+	err = emitEvent(stub, eventPictureTransferred, []string{pictureName}, ownerChange{From: previousOwner, To: newOwner})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	fmt.Println("- end transferPicture (success)")
 	return shim.Success(nil)
 }
 
+// ===========================================================
+// changeOwner sets the new owner on a picture and rewrites it. Any pending transfer offer
+// is withdrawn, as it was made by the previous owner. Callers are responsible for
+// checking the change is authorised.
+// ===========================================================
+func changeOwner(stub shim.ChaincodeStubInterface, pic *picture, newOwner identity) error {
+	pic.Owner = newOwner //change the owner
+
+	err := putPicture(stub, pic) //rewrite the picture
+	if err != nil {
+		return err
+	}
+	return deleteTransferOffer(stub, pic.Name)
+}
+
 // ===========================================================================================
 // constructQueryResponseFromIterator constructs a JSON array containing query results from
 // a given result iterator
@@ -380,21 +585,90 @@ func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorI
 }
 
 // ===========================================================================================
-// addPaginationMetadataToQueryResults adds QueryResponseMetadata, which contains pagination
-// info, to the constructed query results
+// constructQueryResponseFromIndexIterator constructs a JSON array containing the pictures
+// found by iterating over a composite key index, whose last attribute is the picture name.
+// Entries whose picture is missing are skipped, so it also returns the number of pictures written.
+// ===========================================================================================
+func constructQueryResponseFromIndexIterator(stub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface) (*bytes.Buffer, int32, error) {
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	var written int32
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, 0, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, 0, err
+		}
+		pictureName := compositeKeyParts[len(compositeKeyParts)-1]
+		pictureAsBytes, err := stub.GetState(pictureName)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Failed to get picture: %s", err)
+		} else if pictureAsBytes == nil {
+			continue
+		}
+
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(pictureName)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Record\":")
+		// Record is a JSON object, so we write as-is
+		buffer.WriteString(string(pictureAsBytes))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+		written++
+	}
+	buffer.WriteString("]")
+
+	return &buffer, written, nil
+}
+
+// appendQueryResults joins two JSON arrays built by the functions above
+func appendQueryResults(buffer, next *bytes.Buffer) *bytes.Buffer {
+	if next.Len() <= len("[]") {
+		return buffer
+	} else if buffer.Len() <= len("[]") {
+		return next
+	}
+	var joined bytes.Buffer
+	joined.Write(buffer.Bytes()[:buffer.Len()-1])
+	joined.WriteString(",")
+	joined.Write(next.Bytes()[1:])
+	return &joined
+}
+
+// ===========================================================================================
+// addPaginationMetadataToQueryResults wraps the constructed query results in an object with
+// the pagination info of QueryResponseMetadata, {"records":[...],"fetchedCount":3,"bookmark":"..."}.
+// Clients pass the bookmark to get the next page, which is empty once all records are fetched.
 // ===========================================================================================
 func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata *pb.QueryResponseMetadata) *bytes.Buffer {
 
-	buffer.WriteString("[{\"ResponseMetadata\":{\"RecordsCount\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(fmt.Sprintf("%v", responseMetadata.FetchedRecordsCount))
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"Bookmark\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(responseMetadata.Bookmark)
-	buffer.WriteString("\"}}]")
+	// LevelDB bookmarks are state keys, which contain null characters for composite keys,
+	// marshalling escapes them. Marshalling a string cannot fail.
+	bookmarkAsBytes, _ := json.Marshal(responseMetadata.Bookmark)
 
-	return buffer
+	var bufferWithPaginationInfo bytes.Buffer
+	bufferWithPaginationInfo.WriteString("{\"records\":")
+	bufferWithPaginationInfo.Write(buffer.Bytes())
+	bufferWithPaginationInfo.WriteString(",\"fetchedCount\":")
+	bufferWithPaginationInfo.WriteString(strconv.Itoa(int(responseMetadata.FetchedRecordsCount)))
+	bufferWithPaginationInfo.WriteString(",\"bookmark\":")
+	bufferWithPaginationInfo.Write(bookmarkAsBytes)
+	bufferWithPaginationInfo.WriteString("}")
+
+	return &bufferWithPaginationInfo
 }
 
 // ===========================================================================================
@@ -410,22 +684,18 @@ func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata
 // ===========================================================================================
 func (t *SimpleChaincode) getPicturesByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
 	startKey := args[0]
 	endKey := args[1]
 
 	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	defer resultsIterator.Close()
 
 	buffer, err := constructQueryResponseFromIterator(resultsIterator)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	fmt.Printf("- getPicturesByRange queryResult:\n%s\n", buffer.String())
@@ -443,49 +713,90 @@ func (t *SimpleChaincode) getPicturesByRange(stub shim.ChaincodeStubInterface, a
 // ===========================================================================================
 func (t *SimpleChaincode) transferPicturesBasedOnGeneration(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0       1
-	// "generation", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
+	//   0             1                  2
+	// "generation", "GuggenheimMSP", "CN=User1@guggenheim..."
 	generation := args[0]
-	newOwner := strings.ToLower(args[1])
+	newOwner := identity{MSPID: args[1], Subject: args[2]}
 	fmt.Println("- start transferPicturesBasedOnGeneration ", generation, newOwner)
 
 	// Query the generation~name index by generation
 	// This will execute a key range query on all keys starting with 'generation'
-	generationedPictureResultsIterator, err := stub.GetStateByPartialCompositeKey("generation~name", []string{generation})
+	generationedPictureResultsIterator, err := stub.GetStateByPartialCompositeKey(generationIndex, []string{generation})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	defer generationedPictureResultsIterator.Close()
 
-	// Iterate through result set and for each picture found, transfer to newOwner
-	var i int
-	for i = 0; generationedPictureResultsIterator.HasNext(); i++ {
+	// Iterate through result set and collect the pictures found
+	pictureNames := []string{}
+	for generationedPictureResultsIterator.HasNext() {
 		// Note that we don't get the value (2nd return variable), we'll just get the picture name from the composite key
 		responseRange, err := generationedPictureResultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 
 		// get the generation and name from generation~name composite key
 		objectType, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 		returnedGeneration := compositeKeyParts[0]
 		returnedPictureName := compositeKeyParts[1]
 		fmt.Printf("- found a picture from index:%s generation:%s name:%s\n", objectType, returnedGeneration, returnedPictureName)
+		pictureNames = append(pictureNames, returnedPictureName)
+	}
+
+	// a single stolen picture blocks the whole transfer, see stolen.go. The caller must be
+	// allowed to transfer it, as transferPicture checks.
+	flags := []stolenFlag{}
+	var from identity
+	for _, pictureName := range pictureNames {
+		flag, err := getActiveStolenFlag(stub, pictureName)
+		if err != nil {
+			return errorResponse(err)
+		} else if flag == nil {
+			continue
+		}
+		pic, err := getPicture(stub, pictureName)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = checkOwnerOrAdmin(stub, pic)
+		if err != nil {
+			return errorResponse(newError(codeForbidden, "", "Transfer failed: %s", err))
+		}
+		if len(flags) == 0 {
+			from = pic.Owner
+		}
+		flags = append(flags, *flag)
+	}
+	if len(flags) > 0 {
+		return blockStolenTransfer(from, newOwner, flags)
+	}
+
+	// For each picture found, transfer to newOwner
+	var i int
+	transferred := []string{}
+	for i = 0; i < len(pictureNames); i++ {
+		returnedPictureName := pictureNames[i]
 
 		// Now call the transfer function for the found picture.
-		// Re-use the same function that is used to transfer individual pictures
-		response := t.transferPicture(stub, []string{returnedPictureName, newOwner})
+		// Re-use the same function that is used to transfer individual pictures, which also
+		// checks the caller is allowed to transfer each of them
+		response := t.transferPicture(stub, []string{returnedPictureName, newOwner.MSPID, newOwner.Subject})
 		// if the transfer failed break out of loop and return error
 		if response.Status != shim.OK {
-			return shim.Error("Transfer failed: " + response.Message)
+			e := responseError(response)
+			return errorResponse(newError(e.Code, "", "Transfer failed: %s", e.Message))
 		}
+		transferred = append(transferred, returnedPictureName)
+	}
+
+	// replaces the events of the individual transfers, Fabric keeps one event per transaction
+	err = emitEvent(stub, eventPicturesTransferred, transferred, bulkTransfer{Generation: generation, To: newOwner})
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	responsePayload := fmt.Sprintf("Transferred %d %s pictures to %s", i, generation, newOwner)
@@ -507,50 +818,65 @@ func (t *SimpleChaincode) transferPicturesBasedOnGeneration(stub shim.ChaincodeS
 // ============================================================================================
 
 // ===== Example: Parameterized rich query =================================================
-// queryPicturesByOwner queries for pictures based on a passed in owner.
+// queryPicturesByOwner queries for pictures based on a passed in owner MSP ID and,
+// optionally, certificate subject.
 // This is an example of a parameterized query where the query logic is baked into the chaincode,
-// and accepting a single query parameter (owner).
+// and accepting the owner identity as query parameters.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
 func (t *SimpleChaincode) queryPicturesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0
-	// "bob"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	//   0              1
+	// "LouvreMSP", "CN=User1@louvre..." (optional)
+	ownerSubject := ""
+	if len(args) > 1 {
+		ownerSubject = args[1]
+	}
+	queryString, err := ownerQueryString(args[0], ownerSubject)
+	if err != nil {
+		return errorResponse(err)
 	}
-
-	owner := strings.ToLower(args[0])
-
-	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"picture\",\"owner\":\"%s\"}}", owner)
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(queryResults)
 }
 
+// ownerQueryString builds the selector of the pictures of an owner, the subject may be empty
+// to match every member of the organisation
+func ownerQueryString(ownerMspID, ownerSubject string) (string, error) {
+	selector := map[string]interface{}{"docType": "picture", "owner.mspId": ownerMspID}
+	if ownerSubject != "" {
+		selector["owner.subject"] = ownerSubject
+	}
+	// marshalling the selector keeps subjects with quotes or commas from breaking the query
+	queryAsBytes, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", err
+	}
+	return string(queryAsBytes), nil
+}
+
 // ===== Example: Ad hoc rich query ========================================================
-// queryPictures uses a query string to perform a query for pictures.
-// Query string matching state database syntax is passed in and executed as is.
-// Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the queryPicturesForOwner example for parameterized queries.
+// queryPictures performs an ad hoc query for pictures. The client passes a filter over the
+// whitelisted picture fields, see query.go, which is compiled into a CouchDB selector
+// restricted to pictures. Raw selectors are not accepted.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
 func (t *SimpleChaincode) queryPictures(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	//   0
-	// "queryString"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	// "filter"
+	queryString, err := compileFilter(args[0], false)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	queryString := args[0]
-
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(queryResults)
 }
@@ -586,11 +912,21 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 // the next query to retrieve the next page of results.  Paginated queries extend
 // rich queries and range queries to include a pagesize and bookmark.
 //
-// Two examples are provided in this example.  The first is getPicturesByRangeWithPagination
-// which executes a paginated range query.
-// The second example is a paginated query for rich ad-hoc queries.
+// getPicturesByRangeWithPagination and getPicturesByGenerationWithPagination execute paginated
+// range queries, the latter over the generation~name index. queryPicturesWithPagination and
+// queryPicturesByOwnerWithPagination are paginated rich queries.
+// Every paginated query returns {"records":[...],"fetchedCount":3,"bookmark":"..."}.
 // =========================================================================================
 
+// parsePageSize checks a page size holds in the int32 the stub expects
+func parsePageSize(value string) (int32, error) {
+	pageSize, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || pageSize <= 0 {
+		return 0, newError(codeInvalidArgument, "pageSize", "Page size must be a positive 32-bit integer: %s", value)
+	}
+	return int32(pageSize), nil
+}
+
 // ====== Example: Pagination with Range Query ===============================================
 // getPicturesByRangeWithPagination performs a range query based on the start & end key,
 // page size and a bookmark.
@@ -600,65 +936,168 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 // ===========================================================================================
 func (t *SimpleChaincode) getPicturesByRangeWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
-
+	//   0            1         2       3
+	// "picture1", "picture9", "3", "bookmark"
 	startKey := args[0]
 	endKey := args[1]
-	//return type of ParseInt is int64
-	pageSize, err := strconv.ParseInt(args[2], 10, 32)
+	pageSize, err := parsePageSize(args[2])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	bookmark := args[3]
 
-	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, int32(pageSize), bookmark)
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	defer resultsIterator.Close()
 
 	buffer, err := constructQueryResponseFromIterator(resultsIterator)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
 
-	fmt.Printf("- getPicturesByRange queryResult:\n%s\n", bufferWithPaginationInfo.String())
+	fmt.Printf("- getPicturesByRangeWithPagination queryResult:\n%s\n", bufferWithPaginationInfo.String())
 
-	return shim.Success(buffer.Bytes())
+	return shim.Success(bufferWithPaginationInfo.Bytes())
+}
+
+// ====== Example: Pagination with Composite Key Index =======================================
+// getPicturesByGenerationWithPagination pages through the pictures of a generation using the
+// generation~name index, so it works on LevelDB as well as CouchDB. Retired pictures are
+// excluded, unless the optional 4th argument is "include", which lists them after the others,
+// or "only". A bookmark is only valid with the same argument.
+// Paginated range queries are only valid for read only transactions.
+// ===========================================================================================
+func (t *SimpleChaincode) getPicturesByGenerationWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0         1       2           3
+	// "blue", "3", "bookmark", "include" (optional: exclude, include or only)
+	generation := strings.ToLower(args[0])
+	pageSize, err := parsePageSize(args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	bookmark := args[2]
+	retired := "exclude"
+	if len(args) == 4 {
+		retired = args[3]
+	}
+
+	var indexNames []string
+	switch retired {
+	case "exclude":
+		indexNames = []string{generationIndex}
+	case "include":
+		indexNames = []string{generationIndex, retiredGenerationIndex}
+	case "only":
+		indexNames = []string{retiredGenerationIndex}
+	default:
+		return errorResponse(newError(codeInvalidArgument, "retired", "4th argument must be exclude, include or only: %s", retired))
+	}
+
+	// when both indexes are read, bookmarks in the retired one are marked as such. State
+	// database bookmarks are opaque, CouchDB ones are not keys.
+	const retiredBookmark = "retired:"
+	if len(indexNames) == 2 && strings.HasPrefix(bookmark, retiredBookmark) {
+		indexNames = indexNames[1:]
+		bookmark = strings.TrimPrefix(bookmark, retiredBookmark)
+	}
+
+	// the page is filled from the indexes in turn, each but the last one being read to its end.
+	// The page size counts index entries, fetchedCount the pictures found for them.
+	buffer := bytes.NewBufferString("[]")
+	responseMetadata := &pb.QueryResponseMetadata{}
+	var fetchedEntries int32
+	for _, indexName := range indexNames {
+		if fetchedEntries == pageSize {
+			// the retired pictures start on the next page
+			responseMetadata.Bookmark = retiredBookmark
+			break
+		}
+		resultsIterator, indexMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(indexName, []string{generation}, pageSize-fetchedEntries, bookmark)
+		if err != nil {
+			return errorResponse(err)
+		}
+		indexBuffer, written, err := constructQueryResponseFromIndexIterator(stub, resultsIterator)
+		resultsIterator.Close()
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		buffer = appendQueryResults(buffer, indexBuffer)
+		fetchedEntries += indexMetadata.FetchedRecordsCount
+		responseMetadata.FetchedRecordsCount += written
+		responseMetadata.Bookmark = indexMetadata.Bookmark
+		if indexMetadata.Bookmark != "" {
+			if indexName == retiredGenerationIndex && retired == "include" {
+				responseMetadata.Bookmark = retiredBookmark + indexMetadata.Bookmark
+			}
+			break
+		}
+		bookmark = ""
+	}
+
+	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
+
+	fmt.Printf("- getPicturesByGenerationWithPagination queryResult:\n%s\n", bufferWithPaginationInfo.String())
+
+	return shim.Success(bufferWithPaginationInfo.Bytes())
 }
 
 // ===== Example: Pagination with Ad hoc Rich Query ========================================================
-// queryPicturesWithPagination uses a query string, page size and a bookmark to perform a query
-// for pictures. Query string matching state database syntax is passed in and executed as is.
+// queryPicturesWithPagination uses a filter, page size and a bookmark to perform a query
+// for pictures. The filter is compiled as for queryPictures but may not set a limit.
 // The number of fetched records would be equal to or lesser than the specified page size.
-// Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the queryPicturesForOwner example for parameterized queries.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // Paginated queries are only valid for read only transactions.
 // =========================================================================================
 func (t *SimpleChaincode) queryPicturesWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0
-	// "queryString"
-	if len(args) < 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	//   0        1         2
+	// "filter", "3", "bookmark"
+	queryString, err := compileFilter(args[0], true)
+	if err != nil {
+		return errorResponse(err)
 	}
-
-	queryString := args[0]
-	//return type of ParseInt is int64
-	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	pageSize, err := parsePageSize(args[1])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	bookmark := args[2]
 
-	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark)
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===== Example: Pagination with Parameterized Rich Query =================================
+// queryPicturesByOwnerWithPagination pages through the pictures of an owner, as
+// queryPicturesByOwner does. An empty subject matches every member of the organisation.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// Paginated queries are only valid for read only transactions.
+// =========================================================================================
+func (t *SimpleChaincode) queryPicturesByOwnerWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                 1                   2       3
+	// "LouvreMSP", "CN=User1@louvre..." or "", "3", "bookmark"
+	queryString, err := ownerQueryString(args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	pageSize, err := parsePageSize(args[2])
+	if err != nil {
+		return errorResponse(err)
+	}
+	bookmark := args[3]
+
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
+	if err != nil {
+		return errorResponse(err)
 	}
 	return shim.Success(queryResults)
 }
@@ -686,22 +1125,18 @@ func getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface
 
 	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", bufferWithPaginationInfo.String())
 
-	return buffer.Bytes(), nil
+	return bufferWithPaginationInfo.Bytes(), nil
 }
 
 func (t *SimpleChaincode) getHistoryForPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-
 	pictureName := args[0]
 
 	fmt.Printf("- start getHistoryForPicture: %s\n", pictureName)
 
 	resultsIterator, err := stub.GetHistoryForKey(pictureName)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	defer resultsIterator.Close()
 
@@ -713,7 +1148,7 @@ func (t *SimpleChaincode) getHistoryForPicture(stub shim.ChaincodeStubInterface,
 	for resultsIterator.HasNext() {
 		response, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 		// Add a comma before array members, suppress it for the first array member
 		if bArrayMemberAlreadyWritten == true {
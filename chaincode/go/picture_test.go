@@ -0,0 +1,695 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Test clients ====
+// The creator of a transaction is a serialized identity holding an X.509 certificate, which
+// is all the client identity library reads. Self-signed certificates are enough for MockStub.
+
+type testClient struct {
+	id      identity
+	creator []byte
+}
+
+var (
+	louvreUser      = newTestClient("LouvreMSP", "User1@louvre.artgalleries.com", "client")
+	louvreAdmin     = newTestClient("LouvreMSP", "Admin@louvre.artgalleries.com", "admin")
+	guggenheimUser  = newTestClient("GuggenheimMSP", "User1@guggenheim.artgalleries.com", "client")
+	guggenheimAdmin = newTestClient("GuggenheimMSP", "Admin@guggenheim.artgalleries.com", "admin")
+	outsiderUser    = newTestClient("OutsiderMSP", "User1@outsider.example.com", "client")
+)
+
+// attributeOID is the certificate extension Fabric CA stores attributes in, see
+// github.com/hyperledger/fabric/common/attrmgr
+var attributeOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+func newTestClient(mspID, commonName, ou string) testClient {
+	return newTestClientWithAttributes(mspID, commonName, ou, nil)
+}
+
+// newTestClientWithAttributes returns a client whose certificate carries Fabric CA attributes
+func newTestClientWithAttributes(mspID, commonName, ou string, attributes map[string]string) testClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, OrganizationalUnit: []string{ou}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attributes != nil {
+		value, err := json.Marshal(map[string]map[string]string{"attrs": attributes})
+		if err != nil {
+			panic(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributeOID, Value: value}}
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+	if err != nil {
+		panic(err)
+	}
+	return testClient{id: identity{MSPID: mspID, Subject: template.Subject.String()}, creator: creator}
+}
+
+// ==== Test helpers ====
+
+func newTestStub() *shim.MockStub {
+	return shim.NewMockStub("pictures", new(SimpleChaincode))
+}
+
+var txCount int
+
+// privateStub adds what the MockStub of Fabric 1.4 lacks: the creator of the proposal, whose
+// GetCreator always returns nil, its transient map and the deletion of private data
+type privateStub struct {
+	*shim.MockStub
+	args      []string
+	creator   []byte
+	transient map[string][]byte
+}
+
+func (s *privateStub) GetFunctionAndParameters() (string, []string) {
+	if len(s.args) == 0 {
+		return "", []string{}
+	}
+	return s.args[0], s.args[1:]
+}
+
+func (s *privateStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *privateStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *privateStub) DelPrivateData(collection, key string) error {
+	delete(s.PvtState[collection], key)
+	return nil
+}
+
+// invoke runs a chaincode function as the given client. Events left by earlier transactions
+// are drained first, so the events channel only holds those of this transaction afterwards.
+func invoke(stub *shim.MockStub, caller testClient, args ...string) pb.Response {
+	return invokeWithTransient(stub, caller, nil, args...)
+}
+
+// invokeWithTransient runs a chaincode function with transient fields, see private.go
+func invokeWithTransient(stub *shim.MockStub, caller testClient, transient map[string]string, args ...string) pb.Response {
+	drainEvents(stub)
+	txCount++
+	txID := fmt.Sprintf("tx%d", txCount)
+	transientMap := map[string][]byte{}
+	for field, value := range transient {
+		transientMap[field] = []byte(value)
+	}
+	stub.MockTransactionStart(txID)
+	defer stub.MockTransactionEnd(txID)
+	return new(SimpleChaincode).Invoke(&privateStub{MockStub: stub, args: args, creator: caller.creator, transient: transientMap})
+}
+
+func drainEvents(stub *shim.MockStub) []*pb.ChaincodeEvent {
+	var events []*pb.ChaincodeEvent
+	for {
+		select {
+		case event := <-stub.ChaincodeEventsChannel:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// lastEvent returns the event Fabric keeps for the last transaction, decoded
+func lastEvent(t *testing.T, stub *shim.MockStub) chaincodeEvent {
+	t.Helper()
+	events := drainEvents(stub)
+	if len(events) == 0 {
+		t.Fatal("No event was emitted")
+	}
+	event := chaincodeEvent{}
+	err := json.Unmarshal(events[len(events)-1].Payload, &event)
+	if err != nil {
+		t.Fatal("Failed to decode event:", err)
+	}
+	if event.Type != events[len(events)-1].EventName {
+		t.Fatalf("Event %s has payload type %s", events[len(events)-1].EventName, event.Type)
+	}
+	return event
+}
+
+// inTransaction runs f against the stub outside of Invoke, e.g. to move deadlines in the past
+func inTransaction(stub *shim.MockStub, f func() error) error {
+	stub.MockTransactionStart("setup")
+	defer stub.MockTransactionEnd("setup")
+	return f()
+}
+
+func checkInvoke(t *testing.T, stub *shim.MockStub, caller testClient, args ...string) []byte {
+	t.Helper()
+	return checkTransientInvoke(t, stub, caller, nil, args...)
+}
+
+func checkTransientInvoke(t *testing.T, stub *shim.MockStub, caller testClient, transient map[string]string, args ...string) []byte {
+	t.Helper()
+	response := invokeWithTransient(stub, caller, transient, args...)
+	if response.Status != shim.OK {
+		t.Fatalf("%s failed: %s", args[0], response.Message)
+	}
+	return response.Payload
+}
+
+// checkInvokeError checks a function fails with a message containing the given one, and
+// returns the decoded error envelope so tests can check its code
+func checkInvokeError(t *testing.T, stub *shim.MockStub, caller testClient, message string, args ...string) *chaincodeError {
+	t.Helper()
+	return checkTransientInvokeError(t, stub, caller, nil, message, args...)
+}
+
+func checkTransientInvokeError(t *testing.T, stub *shim.MockStub, caller testClient, transient map[string]string, message string, args ...string) *chaincodeError {
+	t.Helper()
+	response := invokeWithTransient(stub, caller, transient, args...)
+	if response.Status == shim.OK {
+		t.Fatalf("%s succeeded, expected an error containing %q", args[0], message)
+	}
+	e := &chaincodeError{}
+	err := json.Unmarshal([]byte(response.Message), e)
+	if err != nil || e.Code == "" {
+		t.Fatalf("%s failed without an error envelope: %s", args[0], response.Message)
+	}
+	if !strings.Contains(e.Message, message) {
+		t.Fatalf("%s failed with %q, expected an error containing %q", args[0], e.Message, message)
+	}
+	return e
+}
+
+// createPicture registers Claude Monet the first time, as pictures need an existing artist
+func createPicture(t *testing.T, stub *shim.MockStub, owner testClient, name, generation string) {
+	t.Helper()
+	if stub.State[compositeKey(t, stub, artistPrefix, "claude-monet")] == nil {
+		createArtist(t, stub, "claude-monet", "Claude Monet")
+	}
+	checkInvoke(t, stub, owner, "initPicture", name, generation, "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+}
+
+func checkPicture(t *testing.T, stub *shim.MockStub, name string) *picture {
+	t.Helper()
+	pictureAsBytes := stub.State[name]
+	if pictureAsBytes == nil {
+		t.Fatalf("Picture %s does not exist", name)
+	}
+	pic := &picture{}
+	err := json.Unmarshal(pictureAsBytes, pic)
+	if err != nil {
+		t.Fatalf("Failed to decode picture %s: %s", name, err)
+	}
+	return pic
+}
+
+func compositeKey(t *testing.T, stub *shim.MockStub, objectType string, attributes ...string) string {
+	t.Helper()
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// setPictureStatus puts a picture in a state the tested function has to refuse. putPicture
+// records who made the change, so it runs as an admin of the Louvre.
+func setPictureStatus(t *testing.T, stub *shim.MockStub, name, status string) {
+	t.Helper()
+	err := inTransaction(stub, func() error {
+		setup := &privateStub{MockStub: stub, creator: louvreAdmin.creator}
+		pic, err := getPicture(setup, name)
+		if err != nil {
+			return err
+		}
+		pic.Status = status
+		return putPicture(setup, pic)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ==== Paginated queries ====
+// MockStub returns no iterator for paginated queries. pagedStub pages through its range queries
+// instead, with the first key of the next page as bookmark as LevelDB does. It records the rich
+// queries it is given and answers them with every picture.
+
+type pagedStub struct {
+	*shim.MockStub
+	queries []string
+}
+
+type sliceIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *sliceIterator) HasNext() bool { return len(it.kvs) > 0 }
+func (it *sliceIterator) Close() error  { return nil }
+func (it *sliceIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func page(resultsIterator shim.StateQueryIteratorInterface, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	defer resultsIterator.Close()
+	results := &sliceIterator{}
+	responseMetadata := &pb.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if len(results.kvs) == int(pageSize) {
+			responseMetadata.Bookmark = kv.Key
+			break
+		}
+		results.kvs = append(results.kvs, kv)
+	}
+	responseMetadata.FetchedRecordsCount = int32(len(results.kvs))
+	return results, responseMetadata, nil
+}
+
+func (stub *pagedStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return page(resultsIterator, pageSize, bookmark)
+}
+
+func (stub *pagedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return page(resultsIterator, pageSize, bookmark)
+}
+
+func (stub *pagedStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	stub.queries = append(stub.queries, query)
+	return stub.GetStateByRangeWithPagination("picture", "picture~", pageSize, bookmark)
+}
+
+type queryPage struct {
+	Records []struct {
+		Key    string
+		Record picture
+	} `json:"records"`
+	FetchedCount int32  `json:"fetchedCount"`
+	Bookmark     string `json:"bookmark"`
+}
+
+// readPage runs a paginated query handler against a pagedStub and decodes the page
+func readPage(t *testing.T, stub *pagedStub, handler func(*SimpleChaincode, shim.ChaincodeStubInterface, []string) pb.Response, args ...string) (queryPage, []string) {
+	t.Helper()
+	response := handler(new(SimpleChaincode), stub, args)
+	if response.Status != shim.OK {
+		t.Fatal("Query failed:", response.Message)
+	}
+	result := queryPage{}
+	err := json.Unmarshal(response.Payload, &result)
+	if err != nil {
+		t.Fatalf("Failed to decode page %s: %s", response.Payload, err)
+	}
+	names := []string{}
+	for _, record := range result.Records {
+		if record.Key != record.Record.Name {
+			t.Fatalf("Record %s holds picture %s", record.Key, record.Record.Name)
+		}
+		names = append(names, record.Key)
+	}
+	if result.FetchedCount != int32(len(names)) {
+		t.Fatalf("Fetched %d records, counted %d", len(names), result.FetchedCount)
+	}
+	return result, names
+}
+
+// ==== Tests ====
+
+func TestInit(t *testing.T) {
+	stub := newTestStub()
+	response := stub.MockInit("init", [][]byte{[]byte("init")})
+	if response.Status != shim.OK {
+		t.Fatal("Init failed:", response.Message)
+	}
+}
+
+func TestUnknownFunction(t *testing.T) {
+	stub := newTestStub()
+	checkInvokeError(t, stub, louvreUser, "Received unknown function invocation", "paintPicture", "picture1")
+}
+
+func TestInitPicture(t *testing.T) {
+	stub := newTestStub()
+	createArtist(t, stub, "claude-monet", " Claude Monet ")
+	checkInvoke(t, stub, louvreUser, "initPicture", "picture1", "Blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+
+	pic := checkPicture(t, stub, "picture1")
+	expected := picture{
+		ObjectType:      "picture",
+		Name:            "picture1",
+		Generation:      "blue",
+		Size:            35,
+		Owner:           louvreUser.id,
+		ArtistID:        "claude-monet",
+		Title:           "Water Lilies",
+		Year:            1906,
+		Medium:          "Oil on canvas",
+		Dimensions:      "89.9 x 94.1 cm",
+		InventoryNumber: "RF 1963-5",
+		Status:          statusAvailable,
+	}
+	if pic.LastModifiedBy == nil || *pic.LastModifiedBy != louvreUser.id {
+		t.Fatalf("Unexpected lastModifiedBy %v", pic.LastModifiedBy)
+	}
+	pic.LastModifiedBy = nil
+	if *pic != expected {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if stub.State[compositeKey(t, stub, "generation~name", "blue", "picture1")] == nil {
+		t.Fatal("The generation~name index entry was not written")
+	}
+
+	event := lastEvent(t, stub)
+	if event.Type != eventPictureCreated || event.Actor != louvreUser.id || len(event.Pictures) != 1 || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+}
+
+func TestInitPictureErrors(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	nextYear := fmt.Sprint(time.Now().Year() + 1)
+	tests := []struct {
+		name    string
+		args    []string
+		message string
+	}{
+		{"too few arguments", []string{"picture2", "blue", "35"}, "Incorrect number of arguments. Expecting 9"},
+		{"too many arguments", []string{"picture2", "blue", "35", "claude-monet", "Title", "1906", "Oil", "1 x 1 cm", "RF 1", "extra"}, "Incorrect number of arguments. Expecting 9"},
+		{"empty argument", []string{"picture2", "blue", "35", " ", "Title", "1906", "Oil", "1 x 1 cm", "RF 1"}, "Argument 4 (artistId) must be a non-empty string"},
+		{"non-numeric size", []string{"picture2", "blue", "large", "claude-monet", "Title", "1906", "Oil", "1 x 1 cm", "RF 1"}, "Argument 3 (size) must be an integer"},
+		{"non-numeric year", []string{"picture2", "blue", "35", "claude-monet", "Title", "circa 1906", "Oil", "1 x 1 cm", "RF 1"}, "Argument 6 (year) must be an integer"},
+		{"future year", []string{"picture2", "blue", "35", "claude-monet", "Title", nextYear, "Oil", "1 x 1 cm", "RF 1"}, "6th argument (year) must be a year between 1 and the current year"},
+		{"unknown artist", []string{"picture2", "blue", "35", "vincent-van-gogh", "Title", "1906", "Oil", "1 x 1 cm", "RF 1"}, "Artist does not exist: vincent-van-gogh"},
+		{"existing picture", []string{"picture1", "blue", "35", "claude-monet", "Title", "1906", "Oil", "1 x 1 cm", "RF 1"}, "This picture already exists: picture1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkInvokeError(t, stub, louvreUser, test.message, append([]string{"initPicture"}, test.args...)...)
+		})
+	}
+
+	// without a creator the owner cannot be known
+	checkInvokeError(t, stub, testClient{}, "Failed to get client identity", "initPicture", "picture2", "blue", "35", "claude-monet", "Title", "1906", "Oil", "1 x 1 cm", "RF 1")
+}
+
+func TestReadPicture(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	payload := checkInvoke(t, stub, guggenheimUser, "readPicture", "picture1")
+	if string(payload) != string(stub.State["picture1"]) {
+		t.Fatalf("Unexpected picture %s", payload)
+	}
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments", "readPicture")
+	checkInvokeError(t, stub, louvreUser, "Picture does not exist: picture2", "readPicture", "picture2")
+}
+
+func TestTransferPicture(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	tests := []struct {
+		name    string
+		caller  testClient
+		args    []string
+		message string
+	}{
+		{"too few arguments", louvreUser, []string{"picture1", "GuggenheimMSP"}, "Incorrect number of arguments. Expecting 3"},
+		{"unknown organisation", louvreUser, []string{"picture1", "OutsiderMSP", outsiderUser.id.Subject}, "Unknown organisation for the new owner: OutsiderMSP"},
+		{"empty subject", louvreUser, []string{"picture1", "GuggenheimMSP", ""}, "Argument 3 (newOwnerSubject) must be a non-empty string"},
+		{"missing picture", louvreUser, []string{"picture2", "GuggenheimMSP", guggenheimUser.id.Subject}, "Picture does not exist: picture2"},
+		{"not the owner", guggenheimUser, []string{"picture1", "GuggenheimMSP", guggenheimUser.id.Subject}, "Not allowed to manage picture picture1"},
+		{"admin name without the admin OU", newTestClient("LouvreMSP", "Admin@louvre.artgalleries.com", "client"), []string{"picture1", "GuggenheimMSP", guggenheimUser.id.Subject}, "Not allowed to manage picture picture1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkInvokeError(t, stub, test.caller, test.message, append([]string{"transferPicture"}, test.args...)...)
+		})
+	}
+
+	setPictureStatus(t, stub, "picture1", statusOnLoan)
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available", "transferPicture", "picture1", "GuggenheimMSP", guggenheimUser.id.Subject)
+	setPictureStatus(t, stub, "picture1", statusAvailable)
+
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", "GuggenheimMSP", guggenheimUser.id.Subject)
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != guggenheimUser.id {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+	event := lastEvent(t, stub)
+	details := ownerChange{}
+	detailsJSON, _ := json.Marshal(event.Details)
+	json.Unmarshal(detailsJSON, &details)
+	if event.Type != eventPictureTransferred || details.From != louvreUser.id || details.To != guggenheimUser.id {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+
+	// the previous owner lost control of the picture
+	checkInvokeError(t, stub, louvreUser, "Not allowed to manage picture picture1", "transferPicture", "picture1", "LouvreMSP", louvreUser.id.Subject)
+}
+
+func TestTransferPicturesBasedOnGeneration(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "red")
+	createPicture(t, stub, louvreUser, "picture3", "blue")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 3", "transferPicturesBasedOnGeneration", "blue", "GuggenheimMSP")
+
+	payload := checkInvoke(t, stub, louvreUser, "transferPicturesBasedOnGeneration", "blue", "GuggenheimMSP", guggenheimUser.id.Subject)
+	if expected := "Transferred 2 blue pictures to " + guggenheimUser.id.String(); string(payload) != expected {
+		t.Fatalf("Unexpected response %q", payload)
+	}
+	for name, owner := range map[string]identity{"picture1": guggenheimUser.id, "picture2": louvreUser.id, "picture3": guggenheimUser.id} {
+		if pic := checkPicture(t, stub, name); pic.Owner != owner {
+			t.Fatalf("Picture %s is owned by %s, expected %s", name, pic.Owner, owner)
+		}
+	}
+	event := lastEvent(t, stub)
+	if event.Type != eventPicturesTransferred || strings.Join(event.Pictures, ",") != "picture1,picture3" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+
+	// one picture the caller may not transfer fails the whole transaction
+	createPicture(t, stub, guggenheimUser, "picture4", "red")
+	checkInvokeError(t, stub, louvreUser, "Transfer failed: Not allowed to manage picture picture4", "transferPicturesBasedOnGeneration", "red", "GuggenheimMSP", guggenheimUser.id.Subject)
+
+	// a generation without pictures transfers nothing
+	payload = checkInvoke(t, stub, louvreUser, "transferPicturesBasedOnGeneration", "green", "GuggenheimMSP", guggenheimUser.id.Subject)
+	if !strings.HasPrefix(string(payload), "Transferred 0 green pictures") {
+		t.Fatalf("Unexpected response %q", payload)
+	}
+}
+
+func TestGetPicturesByRange(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "red")
+	createPicture(t, stub, louvreUser, "picture3", "blue")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 2", "getPicturesByRange", "picture1")
+
+	// the end key is excluded
+	payload := checkInvoke(t, stub, louvreUser, "getPicturesByRange", "picture1", "picture3")
+	results := []struct {
+		Key    string
+		Record picture
+	}{}
+	err := json.Unmarshal(payload, &results)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %s", payload, err)
+	}
+	if len(results) != 2 || results[0].Key != "picture1" || results[1].Key != "picture2" || results[1].Record.Generation != "red" {
+		t.Fatalf("Unexpected results %s", payload)
+	}
+}
+
+func TestPaginatedQueries(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, guggenheimUser, "picture2", "blue")
+	createPicture(t, stub, louvreUser, "picture3", "red")
+	createPicture(t, stub, louvreUser, "picture4", "blue")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 4", "getPicturesByRangeWithPagination", "picture1", "picture3", "2")
+	checkInvokeError(t, stub, louvreUser, "Argument 3 (pageSize) must be an integer", "getPicturesByRangeWithPagination", "picture1", "picture3", "two", "")
+	checkInvokeError(t, stub, louvreUser, "Page size must be a positive 32-bit integer: 0", "getPicturesByRangeWithPagination", "picture1", "picture3", "0", "")
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 3", "queryPicturesWithPagination", `{}`, "2")
+	checkInvokeError(t, stub, louvreUser, "Argument 2 (pageSize) must be an integer", "queryPicturesWithPagination", `{}`, "two", "")
+	checkInvokeError(t, stub, louvreUser, "Limit cannot be used with pagination", "queryPicturesWithPagination", `{"limit":10}`, "2", "")
+	checkInvokeError(t, stub, louvreUser, "Page size must be a positive 32-bit integer: 4294967296", "getPicturesByGenerationWithPagination", "blue", "4294967296", "")
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 4", "queryPicturesByOwnerWithPagination", "LouvreMSP", "2", "")
+
+	paged := &pagedStub{MockStub: stub}
+	result, names := readPage(t, paged, (*SimpleChaincode).getPicturesByRangeWithPagination, "picture1", "picture9", "3", "")
+	if strings.Join(names, ",") != "picture1,picture2,picture3" || result.Bookmark != "picture4" {
+		t.Fatalf("Unexpected page %v, bookmark %q", names, result.Bookmark)
+	}
+	result, names = readPage(t, paged, (*SimpleChaincode).getPicturesByRangeWithPagination, "picture1", "picture9", "3", result.Bookmark)
+	if strings.Join(names, ",") != "picture4" || result.Bookmark != "" {
+		t.Fatalf("Unexpected page %v, bookmark %q", names, result.Bookmark)
+	}
+
+	// the bookmark of an index query is a composite key
+	result, names = readPage(t, paged, (*SimpleChaincode).getPicturesByGenerationWithPagination, "Blue", "2", "")
+	if strings.Join(names, ",") != "picture1,picture2" || result.Bookmark != compositeKey(t, stub, "generation~name", "blue", "picture4") {
+		t.Fatalf("Unexpected page %v, bookmark %q", names, result.Bookmark)
+	}
+	result, names = readPage(t, paged, (*SimpleChaincode).getPicturesByGenerationWithPagination, "blue", "2", result.Bookmark)
+	if strings.Join(names, ",") != "picture4" || result.Bookmark != "" {
+		t.Fatalf("Unexpected page %v, bookmark %q", names, result.Bookmark)
+	}
+
+	// an index entry whose picture is missing is skipped, and not counted as fetched
+	err := inTransaction(stub, func() error { return stub.DelState("picture2") })
+	if err != nil {
+		t.Fatal("Failed to delete picture2:", err)
+	}
+	result, names = readPage(t, paged, (*SimpleChaincode).getPicturesByGenerationWithPagination, "blue", "2", "")
+	if strings.Join(names, ",") != "picture1" || result.Bookmark != compositeKey(t, stub, "generation~name", "blue", "picture4") {
+		t.Fatalf("Unexpected page %v, bookmark %q", names, result.Bookmark)
+	}
+
+	readPage(t, paged, (*SimpleChaincode).queryPicturesByOwnerWithPagination, "LouvreMSP", "", "2", "")
+	readPage(t, paged, (*SimpleChaincode).queryPicturesByOwnerWithPagination, "LouvreMSP", louvreUser.id.Subject, "2", "")
+	readPage(t, paged, (*SimpleChaincode).queryPicturesWithPagination, `{"filters":[{"field":"size","operator":"eq","value":35}]}`, "2", "")
+	expected := []string{
+		`{"selector":{"docType":"picture","owner.mspId":"LouvreMSP"}}`,
+		`{"selector":{"docType":"picture","owner.mspId":"LouvreMSP","owner.subject":"` + louvreUser.id.Subject + `"}}`,
+		`{"selector":{"docType":"picture","size":{"$eq":35}}}`,
+	}
+	if strings.Join(paged.queries, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected queries %v", paged.queries)
+	}
+}
+
+// The packaged indexes are only used by CouchDB when their fields match the picture documents
+func TestCouchDBIndexes(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	document := map[string]interface{}{}
+	err := json.Unmarshal(stub.State["picture1"], &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob("META-INF/statedb/couchdb/indexes/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Unexpected index files %v", files)
+	}
+	for _, file := range files {
+		indexAsBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := struct {
+			Index struct {
+				Fields []interface{} `json:"fields"`
+			} `json:"index"`
+			Ddoc string `json:"ddoc"`
+			Name string `json:"name"`
+			Type string `json:"type"`
+		}{}
+		err = json.Unmarshal(indexAsBytes, &index)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", file, err)
+		}
+		if index.Name+".json" != filepath.Base(file) || index.Ddoc == "" || index.Type != "json" || len(index.Index.Fields) == 0 {
+			t.Fatalf("Unexpected index %s: %+v", file, index)
+		}
+
+		for _, field := range index.Index.Fields {
+			// fields are either names or {"name": "asc"|"desc"}
+			name, ok := field.(string)
+			if sort, isSort := field.(map[string]interface{}); isSort {
+				for name = range sort {
+				}
+			} else if !ok {
+				t.Fatalf("Unexpected field %v in %s", field, file)
+			}
+			var value interface{} = document
+			for _, part := range strings.Split(name, ".") {
+				object, _ := value.(map[string]interface{})
+				value = object[part]
+			}
+			if value == nil {
+				t.Fatalf("Field %s of %s is not a picture field", name, file)
+			}
+		}
+	}
+}
+
+// MockStub does not implement rich queries or history: only the argument checks are covered,
+// and the stub errors stand for a state database without support
+func TestRichQueries(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1 or 2", "queryPicturesByOwner")
+	checkInvokeError(t, stub, louvreUser, "not implemented", "queryPicturesByOwner", "LouvreMSP")
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "queryPictures")
+	checkInvokeError(t, stub, louvreUser, "not implemented", "queryPictures", `{"filters":[{"field":"artistId","operator":"eq","value":"claude-monet"}]}`)
+	checkInvokeError(t, stub, louvreUser, "Cannot filter on field", "queryPictures", `{"filters":[{"field":"docType","operator":"eq","value":"transferOffer"}]}`)
+}
+
+func TestGetHistoryForPicture(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "getHistoryForPicture")
+	checkInvokeError(t, stub, louvreUser, "not implemented", "getHistoryForPicture", "picture1")
+}
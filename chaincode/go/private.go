@@ -0,0 +1,301 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Private data ====
+// Sale prices, sealed auction bids and insurance valuations are kept out of world state, which
+// every channel member reads. Each gallery has a private data collection, see
+// collections_config.json, and the sensitive fields are written to the collections of the
+// organisations entitled to them:
+//
+//   - the private details of a picture (insurance value, insurer, ...) to its owner's
+//   - the price of a sale offer, and then of the sale, to the seller's and the buyer's
+//   - the amount of a revealed sealed bid to the bidder's and the seller's, see auction.go
+//
+// Functions take these fields from the transient map of the proposal, which is not recorded
+// in the transaction, never from their arguments. The public record keeps the hex SHA-256
+// hash of the private JSON document, so a party given the document can check it is the one
+// the ledger refers to. Amounts have few possible values, so every document carries a random
+// salt chosen by the client, without which its hash could be found by trying every amount.
+// The chaincode cannot draw it, endorsing peers would write different documents. Pictures
+// leaving an organisation lose their hash, the private details of the former owner stay in
+// its collection.
+//
+// Transient fields, as JSON documents:
+//
+//   privateDetails  {"insuranceValue":"2500000.00","currency":"EUR","insurer":"AXA ART","valuationDate":"2018-06-01","salt":"..."}
+//                   for initPicture (optional) and setPicturePrivateDetails
+//   price           {"price":"1500000.00","currency":"EUR","salt":"..."} for sellPicture
+//   bid             {"amount":"120000.00","salt":"..."} for revealBid, in the auction currency,
+//                   the salt being the one of the commitment
+//
+// The salts of privateDetails and price are random strings of at least minSaltLength
+// characters, e.g. 32 random bytes in hex. A sale settles with the salt of its offer, or of
+// the winning sealed bid.
+
+const (
+	transientPrivateDetails = "privateDetails"
+	transientPrice          = "price"
+	transientBid            = "bid"
+)
+
+// minSaltLength is the length of the shortest salt accepted in a transient field
+const minSaltLength = 16
+
+const (
+	offerPricePrefix = "offerPrice" //picture name, in the collections of both parties
+	salePricePrefix  = "salePrice"  //picture name, sale transaction ID
+	auctionBidPrefix = "auctionBid" //auction ID, bidder MSP ID and subject
+)
+
+type picturePrivateDetails struct {
+	ObjectType     string `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name           string `json:"name"`
+	InsuranceValue string `json:"insuranceValue"` //decimal amount, as for prices
+	Currency       string `json:"currency"`
+	Insurer        string `json:"insurer,omitempty"`
+	ValuationDate  string `json:"valuationDate,omitempty"` //YYYY-MM-DD
+	Salt           string `json:"salt"`
+}
+
+type privatePrice struct {
+	ObjectType string `json:"docType"`
+	Picture    string `json:"picture"`
+	Price      string `json:"price"`
+	Currency   string `json:"currency"`
+	Salt       string `json:"salt"` //empty for the sale of an english auction, whose amount is public
+}
+
+// privateCollection returns the name of the private data collection of an organisation
+func privateCollection(mspID string) string {
+	return "collection" + mspID
+}
+
+// ===========================================================================================
+// getTransientJSON decodes a field of the transient map, returning false if it is missing.
+// Unknown fields are refused, so a misspelt one is not silently dropped.
+// ===========================================================================================
+func getTransientJSON(stub shim.ChaincodeStubInterface, field string, v interface{}) (bool, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return false, fmt.Errorf("Failed to get transient map: %s", err)
+	}
+	value, ok := transientMap[field]
+	if !ok {
+		return false, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err != nil {
+		return false, newError(codeInvalidArgument, field, "Invalid transient field %s: %s", field, err)
+	}
+	return true, nil
+}
+
+// checkSalt verifies the salt of a transient field is long enough to hide the document
+func checkSalt(field, salt string) error {
+	if len(salt) < minSaltLength {
+		return newError(codeInvalidArgument, field, "The %s transient field needs a random salt of at least %d characters", field, minSaltLength)
+	}
+	return nil
+}
+
+// ===========================================================================================
+// putPrivate writes a document to the collections of the given organisations, once each,
+// and returns the hex SHA-256 hash of the JSON written
+// ===========================================================================================
+func putPrivate(stub shim.ChaincodeStubInterface, mspIDs []string, key string, v interface{}) (string, error) {
+	valueJSONasBytes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	written := map[string]bool{}
+	for _, mspID := range mspIDs {
+		if written[mspID] {
+			continue
+		}
+		written[mspID] = true
+		err = stub.PutPrivateData(privateCollection(mspID), key, valueJSONasBytes)
+		if err != nil {
+			return "", fmt.Errorf("Failed to put private data: %s", err)
+		}
+	}
+	hash := sha256.Sum256(valueJSONasBytes)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// ===========================================================================================
+// getOfferPrice reads the price of a sale offer from the collection of the buyer, whose peers
+// endorse acceptTransfer, and checks it against the hash of the offer
+// ===========================================================================================
+func getOfferPrice(stub shim.ChaincodeStubInterface, offer *transferOffer) (*privatePrice, error) {
+	offerPriceKey, err := stub.CreateCompositeKey(offerPricePrefix, []string{offer.Picture})
+	if err != nil {
+		return nil, err
+	}
+	priceAsBytes, err := stub.GetPrivateData(privateCollection(offer.To.MSPID), offerPriceKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get private data: %s", err)
+	} else if priceAsBytes == nil {
+		return nil, fmt.Errorf("Price of the offer for picture %s not found in %s", offer.Picture, privateCollection(offer.To.MSPID))
+	}
+	hash := sha256.Sum256(priceAsBytes)
+	if hex.EncodeToString(hash[:]) != offer.PriceHash {
+		return nil, fmt.Errorf("Price of the offer for picture %s does not match its hash", offer.Picture)
+	}
+
+	price := &privatePrice{}
+	err = json.Unmarshal(priceAsBytes, price)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode offer price for: %s", offer.Picture)
+	}
+	return price, nil
+}
+
+// ===========================================================================================
+// storePrivateDetails validates the privateDetails transient field, writes it to the
+// collection of the owner of the picture and sets its hash on the picture, which the caller
+// then saves. It returns false, leaving the picture as it is, if the field is missing.
+// ===========================================================================================
+func storePrivateDetails(stub shim.ChaincodeStubInterface, pic *picture) (bool, error) {
+	details := &picturePrivateDetails{}
+	ok, err := getTransientJSON(stub, transientPrivateDetails, details)
+	if err != nil || !ok {
+		return false, err
+	}
+	details.ObjectType = "picturePrivateDetails"
+	details.Name = pic.Name
+	if !amountPattern.MatchString(details.InsuranceValue) || amountInCents(details.InsuranceValue) <= 0 {
+		return false, newError(codeInvalidArgument, transientPrivateDetails, "Insurance value must be a decimal amount above zero with at most two decimals: %s", details.InsuranceValue)
+	}
+	if !currencyPattern.MatchString(details.Currency) {
+		return false, newError(codeInvalidArgument, transientPrivateDetails, "Currency must be an ISO 4217 code such as EUR: %s", details.Currency)
+	}
+	if _, err := time.Parse(loanDateLayout, details.ValuationDate); err != nil && details.ValuationDate != "" {
+		return false, newError(codeInvalidArgument, transientPrivateDetails, "Valuation date must be formatted as YYYY-MM-DD: %s", details.ValuationDate)
+	}
+	err = checkSalt(transientPrivateDetails, details.Salt)
+	if err != nil {
+		return false, err
+	}
+
+	pic.PrivateDetailsHash, err = putPrivate(stub, []string{pic.Owner.MSPID}, pic.Name, details)
+	return err == nil, err
+}
+
+// ===========================================================================================
+// setPicturePrivateDetails - the owner of a picture, or an admin of its organisation, records
+// or replaces its private details, given in the privateDetails transient field
+// ===========================================================================================
+func (t *SimpleChaincode) setPicturePrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name", with the privateDetails transient field
+	pictureName := args[0]
+	fmt.Println("- start setPicturePrivateDetails ", pictureName)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	ok, err := storePrivateDetails(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	} else if !ok {
+		return errorResponse(newError(codeInvalidArgument, transientPrivateDetails, "The private details must be given in the privateDetails transient field"))
+	}
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// the event carries the new hash only
+	err = emitEvent(stub, eventPrivateDetailsUpdated, []string{pictureName}, map[string]string{"privateDetailsHash": pic.PrivateDetailsHash})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end setPicturePrivateDetails (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// readPicturePrivateDetails - members of the owning organisation read the private details of
+// a picture. The payload is the JSON document the privateDetailsHash of the picture hashes.
+// ===========================================================================================
+func (t *SimpleChaincode) readPicturePrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	pic, err := getPicture(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if caller.MSPID != pic.Owner.MSPID {
+		return errorResponse(newError(codeForbidden, "", "Private details of picture %s are reserved to %s", pic.Name, pic.Owner.MSPID))
+	}
+
+	detailsAsBytes, err := stub.GetPrivateData(privateCollection(pic.Owner.MSPID), pic.Name)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to get private data: %s", err))
+	} else if detailsAsBytes == nil || pic.PrivateDetailsHash == "" {
+		return errorResponse(newError(codeNotFound, "name", "Picture %s has no private details", pic.Name))
+	}
+	return shim.Success(detailsAsBytes)
+}
+
+// ===========================================================================================
+// readSalePrice - the seller or the buyer of a settled sale reads its price, from the
+// collection of its own organisation
+// ===========================================================================================
+func (t *SimpleChaincode) readSalePrice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0         1
+	// "name", "saleTxId"
+	s, err := getSale(stub, args[0], args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if caller.MSPID != s.Seller.MSPID && caller.MSPID != s.Buyer.MSPID {
+		return errorResponse(newError(codeForbidden, "", "The price of sale %s is reserved to %s and %s", s.TxID, s.Seller.MSPID, s.Buyer.MSPID))
+	}
+
+	priceKey, err := stub.CreateCompositeKey(salePricePrefix, []string{s.Picture, s.TxID})
+	if err != nil {
+		return errorResponse(err)
+	}
+	priceAsBytes, err := stub.GetPrivateData(privateCollection(caller.MSPID), priceKey)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to get private data: %s", err))
+	} else if priceAsBytes == nil {
+		return errorResponse(newError(codeNotFound, "saleTxId", "No price recorded for sale %s", s.TxID))
+	}
+	return shim.Success(priceAsBytes)
+}
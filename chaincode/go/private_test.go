@@ -0,0 +1,168 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const testPrivateDetails = `{"insuranceValue":"2500000.00","currency":"EUR","insurer":"AXA ART","valuationDate":"2018-06-01","salt":"5f0b7c1e9a3d2846"}`
+
+// checkPrivateDetailsHash checks the hash of a picture is the one of its private details in the
+// collection of the given organisation
+func checkPrivateDetailsHash(t *testing.T, stub *shim.MockStub, name, mspID string) picturePrivateDetails {
+	t.Helper()
+	pic := checkPicture(t, stub, name)
+	detailsAsBytes := stub.PvtState[privateCollection(mspID)][name]
+	if hash := sha256.Sum256(detailsAsBytes); detailsAsBytes == nil || hex.EncodeToString(hash[:]) != pic.PrivateDetailsHash {
+		t.Fatalf("Private details %s do not match the hash %q of %s", detailsAsBytes, pic.PrivateDetailsHash, name)
+	}
+	details := picturePrivateDetails{}
+	err := json.Unmarshal(detailsAsBytes, &details)
+	if err != nil {
+		t.Fatal("Failed to decode private details:", err)
+	}
+	return details
+}
+
+func TestCollectionsConfig(t *testing.T) {
+	configAsBytes, err := ioutil.ReadFile("collections_config.json")
+	if err != nil {
+		t.Fatal("Failed to read collections_config.json:", err)
+	}
+	collections := []struct {
+		Name           string
+		Policy         string
+		MemberOnlyRead bool
+	}{}
+	err = json.Unmarshal(configAsBytes, &collections)
+	if err != nil {
+		t.Fatal("Failed to decode collections_config.json:", err)
+	}
+
+	// one collection readable by its members only per gallery
+	if len(collections) != len(galleryMSPs) {
+		t.Fatalf("Found %d collections for %d galleries", len(collections), len(galleryMSPs))
+	}
+	for _, c := range collections {
+		found := false
+		for mspID := range galleryMSPs {
+			if c.Name == privateCollection(mspID) {
+				found = c.Policy == "OR('"+mspID+".member')" && c.MemberOnlyRead
+			}
+		}
+		if !found {
+			t.Fatalf("Unexpected collection %+v", c)
+		}
+	}
+}
+
+func TestPicturePrivateDetails(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	if pic := checkPicture(t, stub, "picture1"); pic.PrivateDetailsHash != "" {
+		t.Fatalf("Unexpected hash %q", pic.PrivateDetailsHash)
+	}
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 has no private details", "readPicturePrivateDetails", "picture1")
+
+	// the details can be given at creation
+	checkTransientInvoke(t, stub, guggenheimUser, map[string]string{transientPrivateDetails: testPrivateDetails}, "initPicture", "picture2", "red", "50", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+	details := checkPrivateDetailsHash(t, stub, "picture2", "GuggenheimMSP")
+	if details.InsuranceValue != "2500000.00" || details.Insurer != "AXA ART" || details.Name != "picture2" {
+		t.Fatalf("Unexpected private details %+v", details)
+	}
+	if stub.PvtState[privateCollection("LouvreMSP")]["picture2"] != nil {
+		t.Fatal("Private details written to the collection of another organisation")
+	}
+
+	// or set later by the owner or an admin of its organisation
+	checkTransientInvoke(t, stub, louvreAdmin, map[string]string{transientPrivateDetails: `{"insuranceValue":"1200000","currency":"USD","salt":"9e4a61d03bc2f758"}`}, "setPicturePrivateDetails", "picture1")
+	event := lastEvent(t, stub)
+	if event.Type != eventPrivateDetailsUpdated || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	if details := checkPrivateDetailsHash(t, stub, "picture1", "LouvreMSP"); details.InsuranceValue != "1200000" || details.ValuationDate != "" {
+		t.Fatalf("Unexpected private details %+v", details)
+	}
+	details = picturePrivateDetails{}
+	err := json.Unmarshal(checkInvoke(t, stub, louvreUser, "readPicturePrivateDetails", "picture1"), &details)
+	if err != nil || details.Currency != "USD" {
+		t.Fatalf("Unexpected private details %+v (%v)", details, err)
+	}
+
+	tests := []struct {
+		name      string
+		caller    testClient
+		transient map[string]string
+		message   string
+		code      string
+	}{
+		{"missing details", louvreUser, nil, "The private details must be given in the privateDetails transient field", codeInvalidArgument},
+		{"invalid JSON", louvreUser, map[string]string{transientPrivateDetails: `{"insuranceValue":`}, "Invalid transient field privateDetails", codeInvalidArgument},
+		{"unknown field", louvreUser, map[string]string{transientPrivateDetails: `{"value":"1200000","currency":"USD"}`}, "Invalid transient field privateDetails", codeInvalidArgument},
+		{"zero value", louvreUser, map[string]string{transientPrivateDetails: `{"insuranceValue":"0","currency":"USD"}`}, "Insurance value must be a decimal amount above zero", codeInvalidArgument},
+		{"invalid currency", louvreUser, map[string]string{transientPrivateDetails: `{"insuranceValue":"1200000","currency":"dollars"}`}, "Currency must be an ISO 4217 code", codeInvalidArgument},
+		{"invalid date", louvreUser, map[string]string{transientPrivateDetails: `{"insuranceValue":"1200000","currency":"USD","valuationDate":"06/2018"}`}, "Valuation date must be formatted as YYYY-MM-DD", codeInvalidArgument},
+		{"missing salt", louvreUser, map[string]string{transientPrivateDetails: `{"insuranceValue":"1200000","currency":"USD"}`}, "The privateDetails transient field needs a random salt of at least 16 characters", codeInvalidArgument},
+		{"short salt", louvreUser, map[string]string{transientPrivateDetails: `{"insuranceValue":"1200000","currency":"USD","salt":"42"}`}, "The privateDetails transient field needs a random salt of at least 16 characters", codeInvalidArgument},
+		{"not the owner", guggenheimAdmin, map[string]string{transientPrivateDetails: testPrivateDetails}, "", codeForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := checkTransientInvokeError(t, stub, test.caller, test.transient, test.message, "setPicturePrivateDetails", "picture1")
+			if e.Code != test.code {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+		})
+	}
+
+	e := checkInvokeError(t, stub, guggenheimUser, "Private details of picture picture1 are reserved to LouvreMSP", "readPicturePrivateDetails", "picture1")
+	if e.Code != codeForbidden {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
+
+func TestPrivateDetailsLeaveWithTheOrganisation(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkTransientInvoke(t, stub, louvreUser, map[string]string{transientPrivateDetails: testPrivateDetails}, "setPicturePrivateDetails", "picture1")
+
+	// a transfer within the organisation keeps the details
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", louvreAdmin.id.MSPID, louvreAdmin.id.Subject)
+	checkPrivateDetailsHash(t, stub, "picture1", "LouvreMSP")
+
+	// the new organisation starts without any, the former owner keeps its own
+	checkInvoke(t, stub, louvreAdmin, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	if pic := checkPicture(t, stub, "picture1"); pic.PrivateDetailsHash != "" {
+		t.Fatalf("Unexpected hash %q", pic.PrivateDetailsHash)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "Picture picture1 has no private details", "readPicturePrivateDetails", "picture1")
+	if stub.PvtState[privateCollection("LouvreMSP")]["picture1"] == nil {
+		t.Fatal("The private details of the former owner were deleted")
+	}
+}
+
+func TestCancelledSaleOfferPrice(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkTransientInvoke(t, stub, louvreUser, priceTransient("1500000.00", "EUR"), "sellPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	offerPriceKey := compositeKey(t, stub, offerPricePrefix, "picture1")
+	if stub.PvtState[privateCollection("GuggenheimMSP")][offerPriceKey] == nil {
+		t.Fatal("The offer price was not written to the collection of the buyer")
+	}
+
+	checkInvoke(t, stub, louvreUser, "cancelTransfer", "picture1")
+	for _, mspID := range []string{"LouvreMSP", "GuggenheimMSP"} {
+		if stub.PvtState[privateCollection(mspID)][offerPriceKey] != nil {
+			t.Fatalf("The offer price was left in the collection of %s", mspID)
+		}
+	}
+}
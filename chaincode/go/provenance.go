@@ -0,0 +1,151 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Provenance ====
+// getProvenance turns the history of a picture into its chain of custody, oldest first, for
+// due diligence reports. Each version of the picture is compared with the one before to find
+// what happened; versions that change neither owner, custodian, retirement nor location,
+// e.g. an auction opening, are left out. The submitting client is the lastModifiedBy identity
+// putPicture records. Pictures are retired rather than deleted since retire.go, deletions are
+// only found in older histories, which keep no value for them so their submitter is not known.
+
+// provenance event types
+const (
+	provenanceCreated     = "created"
+	provenanceTransferred = "transferred"
+	provenanceLoaned      = "loaned"
+	provenanceReturned    = "returned"
+	provenanceDeleted     = "deleted"
+	provenanceRetired     = "retired"   //the reason and status tell why
+	provenanceRecovered   = "recovered" //a lost or stolen picture is back, the reason tells how
+	provenanceMoved       = "moved"     //change of physical location, see location.go
+)
+
+type provenanceEntry struct {
+	Event         string    `json:"event"`
+	TxID          string    `json:"txId"`
+	Timestamp     string    `json:"timestamp"`               //RFC3339 transaction timestamp
+	PreviousOwner *identity `json:"previousOwner,omitempty"` //not set when the picture is created
+	NewOwner      *identity `json:"newOwner,omitempty"`      //not set when the picture is deleted
+	Custodian     *identity `json:"custodian,omitempty"`     //borrower of a loan, or the custodian returning the picture
+	SubmittedBy   *identity `json:"submittedBy,omitempty"`
+	Status        string    `json:"status,omitempty"`   //retirement status
+	Reason        string    `json:"reason,omitempty"`   //retirement or recovery reason
+	Location      *location `json:"location,omitempty"` //new location of a move
+}
+
+// provenanceEvent returns the event type of a change between two versions of a picture,
+// or "" if the change does not matter for custody. nil stands for no picture.
+func provenanceEvent(previous, current *picture) string {
+	switch {
+	case current == nil:
+		return provenanceDeleted
+	case previous == nil:
+		return provenanceCreated
+	case retiredStatuses[current.Status] && previous.Status != current.Status:
+		return provenanceRetired
+	case retiredStatuses[previous.Status] && !retiredStatuses[current.Status]:
+		return provenanceRecovered
+	case previous.Owner != current.Owner:
+		return provenanceTransferred
+	case previous.Custodian == nil && current.Custodian != nil:
+		return provenanceLoaned
+	case previous.Custodian != nil && current.Custodian == nil:
+		return provenanceReturned
+	case !samePlace(previous.Location, current.Location):
+		return provenanceMoved
+	}
+	return ""
+}
+
+// ===========================================================================================
+// getProvenance - report the chain of custody of a picture: creation, transfers, loans and
+// retirement, with the owners before and after, the submitting client and the transaction
+// ===========================================================================================
+func (t *SimpleChaincode) getProvenance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	pictureName := args[0]
+	fmt.Printf("- start getProvenance: %s\n", pictureName)
+
+	resultsIterator, err := stub.GetHistoryForKey(pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	entries := []provenanceEntry{}
+	var previous *picture
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		var current *picture
+		if !response.IsDelete {
+			current = &picture{}
+			err = json.Unmarshal(response.Value, current)
+			if err != nil {
+				return errorResponse(fmt.Errorf("Failed to decode JSON of %s in transaction %s", pictureName, response.TxId))
+			}
+		}
+
+		event := provenanceEvent(previous, current)
+		if event != "" {
+			entry := provenanceEntry{
+				Event:     event,
+				TxID:      response.TxId,
+				Timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).UTC().Format(time.RFC3339),
+			}
+			if previous != nil {
+				previousOwner := previous.Owner
+				entry.PreviousOwner = &previousOwner
+				entry.Custodian = previous.Custodian
+			}
+			if current != nil {
+				newOwner := current.Owner
+				entry.NewOwner = &newOwner
+				if current.Custodian != nil {
+					entry.Custodian = current.Custodian
+				}
+				entry.SubmittedBy = current.LastModifiedBy
+				if event == provenanceRetired {
+					entry.Status = current.Status
+					entry.Reason = current.RetirementReason
+				}
+				if event == provenanceRecovered {
+					entry.Reason = current.RecoveryReason
+				}
+				if event == provenanceMoved {
+					entry.Location = current.Location
+				}
+			}
+			entries = append(entries, entry)
+		}
+		previous = current
+	}
+	if len(entries) == 0 {
+		return errorResponse(newError(codeNotFound, "name", "Picture has no history: %s", pictureName))
+	}
+
+	entriesAsBytes, err := json.Marshal(entries)
+	if err != nil {
+		return errorResponse(err)
+	}
+	fmt.Printf("- getProvenance returning:\n%s\n", entriesAsBytes)
+	return shim.Success(entriesAsBytes)
+}
@@ -0,0 +1,127 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// ==== History ====
+// MockStub keeps no history. historyStub builds one from snapshots of a key taken after each
+// transaction, oldest first as Fabric 1.4 returns it.
+
+type historyStub struct {
+	*shim.MockStub
+	history map[string][]*queryresult.KeyModification
+}
+
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool { return len(it.modifications) > 0 }
+func (it *historyIterator) Close() error  { return nil }
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+func (stub *historyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: stub.history[key]}, nil
+}
+
+// record adds the current value of a key to its history, as written by the last transaction
+func (stub *historyStub) record(key string, at time.Time) {
+	value, exists := stub.State[key]
+	stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+		TxId:      fmt.Sprintf("tx%d", txCount),
+		Value:     value,
+		Timestamp: &timestamp.Timestamp{Seconds: at.Unix()},
+		IsDelete:  !exists,
+	})
+}
+
+func TestGetProvenance(t *testing.T) {
+	stub := &historyStub{MockStub: newTestStub(), history: map[string][]*queryresult.KeyModification{}}
+	start := time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC)
+	txIDs := []string{}
+	step := func(caller testClient, args ...string) []byte {
+		t.Helper()
+		payload := checkInvoke(t, stub.MockStub, caller, args...)
+		stub.record("picture1", start.AddDate(0, 0, len(txIDs)))
+		txIDs = append(txIDs, fmt.Sprintf("tx%d", txCount))
+		return payload
+	}
+
+	createArtist(t, stub.MockStub, "claude-monet", "Claude Monet")
+	step(louvreUser, "initPicture", "picture1", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+	step(louvreUser, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	loanID := string(checkInvoke(t, stub.MockStub, louvreUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	step(guggenheimAdmin, "approveLoan", loanID)
+	step(guggenheimUser, "recordReturn", loanID)
+	// an auction only changes the status, it is not part of the chain of custody
+	auctionID := string(step(guggenheimUser, "createAuction", "picture1", "english", "EUR", "100.00", deadline(time.Hour)))
+	step(guggenheimUser, "cancelAuction", auctionID)
+	step(guggenheimUser, "reportStolen", "picture1", "OCBC-2018-0412", "Stolen from the storage")
+	// pictures could be deleted before retirement existed, a deletion has no value
+	stub.history["picture1"] = append(stub.history["picture1"], &queryresult.KeyModification{TxId: "legacy", Timestamp: &timestamp.Timestamp{Seconds: start.AddDate(0, 0, 7).Unix()}, IsDelete: true})
+	txIDs = append(txIDs, "legacy")
+
+	response := new(SimpleChaincode).getProvenance(stub, []string{"picture1"})
+	if response.Status != shim.OK {
+		t.Fatal("getProvenance failed:", response.Message)
+	}
+	entries := []provenanceEntry{}
+	err := json.Unmarshal(response.Payload, &entries)
+	if err != nil {
+		t.Fatalf("Failed to decode provenance %s: %s", response.Payload, err)
+	}
+
+	louvre, guggenheim, admin := louvreUser.id, guggenheimUser.id, guggenheimAdmin.id
+	expected := []struct {
+		event         string
+		tx            int
+		previousOwner *identity
+		newOwner      *identity
+		custodian     *identity
+		submittedBy   *identity
+		reason        string
+	}{
+		{provenanceCreated, 0, nil, &louvre, nil, &louvre, ""},
+		{provenanceTransferred, 1, &louvre, &guggenheim, nil, &louvre, ""},
+		{provenanceLoaned, 2, &guggenheim, &guggenheim, &louvre, &admin, ""},
+		{provenanceReturned, 3, &guggenheim, &guggenheim, &louvre, &guggenheim, ""},
+		{provenanceRetired, 6, &guggenheim, &guggenheim, nil, &guggenheim, "Reported stolen, reference OCBC-2018-0412: Stolen from the storage"},
+		{provenanceDeleted, 7, &guggenheim, nil, nil, nil, ""},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Unexpected provenance %s", response.Payload)
+	}
+	for i, e := range expected {
+		entry := entries[i]
+		if entry.Event != e.event || entry.TxID != txIDs[e.tx] || entry.Timestamp != start.AddDate(0, 0, e.tx).Format(time.RFC3339) ||
+			!sameIdentity(entry.PreviousOwner, e.previousOwner) || !sameIdentity(entry.NewOwner, e.newOwner) ||
+			!sameIdentity(entry.Custodian, e.custodian) || !sameIdentity(entry.SubmittedBy, e.submittedBy) || entry.Reason != e.reason {
+			t.Fatalf("Unexpected entry %d: %+v", i, entry)
+		}
+	}
+
+	response = new(SimpleChaincode).getProvenance(stub, []string{"picture2"})
+	if e := responseError(response); e == nil || e.Code != codeNotFound {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+}
+
+func sameIdentity(a, b *identity) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
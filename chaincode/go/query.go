@@ -0,0 +1,232 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// ==== Picture filters ====
+// queryPictures and queryPicturesWithPagination do not run client selectors, which could read
+// any document of the chaincode. They take a filter over the picture fields listed below,
+// which compileFilter turns into a CouchDB query always restricted to docType picture:
+//
+//   {"filters":[{"field":"artistId","operator":"eq","value":"claude-monet"},
+//               {"field":"year","operator":"lt","value":1910}],
+//    "sort":[{"field":"year","order":"desc"}],
+//    "limit":20}
+//
+// filters are combined with AND and may be empty. Sorting needs one of the packaged sort
+// indexes of sortIndexes, so only size and year are sortable. limit is not allowed with
+// pagination.
+
+const (
+	fieldString = "string"
+	fieldNumber = "number"
+)
+
+type queryField struct {
+	Type     string
+	Sortable bool
+}
+
+// queryFields lists the picture fields filters may use, by their JSON name
+var queryFields = map[string]queryField{
+	"name":            {Type: fieldString},
+	"generation":      {Type: fieldString},
+	"size":            {Type: fieldNumber, Sortable: true},
+	"owner.mspId":     {Type: fieldString},
+	"owner.subject":   {Type: fieldString},
+	"artistId":        {Type: fieldString},
+	"title":           {Type: fieldString},
+	"year":            {Type: fieldNumber, Sortable: true},
+	"medium":          {Type: fieldString},
+	"dimensions":      {Type: fieldString},
+	"inventoryNumber": {Type: fieldString},
+	"status":          {Type: fieldString},
+	"conditionGrade":  {Type: fieldString},
+	"location.site":   {Type: fieldString},
+}
+
+// queryOperators maps filter operators to CouchDB selector operators
+var queryOperators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+	"in":  "$in",
+}
+
+// sortIndexes lists the fields of the sort indexes packaged in META-INF/statedb/couchdb/indexes.
+// CouchDB only sorts with an index whose fields start with the sort fields, and only uses it
+// when the selector constrains every field of the index.
+var sortIndexes = []struct {
+	Name   string
+	Fields []string
+}{
+	{"indexSizeSortDesc", []string{"size", "docType"}},
+	{"indexYearSortDesc", []string{"year", "docType"}},
+}
+
+const (
+	maxQueryLimit    = 1000 //most pictures a filter may return without pagination
+	maxQueryInValues = 100  //most values of an "in" condition
+)
+
+type queryCondition struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+}
+
+type querySort struct {
+	Field string `json:"field"`
+	Order string `json:"order"` //asc or desc
+}
+
+type pictureFilter struct {
+	Filters []queryCondition `json:"filters"`
+	Sort    []querySort      `json:"sort"`
+	Limit   int              `json:"limit"` //0 for no limit
+}
+
+// ===========================================================================================
+// compileFilter checks a picture filter and returns the CouchDB query it stands for. Errors
+// are INVALID_ARGUMENT errors of the filter argument.
+// ===========================================================================================
+func compileFilter(filterJSON string, paginated bool) (string, error) {
+	filter := pictureFilter{}
+	decoder := json.NewDecoder(strings.NewReader(filterJSON))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+	err := decoder.Decode(&filter)
+	if err != nil {
+		return "", newError(codeInvalidArgument, "filter", "Invalid filter: %s", err)
+	}
+
+	selector := map[string]interface{}{"docType": "picture"}
+	for _, condition := range filter.Filters {
+		field, ok := queryFields[condition.Field]
+		if !ok {
+			return "", newError(codeInvalidArgument, "filter", "Cannot filter on field %q", condition.Field)
+		}
+		operator, ok := queryOperators[condition.Operator]
+		if !ok {
+			return "", newError(codeInvalidArgument, "filter", "Unknown operator %q for field %s", condition.Operator, condition.Field)
+		}
+		err = checkFilterValue(condition, field)
+		if err != nil {
+			return "", err
+		}
+
+		// several conditions on a field are merged, e.g. a year range
+		operators, _ := selector[condition.Field].(map[string]interface{})
+		if operators == nil {
+			operators = map[string]interface{}{}
+			selector[condition.Field] = operators
+		}
+		if _, exists := operators[operator]; exists {
+			return "", newError(codeInvalidArgument, "filter", "Field %s has two %s conditions", condition.Field, condition.Operator)
+		}
+		operators[operator] = condition.Value
+	}
+
+	query := map[string]interface{}{"selector": selector}
+	if len(filter.Sort) > 0 {
+		sort := []map[string]string{}
+		sortFields := []string{}
+		for _, s := range filter.Sort {
+			if !queryFields[s.Field].Sortable {
+				return "", newError(codeInvalidArgument, "filter", "Cannot sort on field %q", s.Field)
+			}
+			if s.Order != "asc" && s.Order != "desc" {
+				return "", newError(codeInvalidArgument, "filter", "Sort order of %s must be asc or desc", s.Field)
+			}
+			if s.Order != filter.Sort[0].Order {
+				return "", newError(codeInvalidArgument, "filter", "All sort fields must have the same order")
+			}
+			sort = append(sort, map[string]string{s.Field: s.Order})
+			sortFields = append(sortFields, s.Field)
+		}
+		indexFields := findSortIndex(sortFields)
+		if indexFields == nil {
+			return "", newError(codeInvalidArgument, "filter", "No index sorts on %s", strings.Join(sortFields, ", "))
+		}
+		// fields of the index the filters leave free match any value
+		for _, field := range indexFields {
+			if selector[field] == nil {
+				selector[field] = map[string]interface{}{"$gt": nil}
+			}
+		}
+		query["sort"] = sort
+	}
+	if filter.Limit < 0 || filter.Limit > maxQueryLimit {
+		return "", newError(codeInvalidArgument, "filter", "Limit must be between 0 and %d, 0 for no limit", maxQueryLimit)
+	} else if filter.Limit > 0 {
+		if paginated {
+			return "", newError(codeInvalidArgument, "filter", "Limit cannot be used with pagination, use the page size")
+		}
+		query["limit"] = filter.Limit
+	}
+
+	// the maps are marshalled with sorted keys, so every peer runs the same query
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(query)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// findSortIndex returns the fields of the sort index starting with the sort fields, or nil
+func findSortIndex(sortFields []string) []string {
+	for _, index := range sortIndexes {
+		if len(sortFields) > len(index.Fields) {
+			continue
+		}
+		matches := true
+		for i, field := range sortFields {
+			matches = matches && index.Fields[i] == field
+		}
+		if matches {
+			return index.Fields
+		}
+	}
+	return nil
+}
+
+// checkFilterValue checks the value of a condition has the type of the field, or is a
+// list of such values for the "in" operator
+func checkFilterValue(condition queryCondition, field queryField) error {
+	values := []interface{}{condition.Value}
+	if condition.Operator == "in" {
+		list, ok := condition.Value.([]interface{})
+		if !ok || len(list) == 0 || len(list) > maxQueryInValues {
+			return newError(codeInvalidArgument, "filter", "Value of %s in must be a list of 1 to %d values", condition.Field, maxQueryInValues)
+		}
+		values = list
+	}
+
+	for _, value := range values {
+		switch value.(type) {
+		case string:
+			if field.Type == fieldString {
+				continue
+			}
+		case json.Number:
+			if field.Type == fieldNumber {
+				continue
+			}
+		}
+		return newError(codeInvalidArgument, "filter", "Value of %s must be a %s", condition.Field, field.Type)
+	}
+	return nil
+}
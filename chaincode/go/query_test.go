@@ -0,0 +1,180 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		query  string
+	}{
+		{"no filter", `{}`, `{"selector":{"docType":"picture"}}`},
+		{"no limit", `{"limit":0}`, `{"selector":{"docType":"picture"}}`},
+		{"equality", `{"filters":[{"field":"artistId","operator":"eq","value":"claude-monet"}]}`,
+			`{"selector":{"artistId":{"$eq":"claude-monet"},"docType":"picture"}}`},
+		{"range", `{"filters":[{"field":"year","operator":"gte","value":1870},{"field":"year","operator":"lt","value":1900}]}`,
+			`{"selector":{"docType":"picture","year":{"$gte":1870,"$lt":1900}}}`},
+		{"in", `{"filters":[{"field":"status","operator":"in","value":["available","onLoan"]}]}`,
+			`{"selector":{"docType":"picture","status":{"$in":["available","onLoan"]}}}`},
+		{"nested field", `{"filters":[{"field":"owner.mspId","operator":"ne","value":"LouvreMSP"}]}`,
+			`{"selector":{"docType":"picture","owner.mspId":{"$ne":"LouvreMSP"}}}`},
+		{"sort", `{"sort":[{"field":"size","order":"desc"}],"limit":20}`,
+			`{"limit":20,"selector":{"docType":"picture","size":{"$gt":null}},"sort":[{"size":"desc"}]}`},
+		{"sort with an in condition", `{"filters":[{"field":"status","operator":"in","value":["available","onLoan"]}],"sort":[{"field":"size","order":"desc"}]}`,
+			`{"selector":{"docType":"picture","size":{"$gt":null},"status":{"$in":["available","onLoan"]}},"sort":[{"size":"desc"}]}`},
+		{"sort on a filtered field", `{"filters":[{"field":"year","operator":"lt","value":1900}],"sort":[{"field":"year","order":"asc"}]}`,
+			`{"selector":{"docType":"picture","year":{"$lt":1900}},"sort":[{"year":"asc"}]}`},
+		{"special characters", `{"filters":[{"field":"title","operator":"eq","value":"<Nymphéas> & \"Lilies\""}]}`,
+			`{"selector":{"docType":"picture","title":{"$eq":"<Nymphéas> & \"Lilies\""}}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := compileFilter(test.filter, false)
+			if err != nil {
+				t.Fatalf("Failed to compile %s: %s", test.filter, err)
+			}
+			if query != test.query {
+				t.Fatalf("Unexpected query %s", query)
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    string
+		paginated bool
+		message   string
+	}{
+		{"not JSON", `{"filters":`, false, "Invalid filter"},
+		{"raw selector", `{"selector":{"docType":"transferOffer"}}`, false, `unknown field "selector"`},
+		{"docType", `{"filters":[{"field":"docType","operator":"eq","value":"sale"}]}`, false, `Cannot filter on field "docType"`},
+		{"unknown field", `{"filters":[{"field":"price","operator":"gt","value":0}]}`, false, `Cannot filter on field "price"`},
+		{"couchdb operator", `{"filters":[{"field":"artistId","operator":"$regex","value":".*"}]}`, false, `Unknown operator "$regex"`},
+		{"operator object", `{"filters":[{"field":"artistId","operator":"eq","value":{"$ne":""}}]}`, false, "Value of artistId must be a string"},
+		{"string for a number", `{"filters":[{"field":"year","operator":"eq","value":"1906"}]}`, false, "Value of year must be a number"},
+		{"in without a list", `{"filters":[{"field":"status","operator":"in","value":"available"}]}`, false, "must be a list"},
+		{"in with an empty list", `{"filters":[{"field":"status","operator":"in","value":[]}]}`, false, "must be a list"},
+		{"in with a wrong type", `{"filters":[{"field":"status","operator":"in","value":["available",1]}]}`, false, "Value of status must be a string"},
+		{"same condition twice", `{"filters":[{"field":"year","operator":"gt","value":1},{"field":"year","operator":"gt","value":2}]}`, false, "Field year has two gt conditions"},
+		{"unsortable field", `{"sort":[{"field":"artistId","order":"asc"}]}`, false, `Cannot sort on field "artistId"`},
+		{"sort order", `{"sort":[{"field":"size","order":"up"}]}`, false, "Sort order of size must be asc or desc"},
+		{"mixed sort orders", `{"sort":[{"field":"size","order":"asc"},{"field":"year","order":"desc"}]}`, false, "same order"},
+		{"sort without an index", `{"sort":[{"field":"size","order":"asc"},{"field":"year","order":"asc"}]}`, false, "No index sorts on size, year"},
+		{"negative limit", `{"limit":-1}`, false, "Limit must be between 0 and 1000, 0 for no limit"},
+		{"large limit", `{"limit":1001}`, false, "Limit must be between 0 and 1000, 0 for no limit"},
+		{"limit with pagination", `{"limit":10}`, true, "Limit cannot be used with pagination"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileFilter(test.filter, test.paginated)
+			e, ok := err.(*chaincodeError)
+			if !ok || e.Code != codeInvalidArgument || e.Field != "filter" || !strings.Contains(e.Message, test.message) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		})
+	}
+}
+
+// readCouchDBIndexes reads the fields of the indexes packaged with the chaincode, by index name
+func readCouchDBIndexes(t *testing.T) map[string][]string {
+	t.Helper()
+	paths, err := filepath.Glob("META-INF/statedb/couchdb/indexes/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No CouchDB indexes found (%v)", err)
+	}
+	indexes := map[string][]string{}
+	for _, path := range paths {
+		indexJSON, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		definition := struct {
+			Name  string
+			Index struct {
+				Fields []interface{} //a field name, or {"field":"asc|desc"}
+			}
+		}{}
+		err = json.Unmarshal(indexJSON, &definition)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", path, err)
+		}
+		for _, field := range definition.Index.Fields {
+			switch f := field.(type) {
+			case string:
+				indexes[definition.Name] = append(indexes[definition.Name], f)
+			case map[string]interface{}:
+				for name := range f {
+					indexes[definition.Name] = append(indexes[definition.Name], name)
+				}
+			}
+		}
+	}
+	return indexes
+}
+
+func TestSortIndexes(t *testing.T) {
+	indexes := readCouchDBIndexes(t)
+	for _, index := range sortIndexes {
+		if strings.Join(indexes[index.Name], ",") != strings.Join(index.Fields, ",") {
+			t.Fatalf("sortIndexes has %s on %v, META-INF on %v", index.Name, index.Fields, indexes[index.Name])
+		}
+	}
+
+	// every sorted query must be served by a packaged index: its sort fields start the
+	// fields of the index, and its selector constrains all of them
+	filters := []string{
+		`{"sort":[{"field":"size","order":"desc"}]}`,
+		`{"sort":[{"field":"size","order":"asc"}],"limit":10}`,
+		`{"filters":[{"field":"status","operator":"in","value":["available","onLoan"]}],"sort":[{"field":"size","order":"desc"}]}`,
+		`{"filters":[{"field":"owner.mspId","operator":"eq","value":"LouvreMSP"}],"sort":[{"field":"size","order":"desc"}]}`,
+		`{"filters":[{"field":"year","operator":"gte","value":1870},{"field":"year","operator":"lt","value":1900}],"sort":[{"field":"year","order":"desc"}],"limit":20}`,
+		`{"filters":[{"field":"artistId","operator":"eq","value":"claude-monet"}],"sort":[{"field":"year","order":"asc"}]}`,
+	}
+	for _, filter := range filters {
+		queryJSON, err := compileFilter(filter, false)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", filter, err)
+		}
+		query := struct {
+			Selector map[string]interface{}
+			Sort     []map[string]string
+		}{}
+		err = json.Unmarshal([]byte(queryJSON), &query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sortFields := []string{}
+		for _, s := range query.Sort {
+			for field := range s {
+				sortFields = append(sortFields, field)
+			}
+		}
+
+		served := false
+		for _, fields := range indexes {
+			if len(fields) < len(sortFields) || strings.Join(fields[:len(sortFields)], ",") != strings.Join(sortFields, ",") {
+				continue
+			}
+			constrained := true
+			for _, field := range fields {
+				constrained = constrained && query.Selector[field] != nil
+			}
+			served = served || constrained
+		}
+		if !served {
+			t.Fatalf("No index serves %s", queryJSON)
+		}
+	}
+}
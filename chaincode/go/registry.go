@@ -0,0 +1,384 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Function registry ====
+// Every function callable through Invoke is declared once in the functions table below, with
+// its arguments, whether it only reads state and the role the caller needs. Invoke checks the
+// arguments and the role before calling the handler, so handlers only validate what depends
+// on state or on several arguments (a price above zero, a deadline in the future, ...).
+// describeFunctions returns the table as JSON for client tooling.
+
+// argument types, checked before the handler runs
+const (
+	argString         = "string"    //non-empty string
+	argKey            = "key"       //state key, empty for an open-ended range
+	argBookmark       = "bookmark"  //pagination bookmark, empty for the first page
+	argFilter         = "filter"    //query parameter, empty to match any value
+	argText           = "text"      //free text, may be empty
+	argInt            = "int"       //decimal integer
+	argYear           = "year"      //year as a decimal integer, empty if unknown
+	argAmount         = "amount"    //decimal amount with at most two decimals, e.g. "1500000.00"
+	argCurrency       = "currency"  //ISO 4217 alphabetic code, e.g. "EUR"
+	argDate           = "date"      //YYYY-MM-DD
+	argTimestamp      = "timestamp" //RFC3339, e.g. "2018-06-30T18:00:00Z"
+	argJSON           = "json"      //JSON document
+	argHash           = "sha256"    //hex encoded SHA-256 hash
+	argPerceptualHash = "phash"     //hex encoded 64 bit perceptual hash, 16 digits
+)
+
+// roles a caller may need, checked before the handler runs. Handlers still check the caller
+// against the records involved, e.g. that it owns the picture it transfers.
+const (
+	roleAny      = "any"      //any client of the channel
+	roleGallery  = "gallery"  //a member of one of the galleryMSPs
+	roleMember   = "member"   //a member of one of the galleryMSPs or stolenRegistryMSPs
+	roleRegistry = "registry" //a member of one of the stolenRegistryMSPs
+	roleExpert   = "expert"   //a client whose certificate carries the expertAttribute, of any organisation
+)
+
+type argSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"` //optional arguments may only follow required ones
+}
+
+type chaincodeFunction struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Args        []argSpec `json:"args"`
+	ReadOnly    bool      `json:"readOnly"` //only reads state, evaluate it with a query instead of submitting it
+	Role        string    `json:"role"`
+	handler     func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response
+}
+
+// arg and optionalArg keep the table below readable
+func arg(name, argType string) argSpec {
+	return argSpec{Name: name, Type: argType, Required: true}
+}
+
+func optionalArg(name, argType string) argSpec {
+	return argSpec{Name: name, Type: argType}
+}
+
+// functions is filled in init, as describeFunctions refers to it
+var functions []*chaincodeFunction
+
+func init() {
+	functions = []*chaincodeFunction{
+		// ==== Pictures ====
+		{Name: "initPicture", Description: "create a new picture owned by the caller, with the optional privateDetails transient field", Role: roleGallery, handler: (*SimpleChaincode).initPicture,
+			Args: []argSpec{arg("name", argString), arg("generation", argString), arg("size", argInt), arg("artistId", argString), arg("title", argString), arg("year", argInt), arg("medium", argString), arg("dimensions", argString), arg("inventoryNumber", argString)}},
+		{Name: "transferPicture", Description: "change owner of a specific picture", Role: roleGallery, handler: (*SimpleChaincode).transferPicture,
+			Args: []argSpec{arg("name", argString), arg("newOwnerMspId", argString), arg("newOwnerSubject", argString)}},
+		{Name: "transferPicturesBasedOnGeneration", Description: "transfer all pictures of a certain generation", Role: roleGallery, handler: (*SimpleChaincode).transferPicturesBasedOnGeneration,
+			Args: []argSpec{arg("generation", argString), arg("newOwnerMspId", argString), arg("newOwnerSubject", argString)}},
+		{Name: "retirePicture", Description: "take a picture out of the collection, keeping its record", Role: roleGallery, handler: (*SimpleChaincode).retirePicture,
+			Args: []argSpec{arg("name", argString), arg("status", argString), arg("reason", argString)}},
+		{Name: "recoverPicture", Description: "bring a lost or stolen picture back to the collection", Role: roleGallery, handler: (*SimpleChaincode).recoverPicture,
+			Args: []argSpec{arg("name", argString), arg("reason", argString)}},
+		{Name: "readPicture", Description: "read a picture", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).readPicture,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "queryPicturesByOwner", Description: "find pictures of an owner using rich query", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).queryPicturesByOwner,
+			Args: []argSpec{arg("ownerMspId", argString), optionalArg("ownerSubject", argString)}},
+		{Name: "queryPictures", Description: "find pictures matching a filter over the picture fields", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).queryPictures,
+			Args: []argSpec{arg("filter", argJSON)}},
+		{Name: "getPicturesByOwner", Description: "find pictures of an owner using the owner~name index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesByOwner,
+			Args: []argSpec{arg("ownerMspId", argString), optionalArg("ownerSubject", argString)}},
+		{Name: "getPicturesByArtist", Description: "find pictures of an artist using the artist~name index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesByArtist,
+			Args: []argSpec{arg("artistId", argString)}},
+		{Name: "getPicturesByStatus", Description: "find pictures with a status using the status~name index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesByStatus,
+			Args: []argSpec{arg("status", argString)}},
+		{Name: "getHistoryForPicture", Description: "get history of values for a picture", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getHistoryForPicture,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "getProvenance", Description: "get the chain of custody of a picture from its history", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getProvenance,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "getPicturesByRange", Description: "get pictures based on range query, the end key is excluded", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesByRange,
+			Args: []argSpec{arg("startKey", argKey), arg("endKey", argKey)}},
+		{Name: "getPicturesByRangeWithPagination", Description: "get a page of pictures based on range query", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesByRangeWithPagination,
+			Args: []argSpec{arg("startKey", argKey), arg("endKey", argKey), arg("pageSize", argInt), arg("bookmark", argBookmark)}},
+		{Name: "queryPicturesWithPagination", Description: "get a page of pictures matching a filter over the picture fields", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).queryPicturesWithPagination,
+			Args: []argSpec{arg("filter", argJSON), arg("pageSize", argInt), arg("bookmark", argBookmark)}},
+		{Name: "queryPicturesByOwnerWithPagination", Description: "get a page of the pictures of an owner using rich query", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).queryPicturesByOwnerWithPagination,
+			Args: []argSpec{arg("ownerMspId", argString), arg("ownerSubject", argFilter), arg("pageSize", argInt), arg("bookmark", argBookmark)}},
+		{Name: "getPicturesByGenerationWithPagination", Description: "get a page of the pictures of a generation, retired ones excluded, included or only", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesByGenerationWithPagination,
+			Args: []argSpec{arg("generation", argString), arg("pageSize", argInt), arg("bookmark", argBookmark), optionalArg("retired", argString)}},
+
+		// ==== Two-phase transfers ====
+		{Name: "offerTransfer", Description: "propose a picture to a new owner", Role: roleGallery, handler: (*SimpleChaincode).offerTransfer,
+			Args: []argSpec{arg("name", argString), arg("toMspId", argString), arg("toSubject", argString), optionalArg("validityDays", argInt)}},
+		{Name: "acceptTransfer", Description: "new owner accepts a pending offer", Role: roleGallery, handler: (*SimpleChaincode).acceptTransfer,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "rejectTransfer", Description: "new owner declines a pending offer", Role: roleGallery, handler: (*SimpleChaincode).rejectTransfer,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "cancelTransfer", Description: "current owner withdraws a pending offer", Role: roleGallery, handler: (*SimpleChaincode).cancelTransfer,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "expireTransferOffers", Description: "clean up offers past their deadline", Role: roleAny, handler: (*SimpleChaincode).expireTransferOffers},
+		{Name: "readTransferOffer", Description: "read the pending offer for a picture", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).readTransferOffer,
+			Args: []argSpec{arg("name", argString)}},
+
+		// ==== Sales ====
+		{Name: "sellPicture", Description: "offer a picture to a buyer at the price of the price transient field", Role: roleGallery, handler: (*SimpleChaincode).sellPicture,
+			Args: []argSpec{arg("name", argString), arg("buyerMspId", argString), arg("buyerSubject", argString), optionalArg("validityDays", argInt)}},
+		{Name: "getSalesForPicture", Description: "get the settled sales of a picture", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getSalesForPicture,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "getSalesByOwner", Description: "get the sales an owner took part in", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getSalesByOwner,
+			Args: []argSpec{arg("mspId", argString), optionalArg("subject", argString)}},
+
+		// ==== Auctions ====
+		{Name: "createAuction", Description: "put a picture up for auction", Role: roleGallery, handler: (*SimpleChaincode).createAuction,
+			Args: []argSpec{arg("name", argString), arg("type", argString), arg("currency", argCurrency), arg("reservePrice", argAmount), arg("biddingEnds", argTimestamp), optionalArg("revealEnds", argTimestamp)}},
+		{Name: "placeBid", Description: "bid in an english auction", Role: roleGallery, handler: (*SimpleChaincode).placeBid,
+			Args: []argSpec{arg("auctionId", argString), arg("amount", argAmount)}},
+		{Name: "commitBid", Description: "commit to a hidden bid in a sealed-bid auction", Role: roleGallery, handler: (*SimpleChaincode).commitBid,
+			Args: []argSpec{arg("auctionId", argString), arg("commitment", argHash)}},
+		{Name: "revealBid", Description: "reveal a committed bid once bidding is over, in the bid transient field", Role: roleGallery, handler: (*SimpleChaincode).revealBid,
+			Args: []argSpec{arg("auctionId", argString)}},
+		{Name: "closeAuction", Description: "settle an auction with its winner", Role: roleGallery, handler: (*SimpleChaincode).closeAuction,
+			Args: []argSpec{arg("auctionId", argString)}},
+		{Name: "cancelAuction", Description: "withdraw an auction without bids", Role: roleGallery, handler: (*SimpleChaincode).cancelAuction,
+			Args: []argSpec{arg("auctionId", argString)}},
+		{Name: "readAuction", Description: "read an auction", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).readAuction,
+			Args: []argSpec{arg("auctionId", argString)}},
+		{Name: "readAuctionBids", Description: "read the revealed sealed bid amounts of an auction held by the caller's organisation", ReadOnly: true, Role: roleGallery, handler: (*SimpleChaincode).readAuctionBids,
+			Args: []argSpec{arg("auctionId", argString)}},
+
+		// ==== Loans ====
+		{Name: "requestLoan", Description: "ask to borrow a picture", Role: roleGallery, handler: (*SimpleChaincode).requestLoan,
+			Args: []argSpec{arg("name", argString), arg("startDate", argDate), arg("endDate", argDate)}},
+		{Name: "approveLoan", Description: "owner lends a picture to the borrower", Role: roleGallery, handler: (*SimpleChaincode).approveLoan,
+			Args: []argSpec{arg("loanId", argString)}},
+		{Name: "recordReturn", Description: "owner confirms a lent picture is back", Role: roleGallery, handler: (*SimpleChaincode).recordReturn,
+			Args: []argSpec{arg("loanId", argString)}},
+		{Name: "getActiveLoans", Description: "get the loans currently running", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getActiveLoans,
+			Args: []argSpec{optionalArg("mspId", argString)}},
+
+		// ==== Stolen art registry ====
+		{Name: "reportStolen", Description: "flag a picture as stolen or looted", Role: roleMember, handler: (*SimpleChaincode).reportStolen,
+			Args: []argSpec{arg("name", argString), arg("reference", argString), optionalArg("description", argString)}},
+		{Name: "clearStolenFlag", Description: "lift the stolen flag of a picture", Role: roleRegistry, handler: (*SimpleChaincode).clearStolenFlag,
+			Args: []argSpec{arg("name", argString), arg("reason", argString)}},
+		{Name: "reportTransferAttempt", Description: "record a refused transfer of a stolen picture and raise the alert", Role: roleGallery, handler: (*SimpleChaincode).reportTransferAttempt,
+			Args: []argSpec{arg("name", argString), arg("newOwnerMspId", argString), arg("newOwnerSubject", argString)}},
+		{Name: "checkTitle", Description: "check the owner of a picture and whether it may be traded", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).checkTitle,
+			Args: []argSpec{arg("name", argString)}},
+
+		// ==== Artists ====
+		{Name: "createArtist", Description: "register an artist pictures can refer to", Role: roleGallery, handler: (*SimpleChaincode).createArtist,
+			Args: []argSpec{arg("artistId", argString), arg("name", argString), optionalArg("birthYear", argYear), optionalArg("deathYear", argYear), optionalArg("nationality", argText), optionalArg("ulanId", argText), optionalArg("wikidataId", argText)}},
+		{Name: "readArtist", Description: "read an artist", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).readArtist,
+			Args: []argSpec{arg("artistId", argString)}},
+		{Name: "updateArtist", Description: "replace the details of an artist", Role: roleGallery, handler: (*SimpleChaincode).updateArtist,
+			Args: []argSpec{arg("artistId", argString), arg("name", argString), optionalArg("birthYear", argYear), optionalArg("deathYear", argYear), optionalArg("nationality", argText), optionalArg("ulanId", argText), optionalArg("wikidataId", argText)}},
+		{Name: "deleteArtist", Description: "remove an artist without pictures", Role: roleGallery, handler: (*SimpleChaincode).deleteArtist,
+			Args: []argSpec{arg("artistId", argString)}},
+
+		// ==== Exhibitions and collections ====
+		{Name: "createExhibition", Description: "open an exhibition organised by the caller's gallery", Role: roleGallery, handler: (*SimpleChaincode).createExhibition,
+			Args: []argSpec{arg("title", argString), arg("venue", argString), arg("startDate", argDate), arg("endDate", argDate)}},
+		{Name: "createCollection", Description: "create a collection organised by the caller's gallery", Role: roleGallery, handler: (*SimpleChaincode).createCollection,
+			Args: []argSpec{arg("title", argString)}},
+		{Name: "addToExhibition", Description: "add a picture to an exhibition or a collection", Role: roleGallery, handler: (*SimpleChaincode).addToExhibition,
+			Args: []argSpec{arg("exhibitionId", argString), arg("name", argString)}},
+		{Name: "removeFromExhibition", Description: "take a picture out of an exhibition or a collection", Role: roleGallery, handler: (*SimpleChaincode).removeFromExhibition,
+			Args: []argSpec{arg("exhibitionId", argString), arg("name", argString)}},
+		{Name: "readExhibition", Description: "read an exhibition or a collection", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).readExhibition,
+			Args: []argSpec{arg("exhibitionId", argString)}},
+		{Name: "getExhibitionsForPicture", Description: "list the exhibitions and collections of a picture using the picture~exhibition index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getExhibitionsForPicture,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "getExhibitionsByDateRange", Description: "list the exhibitions open between two dates using the month~exhibition index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getExhibitionsByDateRange,
+			Args: []argSpec{arg("from", argDate), arg("to", argDate)}},
+
+		// ==== Private data ====
+		{Name: "setPicturePrivateDetails", Description: "record the private details of the privateDetails transient field", Role: roleGallery, handler: (*SimpleChaincode).setPicturePrivateDetails,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "readPicturePrivateDetails", Description: "read the private details of a picture of the caller's organisation", ReadOnly: true, Role: roleGallery, handler: (*SimpleChaincode).readPicturePrivateDetails,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "readSalePrice", Description: "read the price of a sale the caller's organisation took part in", ReadOnly: true, Role: roleGallery, handler: (*SimpleChaincode).readSalePrice,
+			Args: []argSpec{arg("name", argString), arg("saleTxId", argString)}},
+
+		// ==== Image fingerprints ====
+		{Name: "addImageFingerprint", Description: "register the fingerprint of a reference photograph of a picture", Role: roleGallery, handler: (*SimpleChaincode).addImageFingerprint,
+			Args: []argSpec{arg("name", argString), arg("sha256", argHash), arg("pHash", argPerceptualHash), optionalArg("description", argText)}},
+		{Name: "getImageFingerprints", Description: "list the image fingerprints of a picture", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getImageFingerprints,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "verifyImage", Description: "find the pictures of a SHA-256 or perceptual image hash", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).verifyImage,
+			Args: []argSpec{arg("hash", argString), optionalArg("maxDistance", argInt)}},
+
+		// ==== Certificates of authenticity ====
+		{Name: "issueCertificate", Description: "certify a picture as described by its current record", Role: roleExpert, handler: (*SimpleChaincode).issueCertificate,
+			Args: []argSpec{arg("name", argString), arg("statement", argString)}},
+		{Name: "revokeCertificate", Description: "issuer withdraws a certificate", Role: roleAny, handler: (*SimpleChaincode).revokeCertificate,
+			Args: []argSpec{arg("certificateId", argString), arg("reason", argString)}},
+		{Name: "getCertificates", Description: "list the certificates of a picture using the picture~certificate index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getCertificates,
+			Args: []argSpec{arg("name", argString)}},
+		{Name: "verifyCertificate", Description: "check a certificate against its issuer, its status and the current picture record", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).verifyCertificate,
+			Args: []argSpec{arg("certificateId", argString), optionalArg("issuerMspId", argFilter), optionalArg("issuerSubject", argFilter)}},
+
+		// ==== Condition reports ====
+		{Name: "addConditionReport", Description: "record the condition of a picture examined by the caller", Role: roleGallery, handler: (*SimpleChaincode).addConditionReport,
+			Args: []argSpec{arg("name", argString), arg("date", argDate), arg("grade", argString), arg("notes", argText), optionalArg("documentHash", argHash)}},
+		{Name: "getConditionReports", Description: "list the condition reports of a picture by examination date", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getConditionReports,
+			Args: []argSpec{arg("name", argString)}},
+
+		// ==== Physical location ====
+		{Name: "moveLocation", Description: "record where a picture now is, or that it is in transit", Role: roleGallery, handler: (*SimpleChaincode).moveLocation,
+			Args: []argSpec{arg("name", argString), arg("site", argString), optionalArg("building", argText), optionalArg("room", argText), optionalArg("storageUnit", argText)}},
+		{Name: "getPicturesAtSite", Description: "find the pictures at a site using the location~name index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesAtSite,
+			Args: []argSpec{arg("site", argString), optionalArg("building", argString), optionalArg("room", argString)}},
+		{Name: "getPicturesInTransit", Description: "find the pictures in transit using the location~name index", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).getPicturesInTransit},
+
+		// ==== Registry ====
+		{Name: "describeFunctions", Description: "list the functions of the chaincode and their arguments", ReadOnly: true, Role: roleAny, handler: (*SimpleChaincode).describeFunctions},
+	}
+
+	// functions without arguments are described with an empty list
+	for _, f := range functions {
+		if f.Args == nil {
+			f.Args = []argSpec{}
+		}
+	}
+}
+
+func lookupFunction(name string) *chaincodeFunction {
+	for _, f := range functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// ===========================================================================================
+// checkArgs validates the number of arguments and the type of each of them
+// ===========================================================================================
+func (f *chaincodeFunction) checkArgs(args []string) error {
+	required := 0
+	for _, spec := range f.Args {
+		if spec.Required {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(f.Args) {
+		switch {
+		case required == len(f.Args):
+			return newError(codeInvalidArgument, "", "Incorrect number of arguments. Expecting %d", required)
+		case required+1 == len(f.Args):
+			return newError(codeInvalidArgument, "", "Incorrect number of arguments. Expecting %d or %d", required, len(f.Args))
+		default:
+			return newError(codeInvalidArgument, "", "Incorrect number of arguments. Expecting %d to %d", required, len(f.Args))
+		}
+	}
+
+	for i, value := range args {
+		spec := f.Args[i]
+		if problem := checkArgType(spec.Type, value); problem != "" {
+			return newError(codeInvalidArgument, spec.Name, "Argument %d (%s) must be %s", i+1, spec.Name, problem)
+		}
+	}
+	return nil
+}
+
+// checkArgType returns what is wrong with a value for a type, or an empty string if it is valid
+func checkArgType(argType, value string) string {
+	switch argType {
+	case argKey, argBookmark, argFilter, argText:
+		return ""
+	case argYear:
+		if _, err := strconv.Atoi(value); err != nil && value != "" {
+			return "a year or empty"
+		}
+	case argInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "an integer"
+		}
+	case argAmount:
+		if !amountPattern.MatchString(value) {
+			return "a decimal amount with at most two decimals"
+		}
+	case argCurrency:
+		if !currencyPattern.MatchString(value) {
+			return "an ISO 4217 currency code such as EUR"
+		}
+	case argDate:
+		if _, err := time.Parse(loanDateLayout, value); err != nil {
+			return "a date formatted as YYYY-MM-DD"
+		}
+	case argTimestamp:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "an RFC3339 timestamp"
+		}
+	case argJSON:
+		if !json.Valid([]byte(value)) {
+			return "a JSON document"
+		}
+	case argHash:
+		if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != sha256.Size {
+			return "a hex encoded SHA-256 hash"
+		}
+	case argPerceptualHash:
+		if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != 8 {
+			return "a 64 bit perceptual hash as 16 hex digits"
+		}
+	default:
+		if len(strings.TrimSpace(value)) <= 0 {
+			return "a non-empty string"
+		}
+	}
+	return ""
+}
+
+// ===========================================================================================
+// checkRole verifies the caller holds the role the function requires
+// ===========================================================================================
+func (f *chaincodeFunction) checkRole(stub shim.ChaincodeStubInterface) error {
+	if f.Role == roleAny {
+		return nil
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	switch {
+	case f.Role == roleGallery && !galleryMSPs[caller.MSPID]:
+		return newError(codeForbidden, "", "%s is reserved to gallery members, not %s", f.Name, caller.MSPID)
+	case f.Role == roleMember && !galleryMSPs[caller.MSPID] && !stolenRegistryMSPs[caller.MSPID]:
+		return newError(codeForbidden, "", "%s is reserved to gallery and registry members, not %s", f.Name, caller.MSPID)
+	case f.Role == roleRegistry && !stolenRegistryMSPs[caller.MSPID]:
+		return newError(codeForbidden, "", "%s is reserved to stolen art registry members, not %s", f.Name, caller.MSPID)
+	case f.Role == roleExpert:
+		expert, err := isExpert(stub)
+		if err != nil {
+			return err
+		} else if !expert {
+			return newError(codeForbidden, "", "%s is reserved to art experts, %s has no %s attribute", f.Name, caller, expertAttribute)
+		}
+	}
+	return nil
+}
+
+// ===========================================================================================
+// describeFunctions - list the functions of the chaincode with their arguments, so clients
+// can build and check invocations without hard-coding them
+// ===========================================================================================
+func (t *SimpleChaincode) describeFunctions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	functionsJSONasBytes, err := json.Marshal(functions)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(functionsJSONasBytes)
+}
@@ -0,0 +1,91 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDescribeFunctions(t *testing.T) {
+	stub := newTestStub()
+	described := []chaincodeFunction{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "describeFunctions"), &described)
+	if err != nil {
+		t.Fatal("Failed to decode functions:", err)
+	}
+	if len(described) != len(functions) {
+		t.Fatalf("Described %d functions, %d are registered", len(described), len(functions))
+	}
+	for i, f := range described {
+		if f.Name != functions[i].Name || f.Role != functions[i].Role || f.ReadOnly != functions[i].ReadOnly || len(f.Args) != len(functions[i].Args) {
+			t.Fatalf("Unexpected description %+v of %s", f, functions[i].Name)
+		}
+	}
+	if f := described[0]; f.Name != "initPicture" || f.Args[2] != arg("size", argInt) {
+		t.Fatalf("Unexpected description %+v", f)
+	}
+}
+
+func TestRegisteredFunctions(t *testing.T) {
+	seen := map[string]bool{}
+	for _, f := range functions {
+		if seen[f.Name] {
+			t.Fatalf("%s is registered twice", f.Name)
+		}
+		seen[f.Name] = true
+		if f.handler == nil || f.Description == "" || (f.Role != roleAny && f.Role != roleGallery && f.Role != roleMember && f.Role != roleRegistry && f.Role != roleExpert) {
+			t.Fatalf("%s is not fully declared", f.Name)
+		}
+		for i, spec := range f.Args {
+			if !spec.Required && i+1 < len(f.Args) && f.Args[i+1].Required {
+				t.Fatalf("%s has a required argument after an optional one", f.Name)
+			}
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		message string
+	}{
+		{"too few", []string{"picture1", "GuggenheimMSP"}, "Incorrect number of arguments. Expecting 3 or 4"},
+		{"too many", []string{"picture1", "GuggenheimMSP", "buyer", "7", "extra"}, "Incorrect number of arguments. Expecting 3 or 4"},
+		{"empty string", []string{"picture1", " ", "buyer"}, "Argument 2 (toMspId) must be a non-empty string"},
+		{"optional integer", []string{"picture1", "GuggenheimMSP", "buyer", "week"}, "Argument 4 (validityDays) must be an integer"},
+		{"valid", []string{"picture1", "GuggenheimMSP", "buyer"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := lookupFunction("offerTransfer").checkArgs(test.args)
+			if test.message == "" && err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if test.message != "" && (err == nil || err.Error() != test.message) {
+				t.Fatalf("Got error %v, expected %q", err, test.message)
+			}
+		})
+	}
+
+	f := &chaincodeFunction{Name: "test", Args: []argSpec{arg("a", argString), optionalArg("b", argJSON), optionalArg("c", argBookmark)}}
+	if err := f.checkArgs(nil); err == nil || err.Error() != "Incorrect number of arguments. Expecting 1 to 3" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := f.checkArgs([]string{"a", "{"}); err == nil || err.Error() != "Argument 2 (b) must be a JSON document" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := f.checkArgs([]string{"a", "{}", ""}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+func TestCheckRole(t *testing.T) {
+	stub := newTestStub()
+	checkInvokeError(t, stub, outsiderUser, "initPicture is reserved to gallery members, not OutsiderMSP", "initPicture", "picture1", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkInvoke(t, stub, outsiderUser, "readPicture", "picture1")
+}
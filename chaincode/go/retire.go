@@ -0,0 +1,211 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Retirement ====
+// Pictures are cultural heritage records and are never deleted. A picture leaving the
+// collection is retired instead: it gets one of the statuses below, and the reason and
+// date of its retirement. It stays readable, keeps its history and provenance, and moves
+// from the generation~name index to retired~generation~name, see index.go. A retired
+// picture is not available, so it can no longer be transferred, sold, auctioned or lent.
+//
+// statusTransitions lists the changes of status allowed. A lost or stolen picture may be
+// destroyed or, once stolen, be restituted to its rightful owners with retirePicture. A lost
+// picture comes back to the collection with recoverPicture. The stolen status follows the
+// stolen flag instead, see stolen.go: reportStolen sets it and clearStolenFlag lifts it.
+// Deaccessioned, destroyed and restituted pictures are gone for good.
+
+const (
+	statusDeaccessioned = "deaccessioned" //formally removed from the collection, e.g. sold or exchanged
+	statusDestroyed     = "destroyed"
+	statusLost          = "lost"
+	statusStolen        = "stolen"
+	statusRestituted    = "restituted" //returned to its rightful owners, e.g. after a spoliation claim
+)
+
+// retiredStatuses lists the statuses of pictures out of the collection
+var retiredStatuses = map[string]bool{
+	statusDeaccessioned: true,
+	statusDestroyed:     true,
+	statusLost:          true,
+	statusStolen:        true,
+	statusRestituted:    true,
+}
+
+// statusTransitions lists, by status, the statuses retirePicture, recoverPicture and the stolen
+// registry may give a picture. Other statuses are only left through the process that set them, e.g. a loan.
+var statusTransitions = map[string]map[string]bool{
+	statusAvailable: {statusDeaccessioned: true, statusDestroyed: true, statusLost: true, statusStolen: true, statusRestituted: true},
+	statusLost:      {statusAvailable: true, statusStolen: true, statusDestroyed: true},
+	statusStolen:    {statusAvailable: true, statusRestituted: true, statusDestroyed: true},
+}
+
+// checkStatusTransition verifies a picture may go to a status. An available picture must
+// also be free of any loan.
+func checkStatusTransition(pic *picture, status string) error {
+	if _, managed := statusTransitions[pic.Status]; !managed || pic.Status == statusAvailable {
+		err := checkAvailable(pic)
+		if err != nil {
+			return err
+		}
+	}
+	if !statusTransitions[pic.Status][status] {
+		return newError(codeConflict, "status", "Picture %s cannot go from %s to %s", pic.Name, pic.Status, status)
+	}
+	return nil
+}
+
+// checkNotRetired verifies a picture is still in the collection, for processes that do not
+// need it to be available right away, e.g. loan requests
+func checkNotRetired(pic *picture) error {
+	if retiredStatuses[pic.Status] {
+		return newError(codeConflict, "", "Picture %s is retired, its status is %s", pic.Name, pic.Status)
+	}
+	return nil
+}
+
+// ===========================================================================================
+// retirePicture - the owner of a picture, or an admin of its organisation, takes it out of
+// the collection with a retirement status and the reason for it, or changes the status of a
+// lost or stolen picture as statusTransitions allows
+// ===========================================================================================
+func (t *SimpleChaincode) retirePicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0          1                 2
+	// "name", "deaccessioned", "Sold at public auction, board decision 2018-07"
+	pictureName := args[0]
+	status := args[1]
+	reason := strings.TrimSpace(args[2]) //Invoke checks it is not blank, see registry.go
+	if !retiredStatuses[status] {
+		return errorResponse(newError(codeInvalidArgument, "status", "Unknown retirement status: %s", status))
+	} else if status == statusStolen {
+		return errorResponse(newError(codeInvalidArgument, "status", "Use reportStolen to report picture %s stolen", pictureName))
+	}
+	fmt.Println("- start retirePicture ", pictureName, status)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkStatusTransition(pic, status)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// a stolen picture restituted or destroyed is no longer looked for
+	if pic.Status == statusStolen {
+		flag, err := getActiveStolenFlag(stub, pictureName)
+		if err != nil {
+			return errorResponse(err)
+		} else if flag != nil {
+			caller, _, err := getCaller(stub)
+			if err != nil {
+				return errorResponse(err)
+			}
+			err = endStolenFlag(stub, flag, caller, reason, now)
+			if err != nil {
+				return errorResponse(err)
+			}
+		}
+	}
+	pic.Status = status
+	pic.RetirementReason = reason
+	pic.RetiredAt = now.Format(time.RFC3339)
+	// putPicture also moves the index entries, the generation one included
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// a pending offer cannot be accepted any more
+	err = deleteTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+	}
+
+	err = emitEvent(stub, eventPictureRetired, []string{pictureName}, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end retirePicture (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// recoverPicture - the owner of a lost or stolen picture, or an admin of its organisation,
+// brings it back to the collection once found, with the circumstances of the recovery
+// ===========================================================================================
+func (t *SimpleChaincode) recoverPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0          1
+	// "name", "Found by the police in a private collection, case OCBC-2018-0412"
+	pictureName := args[0]
+	reason := strings.TrimSpace(args[1])
+	fmt.Println("- start recoverPicture ", pictureName)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if pic.Status != statusLost && pic.Status != statusStolen {
+		return errorResponse(newError(codeConflict, "", "Picture %s is not lost or stolen, its status is %s", pictureName, pic.Status))
+	}
+	// only pictures retired as stolen before the registry existed have no flag
+	flag, err := getActiveStolenFlag(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if flag != nil {
+		return errorResponse(newError(codeConflict, "", "Picture %s is reported stolen, reference %s, use clearStolenFlag", pictureName, flag.Reference))
+	}
+	err = checkStatusTransition(pic, statusAvailable)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pic.Status = statusAvailable
+	pic.RetirementReason = ""
+	pic.RetiredAt = ""
+	pic.RecoveryReason = reason
+	pic.RecoveredAt = now.Format(time.RFC3339)
+	// putPicture moves the index entries back, the generation one included
+	err = putPicture(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventPictureRecovered, []string{pictureName}, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end recoverPicture (success)")
+	return shim.Success(nil)
+}
@@ -0,0 +1,315 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+func TestRetirePicture(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 3", "retirePicture", "picture1", statusDestroyed)
+	checkInvokeError(t, stub, louvreUser, "Unknown retirement status: available", "retirePicture", "picture1", statusAvailable, "Fire")
+	checkInvokeError(t, stub, louvreUser, "Argument 3 (reason) must be a non-empty string", "retirePicture", "picture1", statusDestroyed, "  ")
+	checkInvokeError(t, stub, louvreUser, "Picture does not exist: picture3", "retirePicture", "picture3", statusDestroyed, "Fire")
+	checkInvokeError(t, stub, louvreUser, "Use reportStolen to report picture picture1 stolen", "retirePicture", "picture1", statusStolen, "Taken")
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture1", "retirePicture", "picture1", statusDestroyed, "Fire")
+	checkInvokeError(t, stub, guggenheimAdmin, "Not allowed to manage picture picture1", "retirePicture", "picture1", statusDestroyed, "Fire")
+
+	checkInvoke(t, stub, louvreUser, "retirePicture", "picture1", statusDeaccessioned, " Exchanged with the Guggenheim ")
+	if event := lastEvent(t, stub); event.Type != eventPictureRetired {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+
+	// the record stays, with the reason, but leaves the generation~name index
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Status != statusDeaccessioned || pic.RetirementReason != "Exchanged with the Guggenheim" || pic.RetiredAt == "" || pic.Owner != louvreUser.id {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	checkInvoke(t, stub, outsiderUser, "readPicture", "picture1")
+	if stub.State[compositeKey(t, stub, generationIndex, "blue", "picture1")] != nil {
+		t.Fatal("The generation~name index entry was not removed")
+	}
+	if stub.State[compositeKey(t, stub, retiredGenerationIndex, "blue", "picture1")] == nil {
+		t.Fatal("The retired~generation~name index entry was not written")
+	}
+	if stub.State[compositeKey(t, stub, transferOfferPrefix, "picture1")] != nil {
+		t.Fatal("The pending transfer offer was not deleted")
+	}
+
+	// a retired picture is no longer available
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available, its status is deaccessioned", "retirePicture", "picture1", statusLost, "Lost in transit")
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not lost or stolen, its status is deaccessioned", "recoverPicture", "picture1", "Bought back")
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not available", "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, guggenheimUser, "Picture picture1 is retired, its status is deaccessioned", "requestLoan", "picture1", loanDate(0), loanDate(30))
+	checkInvoke(t, stub, louvreUser, "transferPicturesBasedOnGeneration", "blue", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	if pic := checkPicture(t, stub, "picture1"); pic.Owner != louvreUser.id {
+		t.Fatalf("The bulk transfer moved a retired picture: %+v", pic)
+	}
+
+	// admins manage the pictures of their organisation, but not pictures held elsewhere
+	setPictureStatus(t, stub, "picture2", statusAtAuction)
+	checkInvokeError(t, stub, guggenheimAdmin, "Picture picture2 is not available", "retirePicture", "picture2", statusLost, "Lost")
+	setPictureStatus(t, stub, "picture2", statusAvailable)
+	checkInvoke(t, stub, guggenheimAdmin, "retirePicture", "picture2", statusLost, "Lost in the reserve")
+}
+
+func TestGetPicturesByGenerationRetired(t *testing.T) {
+	stub := newTestStub()
+	for _, name := range []string{"picture1", "picture2", "picture3", "picture4", "picture5"} {
+		createPicture(t, stub, louvreUser, name, "blue")
+	}
+	checkInvoke(t, stub, louvreUser, "retirePicture", "picture2", statusLost, "Lost in transit")
+	checkInvoke(t, stub, louvreUser, "retirePicture", "picture4", statusRestituted, "Returned to the heirs of the collector")
+
+	checkInvokeError(t, stub, louvreUser, "4th argument must be exclude, include or only: all", "getPicturesByGenerationWithPagination", "blue", "2", "", "all")
+
+	paged := &pagedStub{MockStub: stub}
+	readAll := func(pageSize string, args ...string) string {
+		t.Helper()
+		names := []string{}
+		bookmark := ""
+		for i := 0; i < 10; i++ {
+			result, page := readPage(t, paged, (*SimpleChaincode).getPicturesByGenerationWithPagination, append([]string{"blue", pageSize, bookmark}, args...)...)
+			names = append(names, page...)
+			bookmark = result.Bookmark
+			if bookmark == "" {
+				return strings.Join(names, ",")
+			}
+		}
+		t.Fatal("Too many pages")
+		return ""
+	}
+
+	tests := []struct {
+		retired  []string
+		pageSize string
+		expected string
+	}{
+		{nil, "2", "picture1,picture3,picture5"},
+		{[]string{"exclude"}, "10", "picture1,picture3,picture5"},
+		{[]string{"only"}, "1", "picture2,picture4"},
+		{[]string{"include"}, "2", "picture1,picture3,picture5,picture2,picture4"},
+		{[]string{"include"}, "3", "picture1,picture3,picture5,picture2,picture4"},
+		{[]string{"include"}, "10", "picture1,picture3,picture5,picture2,picture4"},
+	}
+	for _, test := range tests {
+		if names := readAll(test.pageSize, test.retired...); names != test.expected {
+			t.Fatalf("Found %q with %v and page size %s, expected %q", names, test.retired, test.pageSize, test.expected)
+		}
+	}
+}
+
+// retireTestPicture brings a new picture to a status through retirePicture, or reportStolen
+func retireTestPicture(t *testing.T, stub *shim.MockStub, name string, status string) {
+	t.Helper()
+	createPicture(t, stub, louvreUser, name, "blue")
+	if status == statusStolen {
+		checkInvoke(t, stub, louvreUser, "reportStolen", name, "OCBC-2018-0412")
+	} else if status != statusAvailable {
+		checkInvoke(t, stub, louvreUser, "retirePicture", name, status, "Retired for the test")
+	}
+}
+
+// statusChangeArgs are the arguments of the function taking picture1 from a status to another
+func statusChangeArgs(from, to string) []string {
+	switch {
+	case to == statusStolen:
+		return []string{"reportStolen", "picture1", "ALR-2018-90"}
+	case to == statusAvailable && from == statusStolen:
+		return []string{"clearStolenFlag", "picture1", "Found in the reserve"}
+	case to == statusAvailable:
+		return []string{"recoverPicture", "picture1", "Found in the reserve"}
+	}
+	return []string{"retirePicture", "picture1", to, "Changed for the test"}
+}
+
+func TestStatusTransitions(t *testing.T) {
+	// every allowed transition, recoveries included
+	for from, statuses := range statusTransitions {
+		for to := range statuses {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				stub := newTestStub()
+				retireTestPicture(t, stub, "picture1", from)
+				// only a registry clears the stolen flag
+				caller := louvreAdmin
+				if from == statusStolen && to == statusAvailable {
+					caller = registryUser
+				}
+				checkInvoke(t, stub, caller, statusChangeArgs(from, to)...)
+				pic := checkPicture(t, stub, "picture1")
+				if pic.Status != to {
+					t.Fatalf("Unexpected status %s", pic.Status)
+				}
+				// the picture is in the index matching its status
+				index := retiredGenerationIndex
+				if to == statusAvailable {
+					index = generationIndex
+				}
+				if stub.State[compositeKey(t, stub, index, "blue", "picture1")] == nil || stub.State[compositeKey(t, stub, statusIndex, to, "picture1")] == nil {
+					t.Fatalf("Picture not indexed as %s", to)
+				}
+				// the stolen flag is active exactly while the picture is stolen
+				if flag, _ := getActiveStolenFlag(stub, "picture1"); (flag != nil) != (to == statusStolen) {
+					t.Fatalf("Unexpected stolen flag %+v", flag)
+				}
+			})
+		}
+	}
+
+	tests := []struct {
+		from    string
+		to      string
+		message string
+	}{
+		{statusLost, statusRestituted, "Picture picture1 cannot go from lost to restituted"},
+		{statusLost, statusDeaccessioned, "Picture picture1 cannot go from lost to deaccessioned"},
+		{statusStolen, statusLost, "Picture picture1 cannot go from stolen to lost"},
+		{statusStolen, statusStolen, "Picture picture1 is already reported stolen, reference OCBC-2018-0412"},
+		{statusDeaccessioned, statusStolen, "Picture picture1 is not available, its status is deaccessioned"},
+		{statusDestroyed, statusAvailable, "Picture picture1 is not lost or stolen, its status is destroyed"},
+		{statusRestituted, statusStolen, "Picture picture1 is not available, its status is restituted"},
+		{statusDeaccessioned, statusAvailable, "Picture picture1 is not lost or stolen, its status is deaccessioned"},
+		{statusAvailable, statusAvailable, "Picture picture1 is not lost or stolen, its status is available"},
+	}
+	for _, test := range tests {
+		t.Run("not "+test.from+" to "+test.to, func(t *testing.T) {
+			stub := newTestStub()
+			retireTestPicture(t, stub, "picture1", test.from)
+			e := checkInvokeError(t, stub, louvreUser, test.message, statusChangeArgs(test.from, test.to)...)
+			if e.Code != codeConflict {
+				t.Fatalf("Unexpected error %+v", e)
+			}
+			if pic := checkPicture(t, stub, "picture1"); pic.Status != test.from {
+				t.Fatalf("Unexpected status %s", pic.Status)
+			}
+		})
+	}
+}
+
+func TestRecoverPicture(t *testing.T) {
+	stub := newTestStub()
+	retireTestPicture(t, stub, "picture1", statusLost)
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 2", "recoverPicture", "picture1")
+	checkInvokeError(t, stub, louvreUser, "Argument 2 (reason) must be a non-empty string", "recoverPicture", "picture1", " ")
+	checkInvokeError(t, stub, louvreUser, "Picture does not exist: picture2", "recoverPicture", "picture2", "Found")
+	checkInvokeError(t, stub, guggenheimAdmin, "Not allowed to manage picture picture1", "recoverPicture", "picture1", "Found")
+
+	checkInvoke(t, stub, louvreUser, "recoverPicture", "picture1", " Found by the police in a private collection ")
+	if event := lastEvent(t, stub); event.Type != eventPictureRecovered || event.Pictures[0] != "picture1" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Status != statusAvailable || pic.RetirementReason != "" || pic.RetiredAt != "" || pic.RecoveryReason != "Found by the police in a private collection" || pic.RecoveredAt == "" {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if stub.State[compositeKey(t, stub, retiredGenerationIndex, "blue", "picture1")] != nil {
+		t.Fatal("The retired~generation~name index entry was not removed")
+	}
+
+	// the recovered picture may be traded again
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+
+	// a picture reported stolen is recovered by clearing its flag, which the reporter may refuse
+	retireTestPicture(t, stub, "picture2", statusStolen)
+	checkInvokeError(t, stub, louvreUser, "Picture picture2 is reported stolen, reference OCBC-2018-0412, use clearStolenFlag", "recoverPicture", "picture2", "Found")
+	// pictures retired as stolen before the registry have no flag
+	createPicture(t, stub, louvreUser, "picture3", "blue")
+	setPictureStatus(t, stub, "picture3", statusStolen)
+	checkInvoke(t, stub, louvreUser, "recoverPicture", "picture3", "Found")
+}
+
+func TestStolenStatus(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+
+	// the report retires the picture
+	checkInvoke(t, stub, louvreUser, "reportStolen", "picture1", "OCBC-2018-0412", "Taken from the reserve")
+	pic := checkPicture(t, stub, "picture1")
+	if pic.Status != statusStolen || pic.RetirementReason != "Reported stolen, reference OCBC-2018-0412: Taken from the reserve" || pic.RetiredAt == "" {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+	if stub.State[compositeKey(t, stub, transferOfferPrefix, "picture1")] != nil {
+		t.Fatal("The pending transfer offer was not deleted")
+	}
+
+	// clearing the flag brings it back
+	checkInvoke(t, stub, registryUser, "clearStolenFlag", "picture1", "Found in another room")
+	pic = checkPicture(t, stub, "picture1")
+	if pic.Status != statusAvailable || pic.RetirementReason != "" || pic.RecoveryReason != "Found in another room" || pic.RecoveredAt == "" {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+
+	// restituting a stolen picture ends the search
+	checkInvoke(t, stub, louvreUser, "reportStolen", "picture1", "OCBC-2018-0413")
+	checkInvoke(t, stub, louvreAdmin, "retirePicture", "picture1", statusRestituted, "Returned to the heirs of the collector")
+	flag, err := getStolenFlag(stub, "picture1")
+	if err != nil || flag.Active || flag.ClearedBy == nil || *flag.ClearedBy != louvreAdmin.id || flag.ClearReason != "Returned to the heirs of the collector" {
+		t.Fatalf("Unexpected stolen flag %+v (%v)", flag, err)
+	}
+	if pic := checkPicture(t, stub, "picture1"); pic.Status != statusRestituted {
+		t.Fatalf("Unexpected status %s", pic.Status)
+	}
+}
+
+func TestProvenanceOfRetirement(t *testing.T) {
+	stub := &historyStub{MockStub: newTestStub(), history: map[string][]*queryresult.KeyModification{}}
+	start := time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC)
+	step := func(caller testClient, args ...string) {
+		t.Helper()
+		checkInvoke(t, stub.MockStub, caller, args...)
+		stub.record("picture1", start.AddDate(0, 0, len(stub.history["picture1"])))
+	}
+
+	createArtist(t, stub.MockStub, "claude-monet", "Claude Monet")
+	step(louvreUser, "initPicture", "picture1", "blue", "35", "claude-monet", "Water Lilies", "1906", "Oil on canvas", "89.9 x 94.1 cm", "RF 1963-5")
+	step(louvreUser, "retirePicture", "picture1", statusLost, "Missing after the move")
+	step(louvreUser, "reportStolen", "picture1", "OCBC-2018-0412", "Seen at a private sale")
+	step(registryUser, "clearStolenFlag", "picture1", "Seized by the police")
+	step(louvreUser, "reportStolen", "picture1", "OCBC-2018-0413")
+	step(louvreUser, "retirePicture", "picture1", statusRestituted, "Returned to the heirs of the collector")
+
+	response := new(SimpleChaincode).getProvenance(stub, []string{"picture1"})
+	if response.Status != shim.OK {
+		t.Fatal("getProvenance failed:", response.Message)
+	}
+	entries := []provenanceEntry{}
+	err := json.Unmarshal(response.Payload, &entries)
+	if err != nil {
+		t.Fatalf("Failed to decode provenance %s: %s", response.Payload, err)
+	}
+	expected := []struct {
+		event  string
+		status string
+		reason string
+	}{
+		{provenanceCreated, "", ""},
+		{provenanceRetired, statusLost, "Missing after the move"},
+		{provenanceRetired, statusStolen, "Reported stolen, reference OCBC-2018-0412: Seen at a private sale"},
+		{provenanceRecovered, "", "Seized by the police"},
+		{provenanceRetired, statusStolen, "Reported stolen, reference OCBC-2018-0413"},
+		{provenanceRetired, statusRestituted, "Returned to the heirs of the collector"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Unexpected provenance %s", response.Payload)
+	}
+	for i, e := range expected {
+		if entries[i].Event != e.event || entries[i].Status != e.status || entries[i].Reason != e.reason {
+			t.Fatalf("Unexpected entry %d: %+v", i, entries[i])
+		}
+	}
+}
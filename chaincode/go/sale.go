@@ -0,0 +1,271 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Sales ====
+// A sale is a transfer offer carrying an agreed price. Ownership only moves when the buyer
+// accepts the offer, and the settlement is then recorded as a sale document under a
+// composite key built from salePrefix, the picture name and the settling transaction ID.
+// A party~sale index entry is written for both the seller and the buyer so sales can be
+// listed per owner without a rich query. Prices are private data of the seller and the
+// buyer, offers and sales only carry their hash, see private.go.
+
+const salePrefix = "sale"
+const partySaleIndex = "party~sale"
+
+// amounts are decimal strings with at most two decimals, so no precision is lost to floats,
+// the grammar amountInCents relies on. 15 digits keep the amount in cents within an int64.
+var amountPattern = regexp.MustCompile(`^[0-9]{1,15}(\.[0-9]{1,2})?$`)
+
+// currencies are ISO 4217 alphabetic codes, e.g. EUR or USD
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type sale struct {
+	ObjectType string   `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	TxID       string   `json:"txId"`    //transaction that moved the ownership
+	Picture    string   `json:"picture"`
+	Seller     identity `json:"seller"`
+	Buyer      identity `json:"buyer"`
+	PriceHash  string   `json:"priceHash"` //hash of the private price, see readSalePrice
+	SettledAt  string   `json:"settledAt"` //RFC3339 transaction timestamp
+}
+
+// validatePrice checks an amount and currency pair as accepted by sellPicture. The amount is
+// only matched against amountPattern and compared in cents, never parsed as a float.
+func validatePrice(price, currency string) error {
+	if !amountPattern.MatchString(price) {
+		return newError(codeInvalidArgument, "", "Price must be a decimal amount with at most two decimals: %s", price)
+	}
+	if amountInCents(price) <= 0 {
+		return newError(codeInvalidArgument, "", "Price must be greater than zero: %s", price)
+	}
+	if !currencyPattern.MatchString(currency) {
+		return newError(codeInvalidArgument, "", "Currency must be an ISO 4217 code such as EUR: %s", currency)
+	}
+	return nil
+}
+
+// ===========================================================================================
+// recordSale writes the settlement record of a sale made in the current transaction, its
+// price to the collections of the seller and the buyer, and the party~sale index entries
+// ===========================================================================================
+func recordSale(stub shim.ChaincodeStubInterface, pictureName string, seller, buyer identity, price, currency, salt string) (*sale, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sale{
+		ObjectType: "sale",
+		TxID:       stub.GetTxID(),
+		Picture:    pictureName,
+		Seller:     seller,
+		Buyer:      buyer,
+		SettledAt:  now.Format(time.RFC3339),
+	}
+	salePriceKey, err := stub.CreateCompositeKey(salePricePrefix, []string{s.Picture, s.TxID})
+	if err != nil {
+		return nil, err
+	}
+	s.PriceHash, err = putPrivate(stub, []string{seller.MSPID, buyer.MSPID}, salePriceKey, &privatePrice{ObjectType: "salePrice", Picture: pictureName, Price: price, Currency: currency, Salt: salt})
+	if err != nil {
+		return nil, err
+	}
+
+	saleJSONasBytes, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	saleKey, err := stub.CreateCompositeKey(salePrefix, []string{s.Picture, s.TxID})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(saleKey, saleJSONasBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	//  Save index entries to state. Only the key name is needed, no need to store a duplicate copy of the sale.
+	value := []byte{0x00}
+	for _, party := range []identity{seller, buyer} {
+		partySaleIndexKey, err := stub.CreateCompositeKey(partySaleIndex, []string{party.MSPID, party.Subject, s.Picture, s.TxID})
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(partySaleIndexKey, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ===========================================================================================
+// sellPicture - the owner of a picture offers it to a buyer at an agreed price. The offer
+// follows the two-phase transfer rules and is settled when the buyer accepts it. The price
+// is given in the price transient field, so it stays out of the transaction.
+// ===========================================================================================
+func (t *SimpleChaincode) sellPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0            1                2                     3
+	// "name", "GuggenheimMSP", "CN=User1@guggenheim...", "14" (optional validity in days)
+	// with the transient field price: {"price":"1500000.00","currency":"EUR","salt":"..."}
+	price := &privatePrice{}
+	ok, err := getTransientJSON(stub, transientPrice, price)
+	if err != nil {
+		return errorResponse(err)
+	} else if !ok {
+		return errorResponse(newError(codeInvalidArgument, transientPrice, "The price must be given in the price transient field"))
+	}
+	err = validatePrice(price.Price, price.Currency)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkSalt(transientPrice, price.Salt)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pictureName, buyer, validityDays, err := parseOfferArgs(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	fmt.Println("- start sellPicture ", pictureName, buyer)
+
+	offer, err := putNewTransferOffer(stub, pictureName, buyer, validityDays, price)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventSaleOffered, []string{pictureName}, offer)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end sellPicture (success)")
+	return shim.Success(nil)
+}
+
+// getSale reads the sale of a picture settled by a transaction
+func getSale(stub shim.ChaincodeStubInterface, pictureName, txID string) (*sale, error) {
+	saleKey, err := stub.CreateCompositeKey(salePrefix, []string{pictureName, txID})
+	if err != nil {
+		return nil, err
+	}
+	saleAsBytes, err := stub.GetState(saleKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get sale: %s", err)
+	} else if saleAsBytes == nil {
+		return nil, newError(codeNotFound, "saleTxId", "No sale of picture %s in transaction %s", pictureName, txID)
+	}
+
+	s := &sale{}
+	err = json.Unmarshal(saleAsBytes, s)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode sale: %s", txID)
+	}
+	return s, nil
+}
+
+// ===========================================================================================
+// getSalesForPicture - list the settled sales of a picture, ordered by transaction ID
+// ===========================================================================================
+func (t *SimpleChaincode) getSalesForPicture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(salePrefix, []string{args[0]})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	sales := []sale{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		s := sale{}
+		err = json.Unmarshal(responseRange.Value, &s)
+		if err != nil {
+			return errorResponse(err)
+		}
+		sales = append(sales, s)
+	}
+
+	salesJSONasBytes, err := json.Marshal(sales)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(salesJSONasBytes)
+}
+
+// ===========================================================================================
+// getSalesByOwner - list the sales an organisation, or one of its members, took part in
+// as seller or buyer, once each. Uses the party~sale index, so it works on LevelDB as well as CouchDB.
+// ===========================================================================================
+func (t *SimpleChaincode) getSalesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1
+	// "LouvreMSP", "CN=User1@louvre..." (optional)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(partySaleIndex, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	// a sale between two members of the organisation has an entry for each of them
+	sales := []sale{}
+	seen := map[string]bool{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		// get the picture and transaction from the party~sale composite key
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		saleKey, err := stub.CreateCompositeKey(salePrefix, compositeKeyParts[2:])
+		if err != nil {
+			return errorResponse(err)
+		}
+		if seen[saleKey] {
+			continue
+		}
+		seen[saleKey] = true
+		saleAsBytes, err := stub.GetState(saleKey)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Failed to get sale: %s", err))
+		} else if saleAsBytes == nil {
+			continue
+		}
+
+		s := sale{}
+		err = json.Unmarshal(saleAsBytes, &s)
+		if err != nil {
+			return errorResponse(err)
+		}
+		sales = append(sales, s)
+	}
+
+	salesJSONasBytes, err := json.Marshal(sales)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(salesJSONasBytes)
+}
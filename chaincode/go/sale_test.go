@@ -0,0 +1,175 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func readSales(t *testing.T, stub *shim.MockStub, args ...string) []sale {
+	t.Helper()
+	sales := []sale{}
+	err := json.Unmarshal(checkInvoke(t, stub, louvreUser, args...), &sales)
+	if err != nil {
+		t.Fatal("Failed to decode sales:", err)
+	}
+	return sales
+}
+
+// testSalt is a salt long enough for the transient fields that need one
+const testSalt = "5f0b7c1e9a3d2846"
+
+// priceTransient is the transient map of a sellPicture call
+func priceTransient(price, currency string) map[string]string {
+	return map[string]string{transientPrice: fmt.Sprintf(`{"price":%q,"currency":%q,"salt":%q}`, price, currency, testSalt)}
+}
+
+// readTestSalePrice reads the price of a sale as one of its parties
+func readTestSalePrice(t *testing.T, stub *shim.MockStub, caller testClient, s sale) privatePrice {
+	t.Helper()
+	priceAsBytes := checkInvoke(t, stub, caller, "readSalePrice", s.Picture, s.TxID)
+	if hash := sha256.Sum256(priceAsBytes); hex.EncodeToString(hash[:]) != s.PriceHash {
+		t.Fatalf("Price %s does not match the hash of sale %s", priceAsBytes, s.TxID)
+	}
+	price := privatePrice{}
+	err := json.Unmarshal(priceAsBytes, &price)
+	if err != nil {
+		t.Fatal("Failed to decode price:", err)
+	}
+	return price
+}
+
+func TestSellPicture(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	buyer := guggenheimUser.id
+	validArgs := []string{"picture1", buyer.MSPID, buyer.Subject}
+
+	tests := []struct {
+		name      string
+		args      []string
+		transient map[string]string
+		message   string
+	}{
+		{"too few arguments", []string{"picture1", buyer.MSPID}, priceTransient("1500000.00", "EUR"), "Incorrect number of arguments. Expecting 3 or 4"},
+		{"missing price", validArgs, nil, "The price must be given in the price transient field"},
+		{"unknown price field", validArgs, map[string]string{transientPrice: `{"amount":"1500000.00","currency":"EUR"}`}, "Invalid transient field price"},
+		{"missing salt", validArgs, map[string]string{transientPrice: `{"price":"1500000.00","currency":"EUR"}`}, "The price transient field needs a random salt of at least 16 characters"},
+		{"short salt", validArgs, map[string]string{transientPrice: `{"price":"1500000.00","currency":"EUR","salt":"42"}`}, "The price transient field needs a random salt of at least 16 characters"},
+		{"float price", validArgs, priceTransient("1.5e6", "EUR"), "Price must be a decimal amount"},
+		{"three decimals", validArgs, priceTransient("1500000.005", "EUR"), "Price must be a decimal amount with at most two decimals"},
+		{"no integer part", validArgs, priceTransient(".50", "EUR"), "Price must be a decimal amount with at most two decimals"},
+		{"negative price", validArgs, priceTransient("-100.00", "EUR"), "Price must be a decimal amount with at most two decimals"},
+		{"zero price", validArgs, priceTransient("0.00", "EUR"), "Price must be greater than zero"},
+		{"lowercase currency", validArgs, priceTransient("1500000.00", "eur"), "Currency must be an ISO 4217 code"},
+		{"invalid validity", append(validArgs, "-1"), priceTransient("1500000.00", "EUR"), "4th argument must be a positive number of days"},
+		{"unknown buyer organisation", []string{"picture1", "OutsiderMSP", outsiderUser.id.Subject}, priceTransient("1500000.00", "EUR"), "Unknown organisation for the new owner"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkTransientInvokeError(t, stub, louvreUser, test.transient, test.message, append([]string{"sellPicture"}, test.args...)...)
+		})
+	}
+
+	checkTransientInvoke(t, stub, louvreUser, priceTransient("1500000.00", "EUR"), "sellPicture", "picture1", buyer.MSPID, buyer.Subject, "7")
+	if event := lastEvent(t, stub); event.Type != eventSaleOffered {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	// the price is in the collections of both parties, world state only has its hash
+	offer := readOffer(t, stub, "picture1")
+	offerPriceKey := compositeKey(t, stub, offerPricePrefix, "picture1")
+	for _, mspID := range []string{"LouvreMSP", "GuggenheimMSP"} {
+		priceAsBytes := stub.PvtState[privateCollection(mspID)][offerPriceKey]
+		if hash := sha256.Sum256(priceAsBytes); priceAsBytes == nil || hex.EncodeToString(hash[:]) != offer.PriceHash {
+			t.Fatalf("Unexpected offer price %s in the collection of %s", priceAsBytes, mspID)
+		}
+	}
+	for key, value := range stub.State {
+		if strings.Contains(string(value), "1500000.00") {
+			t.Fatalf("The price is public under %q", key)
+		}
+	}
+	if sales := readSales(t, stub, "getSalesForPicture", "picture1"); len(sales) != 0 {
+		t.Fatalf("Sale recorded before the offer was accepted: %+v", sales)
+	}
+
+	checkInvoke(t, stub, guggenheimUser, "acceptTransfer", "picture1")
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != buyer {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+	if event := lastEvent(t, stub); event.Type != eventPictureSold {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	for _, mspID := range []string{"LouvreMSP", "GuggenheimMSP"} {
+		if stub.PvtState[privateCollection(mspID)][offerPriceKey] != nil {
+			t.Fatalf("The offer price was left in the collection of %s", mspID)
+		}
+	}
+
+	sales := readSales(t, stub, "getSalesForPicture", "picture1")
+	if len(sales) != 1 || sales[0].Seller != louvreUser.id || sales[0].Buyer != buyer || sales[0].PriceHash == "" {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	for _, caller := range []testClient{louvreAdmin, guggenheimUser} {
+		if price := readTestSalePrice(t, stub, caller, sales[0]); price.Price != "1500000.00" || price.Currency != "EUR" || price.Picture != "picture1" || price.Salt != testSalt {
+			t.Fatalf("Unexpected price %+v", price)
+		}
+	}
+	checkInvokeError(t, stub, louvreUser, "No sale of picture picture1 in transaction missing", "readSalePrice", "picture1", "missing")
+}
+
+func TestGetSales(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "blue")
+	checkTransientInvoke(t, stub, louvreUser, priceTransient("100.00", "EUR"), "sellPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvoke(t, stub, guggenheimUser, "acceptTransfer", "picture1")
+	checkTransientInvoke(t, stub, guggenheimUser, priceTransient("250", "USD"), "sellPicture", "picture1", louvreAdmin.id.MSPID, louvreAdmin.id.Subject)
+	checkInvoke(t, stub, louvreAdmin, "acceptTransfer", "picture1")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "getSalesForPicture")
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1 or 2", "getSalesByOwner")
+
+	if sales := readSales(t, stub, "getSalesForPicture", "picture1"); len(sales) != 2 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	if sales := readSales(t, stub, "getSalesForPicture", "picture2"); len(sales) != 0 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	// both sales involve a Louvre member, only the first one the Louvre user
+	if sales := readSales(t, stub, "getSalesByOwner", "LouvreMSP"); len(sales) != 2 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	sales := readSales(t, stub, "getSalesByOwner", "LouvreMSP", louvreUser.id.Subject)
+	if len(sales) != 1 || readTestSalePrice(t, stub, louvreUser, sales[0]).Price != "100.00" {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	if sales := readSales(t, stub, "getSalesByOwner", "OutsiderMSP"); len(sales) != 0 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+
+	// a sale within an organisation is hidden from the others
+	checkTransientInvoke(t, stub, louvreUser, priceTransient("80.00", "EUR"), "sellPicture", "picture2", louvreAdmin.id.MSPID, louvreAdmin.id.Subject)
+	checkInvoke(t, stub, louvreAdmin, "acceptTransfer", "picture2")
+	sales = readSales(t, stub, "getSalesForPicture", "picture2")
+	if len(sales) != 1 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	// listed once for the organisation of both parties
+	if sales := readSales(t, stub, "getSalesByOwner", "LouvreMSP"); len(sales) != 3 {
+		t.Fatalf("Unexpected sales %+v", sales)
+	}
+	e := checkInvokeError(t, stub, guggenheimUser, "The price of sale "+sales[0].TxID+" is reserved to LouvreMSP and LouvreMSP", "readSalePrice", "picture2", sales[0].TxID)
+	if e.Code != codeForbidden {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+}
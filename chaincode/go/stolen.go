@@ -0,0 +1,413 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Stolen art registry ====
+// The owner of a picture, or a registry organisation of stolenRegistryMSPs, may flag it as
+// stolen or looted. The flag is stored under a composite key built from stolenFlagPrefix and
+// the picture name, apart from the picture, because the work is usually still recorded under
+// its owner of record. Only a registry clears a flag, whoever raised it, so an owner cannot
+// flag its picture and lift the flag at will. A cleared flag is kept with the reason.
+//
+// The flag is the record of the theft and the status of the picture follows it: reporting a
+// picture stolen retires it with statusStolen, see retire.go, and clearing the flag makes it
+// available again. A picture held by an auction or a loan keeps its status until released,
+// see releasePicture. retirePicture does not set statusStolen itself.
+//
+// A flagged picture cannot be offered, sold, auctioned or transferred, which all fail with
+// CONFLICT. The errors of transferPicture and transferPicturesBasedOnGeneration carry the alert
+// as details: who attempted the transfer, to whom, and the flags it ran into. Peers discard the
+// events and writes of a failed proposal, so the client refused then submits
+// reportTransferAttempt for each flagged picture. It records the attempt under the flag and
+// emits the StolenTransferAttempted event with the alert.
+
+const stolenFlagPrefix = "stolen"
+
+type stolenFlag struct {
+	ObjectType  string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Picture     string    `json:"picture"`
+	Reference   string    `json:"reference"`             //police or registry case number
+	Description string    `json:"description,omitempty"` //circumstances of the theft or looting
+	ReportedBy  identity  `json:"reportedBy"`
+	ReportedAt  string    `json:"reportedAt"` //RFC3339 transaction timestamp
+	Active      bool      `json:"active"`
+	ClearedBy   *identity `json:"clearedBy,omitempty"`
+	ClearedAt   string    `json:"clearedAt,omitempty"`
+	ClearReason string    `json:"clearReason,omitempty"`
+
+	TransferAttempts []transferAttempt `json:"transferAttempts,omitempty"` //refused transfers, see reportTransferAttempt
+}
+
+// transferAttempt is a refused transfer of a flagged picture, as reported by the client refused
+type transferAttempt struct {
+	By          identity `json:"by"`
+	To          identity `json:"to"`
+	AttemptedAt string   `json:"attemptedAt"` //RFC3339 transaction timestamp of the report
+}
+
+// stolenTransferAlert is the details of the error refusing a transfer of stolen pictures, and
+// of the StolenTransferAttempted event
+type stolenTransferAlert struct {
+	From  identity     `json:"from"` //owner of record of the first flagged picture
+	To    identity     `json:"to"`
+	Flags []stolenFlag `json:"flags"`
+}
+
+// titleCheck is the answer of checkTitle
+type titleCheck struct {
+	Picture    string      `json:"picture"`
+	Owner      identity    `json:"owner"`
+	Status     string      `json:"status"`
+	Stolen     bool        `json:"stolen"`         //an active stolen flag exists
+	Flag       *stolenFlag `json:"flag,omitempty"` //the active flag, or the last cleared one
+	ClearTitle bool        `json:"clearTitle"`     //neither flagged nor retired, the picture may be traded
+}
+
+// ===========================================================================================
+// getStolenFlag reads the stolen flag of a picture, active or cleared, returning nil if the
+// picture was never reported
+// ===========================================================================================
+func getStolenFlag(stub shim.ChaincodeStubInterface, pictureName string) (*stolenFlag, error) {
+	flagKey, err := stub.CreateCompositeKey(stolenFlagPrefix, []string{pictureName})
+	if err != nil {
+		return nil, err
+	}
+	flagAsBytes, err := stub.GetState(flagKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get stolen flag: %s", err)
+	} else if flagAsBytes == nil {
+		return nil, nil
+	}
+
+	flag := &stolenFlag{}
+	err = json.Unmarshal(flagAsBytes, flag)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode stolen flag for: %s", pictureName)
+	}
+	return flag, nil
+}
+
+func putStolenFlag(stub shim.ChaincodeStubInterface, flag *stolenFlag) error {
+	flagKey, err := stub.CreateCompositeKey(stolenFlagPrefix, []string{flag.Picture})
+	if err != nil {
+		return err
+	}
+	flagJSONasBytes, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(flagKey, flagJSONasBytes)
+}
+
+// getActiveStolenFlag returns the active stolen flag of a picture, or nil
+func getActiveStolenFlag(stub shim.ChaincodeStubInterface, pictureName string) (*stolenFlag, error) {
+	flag, err := getStolenFlag(stub, pictureName)
+	if err != nil || flag == nil || !flag.Active {
+		return nil, err
+	}
+	return flag, nil
+}
+
+// checkNotStolen verifies a picture has no active stolen flag before it is traded
+func checkNotStolen(stub shim.ChaincodeStubInterface, pic *picture) error {
+	flag, err := getActiveStolenFlag(stub, pic.Name)
+	if err != nil {
+		return err
+	} else if flag != nil {
+		return newError(codeConflict, "", "Picture %s is reported stolen, reference %s", pic.Name, flag.Reference)
+	}
+	return nil
+}
+
+// markStolen gives a flagged picture the stolen status, with the report as its retirement
+func markStolen(pic *picture, flag *stolenFlag) {
+	pic.Status = statusStolen
+	pic.RetirementReason = "Reported stolen, reference " + flag.Reference
+	if flag.Description != "" {
+		pic.RetirementReason += ": " + flag.Description
+	}
+	pic.RetiredAt = flag.ReportedAt
+}
+
+// releasePicture ends the hold of an auction or a loan on a picture, which becomes available
+// again or stolen if it was reported stolen in the meantime. It returns the active flag, if
+// any; the caller stores the picture.
+func releasePicture(stub shim.ChaincodeStubInterface, pic *picture) (*stolenFlag, error) {
+	flag, err := getActiveStolenFlag(stub, pic.Name)
+	if err != nil {
+		return nil, err
+	}
+	if flag != nil {
+		markStolen(pic, flag)
+	} else {
+		pic.Status = statusAvailable
+	}
+	return flag, nil
+}
+
+// endStolenFlag clears an active flag, for clearStolenFlag or a picture retired for good
+func endStolenFlag(stub shim.ChaincodeStubInterface, flag *stolenFlag, by identity, reason string, now time.Time) error {
+	flag.Active = false
+	flag.ClearedBy = &by
+	flag.ClearedAt = now.Format(time.RFC3339)
+	flag.ClearReason = reason
+	return putStolenFlag(stub, flag)
+}
+
+// ===========================================================================================
+// blockStolenTransfer refuses a transfer of flagged pictures with a CONFLICT error carrying
+// the alert
+// ===========================================================================================
+func blockStolenTransfer(from, to identity, flags []stolenFlag) pb.Response {
+	pictures := []string{}
+	references := []string{}
+	for _, flag := range flags {
+		pictures = append(pictures, flag.Picture)
+		references = append(references, fmt.Sprintf("%s (reference %s)", flag.Picture, flag.Reference))
+	}
+	fmt.Println("- transfer blocked, pictures reported stolen: " + strings.Join(pictures, ", "))
+
+	return errorResponse(&chaincodeError{
+		Code:    codeConflict,
+		Message: fmt.Sprintf("Transfer to %s refused, reported stolen: %s", to, strings.Join(references, ", ")),
+		Details: stolenTransferAlert{From: from, To: to, Flags: flags},
+	})
+}
+
+// ===========================================================================================
+// reportTransferAttempt - the client refused a transfer of a flagged picture records the
+// attempt under the flag and raises the alert, which the refused proposal could not
+// ===========================================================================================
+func (t *SimpleChaincode) reportTransferAttempt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0            1                2
+	// "name", "GuggenheimMSP", "CN=User1@guggenheim..."
+	pictureName := args[0]
+	to := identity{MSPID: args[1], Subject: args[2]}
+	if !galleryMSPs[to.MSPID] {
+		return errorResponse(newError(codeInvalidArgument, "newOwnerMspId", "Unknown organisation for the new owner: %s", to.MSPID))
+	}
+	fmt.Println("- start reportTransferAttempt ", pictureName, to)
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// only those allowed to transfer the picture can have been refused
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	flag, err := getActiveStolenFlag(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if flag == nil {
+		return errorResponse(newError(codeNotFound, "name", "Picture %s is not reported stolen", pictureName))
+	}
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	flag.TransferAttempts = append(flag.TransferAttempts, transferAttempt{By: caller, To: to, AttemptedAt: now.Format(time.RFC3339)})
+	err = putStolenFlag(stub, flag)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventStolenTransferAttempted, []string{pictureName}, stolenTransferAlert{From: pic.Owner, To: to, Flags: []stolenFlag{*flag}})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end reportTransferAttempt (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// reportStolen - a member of the owning organisation or of a registry flags a picture as
+// stolen or looted, which retires it as stolen
+// ===========================================================================================
+func (t *SimpleChaincode) reportStolen(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0            1                       2
+	// "name", "OCBC-2018-0412", "Taken from the reserve during the move" (optional)
+	pictureName := args[0]
+	reference := strings.TrimSpace(args[1])
+	description := ""
+	if len(args) == 3 {
+		description = strings.TrimSpace(args[2])
+	}
+	fmt.Println("- start reportStolen ", pictureName, reference)
+
+	reporter, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if reporter.MSPID != pic.Owner.MSPID && !stolenRegistryMSPs[reporter.MSPID] {
+		return errorResponse(newError(codeForbidden, "", "Only members of the owner or of a stolen art registry may report picture %s stolen", pictureName))
+	}
+	flag, err := getActiveStolenFlag(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if flag != nil {
+		return errorResponse(newError(codeConflict, "name", "Picture %s is already reported stolen, reference %s", pictureName, flag.Reference))
+	}
+	// an auction or a loan gives it the stolen status once it ends, see releasePicture
+	held := pic.Status == statusAtAuction || pic.Status == statusOnLoan
+	if !held {
+		err = checkStatusTransition(pic, statusStolen)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// a new report replaces a cleared one, which stays in the history of the key
+	flag = &stolenFlag{
+		ObjectType:  "stolenFlag",
+		Picture:     pictureName,
+		Reference:   reference,
+		Description: description,
+		ReportedBy:  reporter,
+		ReportedAt:  now.Format(time.RFC3339),
+		Active:      true,
+	}
+	err = putStolenFlag(stub, flag)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !held {
+		markStolen(pic, flag)
+		err = putPicture(stub, pic)
+		if err != nil {
+			return errorResponse(err)
+		}
+		// a pending offer cannot be accepted any more
+		err = deleteTransferOffer(stub, pictureName)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+		}
+	}
+
+	err = emitEvent(stub, eventPictureReportedStolen, []string{pictureName}, flag)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end reportStolen (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// clearStolenFlag - a registry member lifts the stolen flag of a picture, e.g. once it is
+// recovered or the claim is settled. A stolen picture is available again.
+// ===========================================================================================
+func (t *SimpleChaincode) clearStolenFlag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                1
+	// "name", "Recovered by the police, owner of record confirmed"
+	pictureName := args[0]
+	reason := strings.TrimSpace(args[1])
+	fmt.Println("- start clearStolenFlag ", pictureName)
+
+	flag, err := getActiveStolenFlag(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if flag == nil {
+		return errorResponse(newError(codeNotFound, "name", "Picture %s is not reported stolen", pictureName))
+	}
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// Invoke checks the caller is a registry member, see registry.go
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = endStolenFlag(stub, flag, caller, reason, now)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if pic.Status == statusStolen {
+		pic.Status = statusAvailable
+		pic.RetirementReason = ""
+		pic.RetiredAt = ""
+		pic.RecoveryReason = reason
+		pic.RecoveredAt = now.Format(time.RFC3339)
+		err = putPicture(stub, pic)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	err = emitEvent(stub, eventStolenFlagCleared, []string{pictureName}, flag)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end clearStolenFlag (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// checkTitle - due diligence before a purchase: who owns a picture, whether it is reported
+// stolen and whether it may be traded
+// ===========================================================================================
+func (t *SimpleChaincode) checkTitle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	pic, err := getPicture(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	flag, err := getStolenFlag(stub, pic.Name)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	check := titleCheck{
+		Picture: pic.Name,
+		Owner:   pic.Owner,
+		Status:  pic.Status,
+		Stolen:  flag != nil && flag.Active,
+		Flag:    flag,
+	}
+	check.ClearTitle = !check.Stolen && !retiredStatuses[pic.Status]
+
+	checkJSONasBytes, err := json.Marshal(check)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(checkJSONasBytes)
+}
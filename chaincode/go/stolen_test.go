@@ -0,0 +1,213 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var registryUser = newTestClient("ArtLossRegisterMSP", "User1@artlossregister.artgalleries.com", "client")
+
+func readTitle(t *testing.T, stub *shim.MockStub, name string) titleCheck {
+	t.Helper()
+	check := titleCheck{}
+	err := json.Unmarshal(checkInvoke(t, stub, outsiderUser, "checkTitle", name), &check)
+	if err != nil {
+		t.Fatal("Failed to decode title check:", err)
+	}
+	return check
+}
+
+// stolenAlert decodes the alert in the details of a refused transfer
+func stolenAlert(t *testing.T, e *chaincodeError) stolenTransferAlert {
+	t.Helper()
+	alert := stolenTransferAlert{}
+	detailsJSON, _ := json.Marshal(e.Details)
+	err := json.Unmarshal(detailsJSON, &alert)
+	if err != nil {
+		t.Fatal("Failed to decode alert:", err)
+	}
+	return alert
+}
+
+func TestStolenRegistry(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	if check := readTitle(t, stub, "picture1"); check.Stolen || !check.ClearTitle || check.Flag != nil || check.Owner != louvreUser.id {
+		t.Fatalf("Unexpected title check %+v", check)
+	}
+
+	checkInvokeError(t, stub, outsiderUser, "reportStolen is reserved to gallery and registry members, not OutsiderMSP", "reportStolen", "picture1", "OCBC-2018-0412")
+	checkInvokeError(t, stub, guggenheimUser, "Picture does not exist: picture2", "reportStolen", "picture2", "OCBC-2018-0412")
+	// a gallery cannot flag the pictures of another
+	e := checkInvokeError(t, stub, guggenheimUser, "Only members of the owner or of a stolen art registry may report picture picture1 stolen", "reportStolen", "picture1", "OCBC-2018-0412")
+	if e.Code != codeForbidden {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	checkInvoke(t, stub, louvreUser, "reportStolen", "picture1", "OCBC-2018-0412", "Seen at a private sale")
+	if event := lastEvent(t, stub); event.Type != eventPictureReportedStolen {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	checkInvokeError(t, stub, registryUser, "Picture picture1 is already reported stolen, reference OCBC-2018-0412", "reportStolen", "picture1", "ALR-1")
+
+	check := readTitle(t, stub, "picture1")
+	if !check.Stolen || check.ClearTitle || check.Flag == nil || check.Flag.ReportedBy != louvreUser.id || check.Flag.Description != "Seen at a private sale" {
+		t.Fatalf("Unexpected title check %+v", check)
+	}
+
+	// other galleries cannot clear a flag, registries can
+	checkInvokeError(t, stub, outsiderUser, "clearStolenFlag is reserved to stolen art registry members, not OutsiderMSP", "clearStolenFlag", "picture1", "Recovered")
+	e = checkInvokeError(t, stub, guggenheimAdmin, "clearStolenFlag is reserved to stolen art registry members, not GuggenheimMSP", "clearStolenFlag", "picture1", "Recovered")
+	if e.Code != codeForbidden {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	checkInvoke(t, stub, registryUser, "clearStolenFlag", "picture1", "Recovered, owner of record confirmed")
+	if event := lastEvent(t, stub); event.Type != eventStolenFlagCleared {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	checkInvokeError(t, stub, registryUser, "Picture picture1 is not reported stolen", "clearStolenFlag", "picture1", "Recovered")
+
+	check = readTitle(t, stub, "picture1")
+	if check.Stolen || !check.ClearTitle || check.Flag == nil || check.Flag.ClearedBy == nil || *check.Flag.ClearedBy != registryUser.id || check.Flag.ClearReason != "Recovered, owner of record confirmed" {
+		t.Fatalf("Unexpected title check %+v", check)
+	}
+	checkInvoke(t, stub, louvreUser, "transferPicture", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	if pic := checkPicture(t, stub, "picture1"); pic.Owner != guggenheimUser.id {
+		t.Fatalf("Unexpected owner %s", pic.Owner)
+	}
+
+	// a retired picture has no clear title either
+	checkInvoke(t, stub, guggenheimUser, "retirePicture", "picture1", statusDestroyed, "Fire in storage")
+	if check := readTitle(t, stub, "picture1"); check.ClearTitle {
+		t.Fatalf("Unexpected title check %+v", check)
+	}
+}
+
+func TestClearStolenFlag(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	// neither the reporter nor an admin of the owner clears a flag, whoever raised it
+	checkInvoke(t, stub, louvreUser, "reportStolen", "picture1", "OCBC-2018-0412")
+	for _, caller := range []testClient{louvreUser, louvreAdmin} {
+		e := checkInvokeError(t, stub, caller, "clearStolenFlag is reserved to stolen art registry members, not LouvreMSP", "clearStolenFlag", "picture1", "Found in the reserve")
+		if e.Code != codeForbidden {
+			t.Fatalf("Unexpected error %+v", e)
+		}
+	}
+	checkInvoke(t, stub, registryUser, "clearStolenFlag", "picture1", "Found in the reserve")
+
+	checkInvoke(t, stub, registryUser, "reportStolen", "picture1", "ALR-2018-80")
+	checkInvokeError(t, stub, louvreAdmin, "clearStolenFlag is reserved to stolen art registry members, not LouvreMSP", "clearStolenFlag", "picture1", "Found")
+	checkInvoke(t, stub, registryUser, "clearStolenFlag", "picture1", "Claim settled with the registry")
+	check := readTitle(t, stub, "picture1")
+	if check.Stolen || check.Flag.ReportedBy != registryUser.id || *check.Flag.ClearedBy != registryUser.id {
+		t.Fatalf("Unexpected title check %+v", check)
+	}
+}
+
+func TestStolenTransferBlocked(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "blue")
+	createPicture(t, stub, louvreUser, "picture3", "red")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture3", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvoke(t, stub, registryUser, "reportStolen", "picture2", "ALR-2018-77")
+	checkInvoke(t, stub, registryUser, "reportStolen", "picture3", "ALR-2018-78")
+
+	// the blocked transfer is refused, the alert travels in the error
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture2", "transferPicture", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	e := checkInvokeError(t, stub, louvreUser, "reported stolen: picture2 (reference ALR-2018-77)", "transferPicture", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	if e.Code != codeConflict {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	alert := stolenAlert(t, e)
+	if alert.From != louvreUser.id || alert.To != guggenheimUser.id || len(alert.Flags) != 1 || alert.Flags[0].Reference != "ALR-2018-77" {
+		t.Fatalf("Unexpected alert %+v", alert)
+	}
+	if pic := checkPicture(t, stub, "picture2"); pic.Owner != louvreUser.id {
+		t.Fatalf("Stolen picture transferred to %s", pic.Owner)
+	}
+
+	// the client refused records the attempt, which raises the alert
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture2", "reportTransferAttempt", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, louvreUser, "Picture picture1 is not reported stolen", "reportTransferAttempt", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvoke(t, stub, louvreUser, "reportTransferAttempt", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	event := lastEvent(t, stub)
+	if event.Type != eventStolenTransferAttempted || event.Actor != louvreUser.id || len(event.Pictures) != 1 || event.Pictures[0] != "picture2" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	attempts := readTitle(t, stub, "picture2").Flag.TransferAttempts
+	if len(attempts) != 1 || attempts[0].By != louvreUser.id || attempts[0].To != guggenheimUser.id || attempts[0].AttemptedAt == "" {
+		t.Fatalf("Unexpected transfer attempts %+v", attempts)
+	}
+
+	// one stolen picture blocks a bulk transfer. picture2 is retired as stolen and skipped, but
+	// picture4 is still held by an auction.
+	createPicture(t, stub, louvreUser, "picture4", "blue")
+	checkInvoke(t, stub, louvreUser, "createAuction", "picture4", "english", "EUR", "100.00", deadline(time.Hour))
+	checkInvoke(t, stub, registryUser, "reportStolen", "picture4", "ALR-2018-82")
+	e = checkInvokeError(t, stub, louvreUser, "reported stolen: picture4", "transferPicturesBasedOnGeneration", "blue", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	if e.Code != codeConflict {
+		t.Fatalf("Unexpected error %+v", e)
+	}
+	if alert := stolenAlert(t, e); len(alert.Flags) != 1 || alert.Flags[0].Picture != "picture4" {
+		t.Fatalf("Unexpected alert %+v", alert)
+	}
+	if pic := checkPicture(t, stub, "picture1"); pic.Owner != louvreUser.id {
+		t.Fatalf("Bulk transfer moved picture1 to %s", pic.Owner)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "Transfer failed", "transferPicturesBasedOnGeneration", "blue", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+
+	// offers, sales and auctions are refused
+	checkInvokeError(t, stub, louvreUser, "Picture picture2 is reported stolen, reference ALR-2018-77", "offerTransfer", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkTransientInvokeError(t, stub, louvreUser, priceTransient("1000.00", "EUR"), "Picture picture2 is reported stolen", "sellPicture", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, louvreUser, "Picture picture2 is reported stolen", "createAuction", "picture2", "english", "EUR", "100.00", deadline(time.Hour))
+	// the report withdrew the pending offer
+	checkInvokeError(t, stub, guggenheimUser, "No pending transfer offer for picture: picture3", "acceptTransfer", "picture3")
+}
+
+func TestStolenDuringAuction(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	auctionID := string(checkInvoke(t, stub, louvreUser, "createAuction", "picture1", "english", "EUR", "100.00", deadline(time.Hour)))
+	checkInvoke(t, stub, guggenheimUser, "placeBid", auctionID, "120.00")
+	checkInvoke(t, stub, registryUser, "reportStolen", "picture1", "ALR-2018-79")
+
+	// the picture stays at auction until the auction closes without a sale
+	if pic := checkPicture(t, stub, "picture1"); pic.Status != statusAtAuction {
+		t.Fatalf("Unexpected status %s", pic.Status)
+	}
+	endBidding(t, stub, auctionID)
+	checkInvoke(t, stub, louvreUser, "closeAuction", auctionID)
+	if a := readTestAuction(t, stub, auctionID); a.Status != auctionClosed || a.Winner != nil {
+		t.Fatalf("Unexpected auction %+v", a)
+	}
+	if pic := checkPicture(t, stub, "picture1"); pic.Owner != louvreUser.id || pic.Status != statusStolen || pic.RetirementReason != "Reported stolen, reference ALR-2018-79" {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+}
+
+func TestStolenDuringLoan(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	loanID := string(checkInvoke(t, stub, guggenheimUser, "requestLoan", "picture1", loanDate(0), loanDate(30)))
+	checkInvoke(t, stub, louvreUser, "approveLoan", loanID)
+	checkInvoke(t, stub, louvreUser, "reportStolen", "picture1", "OCBC-2018-0414", "Taken from the exhibition")
+
+	// the owner closes the loan, the picture is then stolen
+	if pic := checkPicture(t, stub, "picture1"); pic.Status != statusOnLoan {
+		t.Fatalf("Unexpected status %s", pic.Status)
+	}
+	checkInvoke(t, stub, louvreUser, "recordReturn", loanID)
+	if pic := checkPicture(t, stub, "picture1"); pic.Status != statusStolen || pic.Custodian != nil {
+		t.Fatalf("Unexpected picture %+v", pic)
+	}
+}
@@ -0,0 +1,427 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Two-phase transfers ====
+// A transfer offer is made by the current owner of a picture and keeps the picture with its
+// owner until the recipient accepts it. Offers are stored under a composite key built from
+// transferOfferPrefix and the picture name, so there is at most one pending offer per picture.
+
+const transferOfferPrefix = "transfer"
+
+// offers are valid for 30 days unless the owner asks for a different validity
+const defaultOfferValidityDays = 30
+
+type transferOffer struct {
+	ObjectType string   `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Picture    string   `json:"picture"`
+	From       identity `json:"from"`
+	To         identity `json:"to"`
+	OfferedAt  string   `json:"offeredAt"`           //RFC3339 transaction timestamp
+	ExpiresAt  string   `json:"expiresAt"`           //RFC3339, after which the offer can no longer be accepted
+	PriceHash  string   `json:"priceHash,omitempty"` //set when the offer is a sale, hash of the private price, see private.go
+}
+
+func (o *transferOffer) expired(now time.Time) bool {
+	expiresAt, err := time.Parse(time.RFC3339, o.ExpiresAt)
+	return err != nil || !now.Before(expiresAt)
+}
+
+// ===========================================================================================
+// getTransferOffer reads the pending offer for a picture, returning nil if there is none
+// ===========================================================================================
+func getTransferOffer(stub shim.ChaincodeStubInterface, pictureName string) (*transferOffer, error) {
+	offerKey, err := stub.CreateCompositeKey(transferOfferPrefix, []string{pictureName})
+	if err != nil {
+		return nil, err
+	}
+	offerAsBytes, err := stub.GetState(offerKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get transfer offer: %s", err)
+	} else if offerAsBytes == nil {
+		return nil, nil
+	}
+
+	offer := &transferOffer{}
+	err = json.Unmarshal(offerAsBytes, offer)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode transfer offer for: %s", pictureName)
+	}
+	return offer, nil
+}
+
+func putTransferOffer(stub shim.ChaincodeStubInterface, offer *transferOffer) error {
+	offerKey, err := stub.CreateCompositeKey(transferOfferPrefix, []string{offer.Picture})
+	if err != nil {
+		return err
+	}
+	offerJSONasBytes, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(offerKey, offerJSONasBytes)
+}
+
+// deleteTransferOffer removes the offer for a picture, and the private price of a sale offer
+func deleteTransferOffer(stub shim.ChaincodeStubInterface, pictureName string) error {
+	offer, err := getTransferOffer(stub, pictureName)
+	if err != nil || offer == nil {
+		return err
+	}
+	if offer.PriceHash != "" {
+		offerPriceKey, err := stub.CreateCompositeKey(offerPricePrefix, []string{pictureName})
+		if err != nil {
+			return err
+		}
+		for _, mspID := range []string{offer.From.MSPID, offer.To.MSPID} {
+			err = stub.DelPrivateData(privateCollection(mspID), offerPriceKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	offerKey, err := stub.CreateCompositeKey(transferOfferPrefix, []string{pictureName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(offerKey)
+}
+
+// ===========================================================================================
+// offerTransfer - the owner of a picture offers it to a new owner, who has to accept it.
+// An expired offer for the same picture is replaced, a live one has to be cancelled first.
+// ===========================================================================================
+func (t *SimpleChaincode) offerTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0            1                2                     3
+	// "name", "GuggenheimMSP", "CN=User1@guggenheim...", "14" (optional validity in days)
+	pictureName, to, validityDays, err := parseOfferArgs(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	fmt.Println("- start offerTransfer ", pictureName, to)
+
+	offer, err := putNewTransferOffer(stub, pictureName, to, validityDays, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, eventTransferOffered, []string{pictureName}, offer)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Println("- end offerTransfer (success)")
+	return shim.Success(nil)
+}
+
+// parseOfferArgs validates the picture name, recipient and optional validity shared by
+// offerTransfer and sellPicture
+func parseOfferArgs(args []string) (string, identity, int, error) {
+	to := identity{MSPID: args[1], Subject: args[2]}
+	if !galleryMSPs[to.MSPID] {
+		return "", identity{}, 0, newError(codeInvalidArgument, "", "Unknown organisation for the new owner: %s", to.MSPID)
+	}
+	validityDays := defaultOfferValidityDays
+	if len(args) == 4 {
+		validityDays, _ = strconv.Atoi(args[3])
+		if validityDays <= 0 {
+			return "", identity{}, 0, newError(codeInvalidArgument, "validityDays", "4th argument must be a positive number of days")
+		}
+	}
+	return args[0], to, validityDays, nil
+}
+
+// ===========================================================================================
+// putNewTransferOffer checks the caller may give the picture away and records the offer,
+// with a price, written to the collections of both parties, when it is a sale
+// ===========================================================================================
+func putNewTransferOffer(stub shim.ChaincodeStubInterface, pictureName string, to identity, validityDays int, price *privatePrice) (*transferOffer, error) {
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return nil, err
+	}
+	// checked first, a stolen picture is not available either
+	err = checkNotStolen(stub, pic)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAvailable(pic)
+	if err != nil {
+		return nil, err
+	}
+	if to == pic.Owner {
+		return nil, newError(codeConflict, "", "Picture %s is already owned by %s", pictureName, to)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := getTransferOffer(stub, pictureName)
+	if err != nil {
+		return nil, err
+	} else if pending != nil && !pending.expired(now) {
+		return nil, newError(codeConflict, "", "Picture %s already has a pending transfer offer to %s", pictureName, pending.To)
+	} else if pending != nil {
+		// the expired offer may leave a private price behind
+		err = deleteTransferOffer(stub, pictureName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offer := &transferOffer{
+		ObjectType: "transferOffer",
+		Picture:    pictureName,
+		From:       pic.Owner,
+		To:         to,
+		OfferedAt:  now.Format(time.RFC3339),
+		ExpiresAt:  now.AddDate(0, 0, validityDays).Format(time.RFC3339),
+	}
+	if price != nil {
+		offerPriceKey, err := stub.CreateCompositeKey(offerPricePrefix, []string{pictureName})
+		if err != nil {
+			return nil, err
+		}
+		price.ObjectType = "offerPrice"
+		price.Picture = pictureName
+		offer.PriceHash, err = putPrivate(stub, []string{offer.From.MSPID, offer.To.MSPID}, offerPriceKey, price)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return offer, putTransferOffer(stub, offer)
+}
+
+// ===========================================================================================
+// acceptTransfer - the recipient of a live offer accepts it and becomes the owner.
+// Accepting an offer made by sellPicture settles the sale at the offered price.
+// ===========================================================================================
+func (t *SimpleChaincode) acceptTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	pictureName := args[0]
+	fmt.Println("- start acceptTransfer ", pictureName)
+
+	offer, err := getTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if offer == nil {
+		return errorResponse(newError(codeNotFound, "name", "No pending transfer offer for picture: %s", pictureName))
+	}
+	err = checkIdentityOrAdmin(stub, offer.To)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if offer.expired(now) {
+		return errorResponse(newError(codeConflict, "name", "Transfer offer for picture %s expired at %s", pictureName, offer.ExpiresAt))
+	}
+
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// the picture may have been reported stolen since the offer was made
+	err = checkNotStolen(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkAvailable(pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// the price of a sale is read before changeOwner removes the accepted offer with it
+	var price *privatePrice
+	if offer.PriceHash != "" {
+		price, err = getOfferPrice(stub, offer)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	err = changeOwner(stub, pic, offer.To)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if price != nil {
+		s, err := recordSale(stub, pictureName, offer.From, offer.To, price.Price, price.Currency, price.Salt)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = emitEvent(stub, eventPictureSold, []string{pictureName}, s)
+		if err != nil {
+			return errorResponse(err)
+		}
+	} else {
+		err = emitEvent(stub, eventPictureTransferred, []string{pictureName}, ownerChange{From: offer.From, To: offer.To})
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	fmt.Println("- end acceptTransfer (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// rejectTransfer - the recipient of an offer declines it, the picture stays with its owner
+// ===========================================================================================
+func (t *SimpleChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	pictureName := args[0]
+	offer, err := getTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if offer == nil {
+		return errorResponse(newError(codeNotFound, "name", "No pending transfer offer for picture: %s", pictureName))
+	}
+	err = checkIdentityOrAdmin(stub, offer.To)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = deleteTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+	}
+
+	err = emitEvent(stub, eventTransferRejected, []string{pictureName}, offer)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// cancelTransfer - the owner of a picture withdraws the offer it made
+// ===========================================================================================
+func (t *SimpleChaincode) cancelTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	pictureName := args[0]
+	offer, err := getTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	} else if offer == nil {
+		return errorResponse(newError(codeNotFound, "name", "No pending transfer offer for picture: %s", pictureName))
+	}
+	pic, err := getPicture(stub, pictureName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkOwnerOrAdmin(stub, pic)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = deleteTransferOffer(stub, pictureName)
+	if err != nil {
+		return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+	}
+
+	err = emitEvent(stub, eventTransferCancelled, []string{pictureName}, offer)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// expireTransferOffers removes every offer past its deadline. Expired offers can never be
+// accepted, this only keeps them from piling up in state. Anyone may call it.
+// ===========================================================================================
+func (t *SimpleChaincode) expireTransferOffers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// range query over every offer, committing peers re-execute it so the result set is stable
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(transferOfferPrefix, []string{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	expired := []string{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		offer := transferOffer{}
+		err = json.Unmarshal(responseRange.Value, &offer)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if offer.expired(now) {
+			expired = append(expired, offer.Picture)
+		}
+	}
+
+	// the private price of an expired sale goes with the offer
+	for _, pictureName := range expired {
+		err = deleteTransferOffer(stub, pictureName)
+		if err != nil {
+			return errorResponse(fmt.Errorf("Failed to delete state: %s", err))
+		}
+	}
+
+	err = emitEvent(stub, eventTransferOffersExpired, expired, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	responsePayload := fmt.Sprintf("Expired %d transfer offers", len(expired))
+	fmt.Println("- end expireTransferOffers: " + responsePayload)
+	return shim.Success([]byte(responsePayload))
+}
+
+// ===========================================================================================
+// readTransferOffer - read the pending offer for a picture
+// ===========================================================================================
+func (t *SimpleChaincode) readTransferOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "name"
+	offer, err := getTransferOffer(stub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	} else if offer == nil {
+		return errorResponse(newError(codeNotFound, "name", "No pending transfer offer for picture: %s", args[0]))
+	}
+
+	offerJSONasBytes, err := json.Marshal(offer)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(offerJSONasBytes)
+}
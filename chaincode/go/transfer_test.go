@@ -0,0 +1,196 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func readOffer(t *testing.T, stub *shim.MockStub, name string) *transferOffer {
+	t.Helper()
+	offer := &transferOffer{}
+	err := json.Unmarshal(checkInvoke(t, stub, louvreUser, "readTransferOffer", name), offer)
+	if err != nil {
+		t.Fatal("Failed to decode offer:", err)
+	}
+	return offer
+}
+
+// expireOffer moves the deadline of the pending offer for a picture in the past
+func expireOffer(t *testing.T, stub *shim.MockStub, name string) {
+	t.Helper()
+	err := inTransaction(stub, func() error {
+		offer, err := getTransferOffer(stub, name)
+		if err != nil {
+			return err
+		}
+		offer.ExpiresAt = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+		return putTransferOffer(stub, offer)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOfferAndAcceptTransfer(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	to := guggenheimUser.id
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 3 or 4", "offerTransfer", "picture1", to.MSPID)
+	checkInvokeError(t, stub, louvreUser, "Unknown organisation for the new owner", "offerTransfer", "picture1", "OutsiderMSP", to.Subject)
+	checkInvokeError(t, stub, louvreUser, "4th argument must be a positive number of days", "offerTransfer", "picture1", to.MSPID, to.Subject, "0")
+	checkInvokeError(t, stub, louvreUser, "Picture does not exist: picture2", "offerTransfer", "picture2", to.MSPID, to.Subject)
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture1", "offerTransfer", "picture1", to.MSPID, to.Subject)
+	checkInvokeError(t, stub, louvreUser, "is already owned by", "offerTransfer", "picture1", louvreUser.id.MSPID, louvreUser.id.Subject)
+	checkInvokeError(t, stub, louvreUser, "No pending transfer offer for picture: picture1", "readTransferOffer", "picture1")
+
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", to.MSPID, to.Subject, "14")
+	if event := lastEvent(t, stub); event.Type != eventTransferOffered {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	offer := readOffer(t, stub, "picture1")
+	offeredAt, _ := time.Parse(time.RFC3339, offer.OfferedAt)
+	expiresAt, _ := time.Parse(time.RFC3339, offer.ExpiresAt)
+	if offer.From != louvreUser.id || offer.To != to || expiresAt.Sub(offeredAt) != 14*24*time.Hour {
+		t.Fatalf("Unexpected offer %+v", offer)
+	}
+	checkInvokeError(t, stub, louvreUser, "already has a pending transfer offer", "offerTransfer", "picture1", to.MSPID, to.Subject)
+
+	// the picture stays with its owner until the offer is accepted, by the recipient only
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != louvreUser.id {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "acceptTransfer")
+	checkInvokeError(t, stub, louvreUser, "is not allowed to act on behalf of", "acceptTransfer", "picture1")
+	checkInvoke(t, stub, guggenheimUser, "acceptTransfer", "picture1")
+
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != to {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+	if event := lastEvent(t, stub); event.Type != eventPictureTransferred {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "No pending transfer offer for picture: picture1", "acceptTransfer", "picture1")
+}
+
+func TestAcceptTransferByAdmin(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkInvoke(t, stub, louvreAdmin, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvoke(t, stub, guggenheimAdmin, "acceptTransfer", "picture1")
+
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != guggenheimUser.id {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+}
+
+func TestAcceptExpiredTransfer(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	expireOffer(t, stub, "picture1")
+
+	checkInvokeError(t, stub, guggenheimUser, "Transfer offer for picture picture1 expired", "acceptTransfer", "picture1")
+
+	// an expired offer does not block a new one
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimAdmin.id.MSPID, guggenheimAdmin.id.Subject)
+	if offer := readOffer(t, stub, "picture1"); offer.To != guggenheimAdmin.id {
+		t.Fatalf("Unexpected offer %+v", offer)
+	}
+}
+
+func TestAcceptTransferOfUnavailablePicture(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	setPictureStatus(t, stub, "picture1", statusOnLoan)
+
+	checkInvokeError(t, stub, guggenheimUser, "Picture picture1 is not available", "acceptTransfer", "picture1")
+}
+
+func TestRejectTransfer(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	checkInvokeError(t, stub, guggenheimUser, "Incorrect number of arguments. Expecting 1", "rejectTransfer")
+	checkInvokeError(t, stub, guggenheimUser, "No pending transfer offer for picture: picture1", "rejectTransfer", "picture1")
+
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, louvreUser, "is not allowed to act on behalf of", "rejectTransfer", "picture1")
+	checkInvoke(t, stub, guggenheimUser, "rejectTransfer", "picture1")
+
+	if event := lastEvent(t, stub); event.Type != eventTransferRejected {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	if owner := checkPicture(t, stub, "picture1").Owner; owner != louvreUser.id {
+		t.Fatalf("Unexpected owner %s", owner)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "No pending transfer offer for picture: picture1", "acceptTransfer", "picture1")
+}
+
+func TestCancelTransfer(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments. Expecting 1", "cancelTransfer")
+	checkInvokeError(t, stub, louvreUser, "No pending transfer offer for picture: picture1", "cancelTransfer", "picture1")
+
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvokeError(t, stub, guggenheimUser, "Not allowed to manage picture picture1", "cancelTransfer", "picture1")
+	checkInvoke(t, stub, louvreUser, "cancelTransfer", "picture1")
+
+	if event := lastEvent(t, stub); event.Type != eventTransferCancelled {
+		t.Fatalf("Unexpected event %s", event.Type)
+	}
+	checkInvokeError(t, stub, guggenheimUser, "No pending transfer offer for picture: picture1", "acceptTransfer", "picture1")
+}
+
+func TestExpireTransferOffers(t *testing.T) {
+	stub := newTestStub()
+	createPicture(t, stub, louvreUser, "picture1", "blue")
+	createPicture(t, stub, louvreUser, "picture2", "blue")
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture1", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	checkInvoke(t, stub, louvreUser, "offerTransfer", "picture2", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	createPicture(t, stub, louvreUser, "picture3", "blue")
+	checkTransientInvoke(t, stub, louvreUser, priceTransient("1000.00", "EUR"), "sellPicture", "picture3", guggenheimUser.id.MSPID, guggenheimUser.id.Subject)
+	expireOffer(t, stub, "picture1")
+	expireOffer(t, stub, "picture3")
+	offerPriceKey := compositeKey(t, stub, offerPricePrefix, "picture3")
+	if stub.PvtState[privateCollection("LouvreMSP")][offerPriceKey] == nil {
+		t.Fatal("The price of the sale was not written")
+	}
+
+	checkInvokeError(t, stub, outsiderUser, "Incorrect number of arguments. Expecting 0", "expireTransferOffers", "picture1")
+
+	// anyone may clean up
+	payload := checkInvoke(t, stub, outsiderUser, "expireTransferOffers")
+	if string(payload) != "Expired 2 transfer offers" {
+		t.Fatalf("Unexpected response %q", payload)
+	}
+	event := lastEvent(t, stub)
+	if event.Type != eventTransferOffersExpired || strings.Join(event.Pictures, ",") != "picture1,picture3" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	checkInvokeError(t, stub, louvreUser, "No pending transfer offer for picture: picture1", "readTransferOffer", "picture1")
+	readOffer(t, stub, "picture2")
+
+	// the price of the expired sale is removed from both collections
+	for _, mspID := range []string{"LouvreMSP", "GuggenheimMSP"} {
+		if stub.PvtState[privateCollection(mspID)][offerPriceKey] != nil {
+			t.Fatalf("The price of the expired sale is still in the collection of %s", mspID)
+		}
+	}
+}
+
+func TestReadTransferOffer(t *testing.T) {
+	stub := newTestStub()
+	checkInvokeError(t, stub, louvreUser, "Incorrect number of arguments", "readTransferOffer")
+}
@@ -0,0 +1,165 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+// ====CLI Usage================================================================================
+// The listener follows the blocks committed on the channel and keeps a local BoltDB copy of
+// the pictures, their generation index and their ownership changes. It stores the number of
+// the next block to process, so it can be stopped and restarted at any time.
+
+// Follow a peer (from the cli container, or anywhere the crypto material is available)
+// listener -db pictures.db -chaincode artgcc -peer peer0.louvre.artgalleries.com:7051 -channel artgallerieschannel \
+//   -mspid LouvreMSP -mspdir crypto/peerOrganizations/louvre.artgalleries.com/users/User1@louvre.artgalleries.com/msp \
+//   -tlsroot crypto/peerOrganizations/louvre.artgalleries.com/peers/peer0.louvre.artgalleries.com/tls/ca.crt
+
+// Replay recorded blocks, e.g. fetched with `peer channel fetch 5 5.block -c artgallerieschannel`
+// listener -db pictures.db -chaincode artgcc -blocks ./blocks
+
+// Query the read model, without following any blocks
+// listener -db pictures.db -list
+// listener -db pictures.db -list -owner LouvreMSP
+// listener -db pictures.db -generation 1
+// listener -db pictures.db -history picture1
+// listener -db pictures.db -picture picture1
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	dbPath := flag.String("db", "pictures.db", "BoltDB file holding the read model")
+	chaincodeName := flag.String("chaincode", "artgcc", "name the pictures chaincode is instantiated with")
+	blocksDir := flag.String("blocks", "", "replay the *.block files of this folder instead of following a peer")
+
+	var cfg peerConfig
+	flag.StringVar(&cfg.Address, "peer", "", "address of the peer to follow")
+	flag.StringVar(&cfg.ChannelID, "channel", "artgallerieschannel", "channel the chaincode runs on")
+	flag.StringVar(&cfg.MSPDir, "mspdir", "", "local MSP folder of the identity used to read blocks")
+	flag.StringVar(&cfg.MSPID, "mspid", "", "MSP ID of that identity")
+	flag.StringVar(&cfg.TLSRootCert, "tlsroot", "", "TLS root certificate of the peer, empty to connect without TLS")
+	flag.StringVar(&cfg.ServerName, "servername", "", "override of the TLS server name of the peer")
+
+	list := flag.Bool("list", false, "print the current pictures")
+	owner := flag.String("owner", "", "with -list, only print the pictures of this MSP")
+	generation := flag.String("generation", "", "print the names of the pictures of a generation")
+	history := flag.String("history", "", "print the ownership changes of a picture")
+	picture := flag.String("picture", "", "print a picture")
+	flag.Parse()
+
+	s, err := openStore(*dbPath)
+	if err != nil {
+		fail(err)
+	}
+	defer s.Close()
+
+	switch {
+	case *list:
+		err = printJSON(s.Pictures(*owner))
+	case *generation != "":
+		err = printJSON(s.PicturesByGeneration(*generation))
+	case *history != "":
+		err = printJSON(s.OwnershipChanges(*history))
+	case *picture != "":
+		var pictureJSON []byte
+		pictureJSON, err = s.Picture(*picture)
+		if err == nil && pictureJSON == nil {
+			err = fmt.Errorf("picture %s not found", *picture)
+		} else if err == nil {
+			fmt.Println(string(pictureJSON))
+		}
+	default:
+		err = follow(s, *chaincodeName, *blocksDir, cfg)
+	}
+	if err != nil {
+		s.Close()
+		fail(err)
+	}
+}
+
+// follow applies blocks from the checkpoint on, until the recorded blocks run out or the
+// listener is interrupted
+func follow(s *store, chaincodeName string, blocksDir string, cfg peerConfig) error {
+	next, err := s.NextBlock()
+	if err != nil {
+		return err
+	}
+
+	var source blockSource
+	if blocksDir != "" {
+		source, err = newFileSource(blocksDir, next)
+	} else if cfg.Address != "" {
+		source, err = newPeerSource(cfg, next)
+	} else {
+		return fmt.Errorf("either -blocks or -peer is required")
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// closing the source makes a pending Next fail, the block being applied is not affected
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		<-interrupted
+		close(stopped)
+		source.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "- following %s from block %d\n", chaincodeName, next)
+	for {
+		block, err := source.Next()
+		if err == io.EOF {
+			fmt.Fprintf(os.Stderr, "- no more blocks, next block is %d\n", next)
+			return nil
+		} else if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		// a source must not skip or repeat blocks, or the checkpoint would be wrong
+		if block.Header.Number != next {
+			return fmt.Errorf("expected block %d, received block %d", next, block.Header.Number)
+		}
+		update, err := extractUpdate(block, chaincodeName)
+		if err != nil {
+			return err
+		}
+		err = s.Apply(update)
+		if err != nil {
+			return fmt.Errorf("failed to apply block %d: %s", update.Number, err)
+		}
+		fmt.Fprintf(os.Stderr, "- applied block %d (%d writes)\n", update.Number, len(update.Writes))
+		next++
+	}
+}
+
+func printJSON(value interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	valueJSON, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(valueJSON))
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,174 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Block projection ====
+// extractUpdate walks the valid endorser transactions of a block and keeps the writes the
+// pictures chaincode made to picture keys and to its generation~name index. Other composite
+// keys (offers, sales, auctions, loans) are not part of the read model and are skipped.
+
+// compositeKeyNamespace prefixes every composite key created by the shim
+const compositeKeyNamespace = "\x00"
+
+const generationIndex = "generation~name"
+
+const (
+	writePicture = iota
+	writeGenerationIndex
+)
+
+type stateWrite struct {
+	Kind       int
+	TxID       string
+	TxIndex    int
+	Timestamp  string
+	Name       string //picture name
+	Generation string //generation~name index writes only
+	IsDelete   bool
+	Value      []byte
+}
+
+type blockUpdate struct {
+	Number uint64
+	Writes []*stateWrite
+}
+
+func extractUpdate(block *cb.Block, chaincodeName string) (*blockUpdate, error) {
+	if block.Header == nil || block.Data == nil {
+		return nil, fmt.Errorf("malformed block: missing header or data")
+	}
+	update := &blockUpdate{Number: block.Header.Number}
+
+	// the peer records the validation result of every transaction in the block metadata.
+	// Blocks that were never validated (e.g. read from an orderer) have no filter and are
+	// considered valid.
+	var txFilter []byte
+	if block.Metadata != nil && len(block.Metadata.Metadata) > int(cb.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		txFilter = block.Metadata.Metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+
+	for txIndex, envelopeBytes := range block.Data.Data {
+		if txIndex < len(txFilter) && pb.TxValidationCode(txFilter[txIndex]) != pb.TxValidationCode_VALID {
+			continue
+		}
+
+		writes, err := extractTransactionWrites(envelopeBytes, chaincodeName)
+		if err != nil {
+			return nil, fmt.Errorf("block %d, transaction %d: %s", update.Number, txIndex, err)
+		}
+		for _, write := range writes {
+			write.TxIndex = txIndex
+			update.Writes = append(update.Writes, write)
+		}
+	}
+	return update, nil
+}
+
+func extractTransactionWrites(envelopeBytes []byte, chaincodeName string) ([]*stateWrite, error) {
+	envelope := &cb.Envelope{}
+	if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode envelope: %s", err)
+	}
+	payload := &cb.Payload{}
+	if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %s", err)
+	}
+	if payload.Header == nil {
+		return nil, fmt.Errorf("payload without header")
+	}
+	channelHeader := &cb.ChannelHeader{}
+	if err := proto.Unmarshal(payload.Header.ChannelHeader, channelHeader); err != nil {
+		return nil, fmt.Errorf("failed to decode channel header: %s", err)
+	}
+	// configuration and other transactions carry no chaincode writes
+	if cb.HeaderType(channelHeader.Type) != cb.HeaderType_ENDORSER_TRANSACTION {
+		return nil, nil
+	}
+
+	var timestamp string
+	if channelHeader.Timestamp != nil {
+		timestamp = time.Unix(channelHeader.Timestamp.Seconds, int64(channelHeader.Timestamp.Nanos)).UTC().Format(time.RFC3339)
+	}
+
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(payload.Data, tx); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %s", err)
+	}
+
+	var writes []*stateWrite
+	for _, action := range tx.Actions {
+		actionPayload := &pb.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.Payload, actionPayload); err != nil {
+			return nil, fmt.Errorf("failed to decode chaincode action payload: %s", err)
+		}
+		if actionPayload.Action == nil {
+			continue
+		}
+		responsePayload := &pb.ProposalResponsePayload{}
+		if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload); err != nil {
+			return nil, fmt.Errorf("failed to decode proposal response payload: %s", err)
+		}
+		chaincodeAction := &pb.ChaincodeAction{}
+		if err := proto.Unmarshal(responsePayload.Extension, chaincodeAction); err != nil {
+			return nil, fmt.Errorf("failed to decode chaincode action: %s", err)
+		}
+		txRWSet := &rwset.TxReadWriteSet{}
+		if err := proto.Unmarshal(chaincodeAction.Results, txRWSet); err != nil {
+			return nil, fmt.Errorf("failed to decode read/write set: %s", err)
+		}
+
+		for _, nsRWSet := range txRWSet.NsRwset {
+			if nsRWSet.Namespace != chaincodeName {
+				continue
+			}
+			kvRWSet := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(nsRWSet.Rwset, kvRWSet); err != nil {
+				return nil, fmt.Errorf("failed to decode %s read/write set: %s", chaincodeName, err)
+			}
+			for _, kvWrite := range kvRWSet.Writes {
+				write := classifyWrite(kvWrite)
+				if write == nil {
+					continue
+				}
+				write.TxID = channelHeader.TxId
+				write.Timestamp = timestamp
+				writes = append(writes, write)
+			}
+		}
+	}
+	return writes, nil
+}
+
+// classifyWrite maps a chaincode write to the read model, or returns nil for keys the read
+// model does not track. Picture keys are the only simple keys the chaincode writes.
+func classifyWrite(kvWrite *kvrwset.KVWrite) *stateWrite {
+	if !strings.HasPrefix(kvWrite.Key, compositeKeyNamespace) {
+		return &stateWrite{Kind: writePicture, Name: kvWrite.Key, IsDelete: kvWrite.IsDelete, Value: kvWrite.Value}
+	}
+
+	objectType, attributes := splitCompositeKey(kvWrite.Key)
+	if objectType != generationIndex || len(attributes) != 2 {
+		return nil
+	}
+	return &stateWrite{Kind: writeGenerationIndex, Generation: attributes[0], Name: attributes[1], IsDelete: kvWrite.IsDelete}
+}
+
+// splitCompositeKey mirrors the shim: \x00 objectType \x00 attr1 \x00 attr2 \x00 ...
+func splitCompositeKey(key string) (string, []string) {
+	components := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, compositeKeyNamespace), "\x00"), "\x00")
+	return components[0], components[1:]
+}
@@ -0,0 +1,87 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestExtractUpdate(t *testing.T) {
+	louvrePicture := testPicture(t, "picture1", "1", "LouvreMSP")
+	block := testBlock(7, []pb.TxValidationCode{pb.TxValidationCode_VALID, pb.TxValidationCode_VALID, pb.TxValidationCode_MVCC_READ_CONFLICT, pb.TxValidationCode_VALID},
+		// configuration transactions carry no chaincode writes
+		testEnvelope(t, cb.HeaderType_CONFIG, "", nil),
+		testTransaction(t, "tx1",
+			namespaceWrites{testChaincode, []*kvrwset.KVWrite{
+				{Key: "picture1", Value: louvrePicture},
+				{Key: testCompositeKey("generation~name", "1", "picture1"), Value: []byte{0x00}},
+				{Key: testCompositeKey("owner~name", "LouvreMSP", "CN=User1@louvremsp", "picture1"), Value: []byte{0x00}},
+			}},
+			// writes of other chaincodes in the same transaction are not ours
+			namespaceWrites{"lscc", []*kvrwset.KVWrite{{Key: "picture2", Value: []byte("{}")}}},
+		),
+		// invalidated transactions changed nothing
+		testPictureTransaction(t, "tx2", &kvrwset.KVWrite{Key: "picture3", Value: testPicture(t, "picture3", "1", "LouvreMSP")}),
+		testPictureTransaction(t, "tx3",
+			&kvrwset.KVWrite{Key: "picture1", IsDelete: true},
+			&kvrwset.KVWrite{Key: testCompositeKey("generation~name", "1", "picture1"), IsDelete: true},
+			&kvrwset.KVWrite{Key: testCompositeKey("transfer", "picture1"), IsDelete: true},
+		),
+	)
+	dir := writeBlockFiles(t, block)
+	defer os.RemoveAll(dir)
+	source, err := newFileSource(dir, 7)
+	if err != nil {
+		t.Fatal("Failed to open recorded blocks:", err)
+	}
+	recorded, err := source.Next()
+	if err != nil {
+		t.Fatal("Failed to read block:", err)
+	}
+
+	update, err := extractUpdate(recorded, testChaincode)
+	if err != nil {
+		t.Fatal("extractUpdate failed:", err)
+	}
+	expected := []stateWrite{
+		{Kind: writePicture, TxID: "tx1", TxIndex: 1, Name: "picture1", Value: louvrePicture},
+		{Kind: writeGenerationIndex, TxID: "tx1", TxIndex: 1, Name: "picture1", Generation: "1"},
+		{Kind: writePicture, TxID: "tx3", TxIndex: 3, Name: "picture1", IsDelete: true},
+		{Kind: writeGenerationIndex, TxID: "tx3", TxIndex: 3, Name: "picture1", Generation: "1", IsDelete: true},
+	}
+	if update.Number != 7 || len(update.Writes) != len(expected) {
+		t.Fatalf("Unexpected update of block %d with %d writes", update.Number, len(update.Writes))
+	}
+	for i, e := range expected {
+		w := update.Writes[i]
+		if w.Kind != e.Kind || w.TxID != e.TxID || w.TxIndex != e.TxIndex || w.Name != e.Name || w.Generation != e.Generation ||
+			w.IsDelete != e.IsDelete || string(w.Value) != string(e.Value) || w.Timestamp != "2018-09-01T10:00:00Z" {
+			t.Fatalf("Unexpected write %d: %+v", i, w)
+		}
+	}
+
+	// the same block seen by a listener of another chaincode
+	update, err = extractUpdate(recorded, "othercc")
+	if err != nil || len(update.Writes) != 0 {
+		t.Fatalf("Unexpected update %+v (%v)", update, err)
+	}
+	if _, err := extractUpdate(&cb.Block{Header: &cb.BlockHeader{Number: 8}}, testChaincode); err == nil {
+		t.Fatal("Expected an error for a block without data")
+	}
+}
+
+func TestExtractUpdateWithoutValidation(t *testing.T) {
+	// blocks read from an orderer were never validated, all their transactions apply
+	block := testBlock(0, nil, testPictureTransaction(t, "tx0", &kvrwset.KVWrite{Key: "picture1", Value: testPicture(t, "picture1", "1", "LouvreMSP")}))
+	update, err := extractUpdate(block, testChaincode)
+	if err != nil || len(update.Writes) != 1 || update.Writes[0].Name != "picture1" {
+		t.Fatalf("Unexpected update %+v (%v)", update, err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/common/localmsp"
+	mspmgmt "github.com/hyperledger/fabric/msp/mgmt"
+	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// blockSource hands out committed blocks in order, starting from the block the source was
+// opened at. Next returns io.EOF once a finite source has no more blocks.
+type blockSource interface {
+	Next() (*cb.Block, error)
+	Close() error
+}
+
+// ==== Recorded blocks ====
+// fileSource replays blocks recorded as files, e.g. with `peer channel fetch <number>`, so
+// the listener can be run and tested without a peer. Every *.block file in the directory
+// holds one marshalled common.Block; file names do not matter, blocks are ordered by number.
+
+type fileSource struct {
+	files map[uint64]string
+	next  uint64
+}
+
+func newFileSource(dir string, start uint64) (*fileSource, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.block"))
+	if err != nil {
+		return nil, err
+	}
+	source := &fileSource{files: map[uint64]string{}, next: start}
+	for _, path := range paths {
+		block, err := readBlockFile(path)
+		if err != nil {
+			return nil, err
+		}
+		source.files[block.Header.Number] = path
+	}
+	return source, nil
+}
+
+func readBlockFile(path string) (*cb.Block, error) {
+	blockBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block := &cb.Block{}
+	if err := proto.Unmarshal(blockBytes, block); err != nil {
+		return nil, fmt.Errorf("failed to decode block file %s: %s", path, err)
+	}
+	if block.Header == nil {
+		return nil, fmt.Errorf("block file %s has no header", path)
+	}
+	return block, nil
+}
+
+// Next returns the following block, or io.EOF at the first missing block number
+func (s *fileSource) Next() (*cb.Block, error) {
+	path, ok := s.files[s.next]
+	if !ok {
+		return nil, io.EOF
+	}
+	block, err := readBlockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s.next++
+	return block, nil
+}
+
+func (s *fileSource) Close() error {
+	return nil
+}
+
+// ==== Live peer ====
+// peerSource reads blocks from the deliver service of a peer, the same service used by
+// `peer channel fetch`. Full blocks (not filtered ones) are needed to see the read/write
+// sets, so the request is signed with an identity of the local MSP that is a member of
+// the channel.
+
+type peerConfig struct {
+	Address     string //host:port of the peer
+	ChannelID   string
+	MSPDir      string //local MSP folder of the signing identity
+	MSPID       string
+	TLSRootCert string //PEM file of the peer TLS CA, empty to connect without TLS
+	ServerName  string //overrides the TLS server name, e.g. when connecting through a tunnel
+}
+
+type peerSource struct {
+	conn   *grpc.ClientConn
+	stream pb.Deliver_DeliverClient
+	cancel context.CancelFunc
+}
+
+func newPeerSource(cfg peerConfig, start uint64) (*peerSource, error) {
+	err := mspmgmt.LoadLocalMsp(cfg.MSPDir, factory.GetDefaultOpts(), cfg.MSPID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local MSP from %s: %s", cfg.MSPDir, err)
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithBlock()}
+	if cfg.TLSRootCert != "" {
+		creds, err := credentials.NewClientTLSFromFile(cfg.TLSRootCert, cfg.ServerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS root certificate %s: %s", cfg.TLSRootCert, err)
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(cfg.Address, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %s", cfg.Address, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewDeliverClient(conn).Deliver(ctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, fmt.Errorf("failed to open deliver stream: %s", err)
+	}
+
+	// ask for every block from start on, waiting for new ones as they are committed
+	seekInfo := &ab.SeekInfo{
+		Start:    &ab.SeekPosition{Type: &ab.SeekPosition_Specified{Specified: &ab.SeekSpecified{Number: start}}},
+		Stop:     &ab.SeekPosition{Type: &ab.SeekPosition_Specified{Specified: &ab.SeekSpecified{Number: math.MaxUint64}}},
+		Behavior: ab.SeekInfo_BLOCK_UNTIL_READY,
+	}
+	envelope, err := utils.CreateSignedEnvelope(cb.HeaderType_DELIVER_SEEK_INFO, cfg.ChannelID, localmsp.NewSigner(), seekInfo, 0, 0)
+	if err == nil {
+		err = stream.Send(envelope)
+	}
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, fmt.Errorf("failed to request blocks: %s", err)
+	}
+
+	return &peerSource{conn: conn, stream: stream, cancel: cancel}, nil
+}
+
+func (s *peerSource) Next() (*cb.Block, error) {
+	response, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	switch t := response.Type.(type) {
+	case *pb.DeliverResponse_Block:
+		return t.Block, nil
+	case *pb.DeliverResponse_Status:
+		return nil, fmt.Errorf("deliver service ended the stream with status %s", t.Status)
+	default:
+		return nil, fmt.Errorf("unexpected deliver response %T", t)
+	}
+}
+
+func (s *peerSource) Close() error {
+	s.cancel()
+	return s.conn.Close()
+}
@@ -0,0 +1,176 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== Synthesised blocks ====
+// The tests record blocks the way `peer channel fetch` does, one marshalled common.Block per
+// file, built from the same messages a peer commits: envelope, payload, transaction,
+// chaincode action and read/write sets.
+
+const testChaincode = "artgcc"
+
+// testBlockTime is the timestamp of every synthesised transaction
+const testBlockTime = 1535796000 //2018-09-01T10:00:00Z
+
+func mustMarshal(t *testing.T, message proto.Message) []byte {
+	t.Helper()
+	messageBytes, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal("Failed to marshal message:", err)
+	}
+	return messageBytes
+}
+
+// testCompositeKey builds a composite key the way the shim does
+func testCompositeKey(objectType string, attributes ...string) string {
+	return compositeKeyNamespace + objectType + "\x00" + strings.Join(attributes, "\x00") + "\x00"
+}
+
+func testPicture(t *testing.T, name, generation, ownerMSPID string) []byte {
+	t.Helper()
+	pictureJSON, err := json.Marshal(map[string]interface{}{
+		"docType":    "picture",
+		"name":       name,
+		"generation": generation,
+		"owner":      identity{MSPID: ownerMSPID, Subject: "CN=User1@" + strings.ToLower(ownerMSPID)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pictureJSON
+}
+
+// namespaceWrites are the writes of one chaincode in a transaction
+type namespaceWrites struct {
+	namespace string
+	writes    []*kvrwset.KVWrite
+}
+
+// testTransaction builds an endorser transaction envelope with the given writes
+func testTransaction(t *testing.T, txID string, namespaces ...namespaceWrites) []byte {
+	t.Helper()
+	txRWSet := &rwset.TxReadWriteSet{}
+	for _, ns := range namespaces {
+		txRWSet.NsRwset = append(txRWSet.NsRwset, &rwset.NsReadWriteSet{
+			Namespace: ns.namespace,
+			Rwset:     mustMarshal(t, &kvrwset.KVRWSet{Writes: ns.writes}),
+		})
+	}
+	chaincodeAction := mustMarshal(t, &pb.ChaincodeAction{Results: mustMarshal(t, txRWSet)})
+	responsePayload := mustMarshal(t, &pb.ProposalResponsePayload{Extension: chaincodeAction})
+	actionPayload := mustMarshal(t, &pb.ChaincodeActionPayload{Action: &pb.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload}})
+	tx := mustMarshal(t, &pb.Transaction{Actions: []*pb.TransactionAction{{Payload: actionPayload}}})
+	return testEnvelope(t, cb.HeaderType_ENDORSER_TRANSACTION, txID, tx)
+}
+
+// testPictureTransaction is a transaction of the pictures chaincode only
+func testPictureTransaction(t *testing.T, txID string, writes ...*kvrwset.KVWrite) []byte {
+	t.Helper()
+	return testTransaction(t, txID, namespaceWrites{testChaincode, writes})
+}
+
+func testEnvelope(t *testing.T, headerType cb.HeaderType, txID string, data []byte) []byte {
+	t.Helper()
+	channelHeader := mustMarshal(t, &cb.ChannelHeader{Type: int32(headerType), TxId: txID, Timestamp: &timestamp.Timestamp{Seconds: testBlockTime}})
+	payload := mustMarshal(t, &cb.Payload{Header: &cb.Header{ChannelHeader: channelHeader}, Data: data})
+	return mustMarshal(t, &cb.Envelope{Payload: payload})
+}
+
+// testBlock builds a block of transactions. validation holds the TxValidationCode of each
+// transaction as the peer records it, nil for a block that was never validated.
+func testBlock(number uint64, validation []pb.TxValidationCode, transactions ...[]byte) *cb.Block {
+	block := &cb.Block{
+		Header: &cb.BlockHeader{Number: number},
+		Data:   &cb.BlockData{Data: transactions},
+	}
+	if validation != nil {
+		txFilter := make([]byte, len(validation))
+		for i, code := range validation {
+			txFilter[i] = byte(code)
+		}
+		block.Metadata = &cb.BlockMetadata{Metadata: make([][]byte, cb.BlockMetadataIndex_TRANSACTIONS_FILTER+1)}
+		block.Metadata.Metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER] = txFilter
+	}
+	return block
+}
+
+// writeBlockFiles records blocks in a new folder, named like `peer channel fetch` output
+func writeBlockFiles(t *testing.T, blocks ...*cb.Block) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addBlockFiles(t, dir, blocks...)
+	return dir
+}
+
+func addBlockFiles(t *testing.T, dir string, blocks ...*cb.Block) {
+	t.Helper()
+	for _, block := range blocks {
+		path := filepath.Join(dir, fmt.Sprintf("artgallerieschannel_%d.block", block.Header.Number))
+		err := ioutil.WriteFile(path, mustMarshal(t, block), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir := writeBlockFiles(t,
+		testBlock(2, nil, testPictureTransaction(t, "tx2")),
+		testBlock(0, nil, testPictureTransaction(t, "tx0")),
+		testBlock(1, nil, testPictureTransaction(t, "tx1")),
+		testBlock(4, nil, testPictureTransaction(t, "tx4")),
+	)
+	defer os.RemoveAll(dir)
+	// files that are not blocks are ignored
+	err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("fetched from peer0"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks come in order from the start block, and stop at the first missing number
+	source, err := newFileSource(dir, 1)
+	if err != nil {
+		t.Fatal("Failed to open recorded blocks:", err)
+	}
+	defer source.Close()
+	for _, expected := range []uint64{1, 2} {
+		block, err := source.Next()
+		if err != nil || block.Header.Number != expected {
+			t.Fatalf("Expected block %d, got %v (%v)", expected, block, err)
+		}
+	}
+	if block, err := source.Next(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v (%v)", block, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "broken.block"), []byte("not a block"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileSource(dir, 0); err == nil || !strings.Contains(err.Error(), "broken.block") {
+		t.Fatalf("Expected a decoding error, got %v", err)
+	}
+}
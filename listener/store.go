@@ -0,0 +1,245 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ==== Local read model ====
+// The store keeps the current pictures, the generation index and the ownership changes seen
+// in committed blocks, in a BoltDB file. Every block is applied in a single BoltDB
+// transaction together with the checkpoint, so a restart resumes from the first block that
+// was not fully applied.
+
+var (
+	picturesBucket    = []byte("pictures")    //picture name -> picture JSON as written by the chaincode
+	generationsBucket = []byte("generations") //generation \x00 name -> empty
+	ownershipBucket   = []byte("ownership")   //picture name \x00 block number \x00 tx index -> ownershipChange JSON
+	metaBucket        = []byte("meta")
+
+	checkpointKey = []byte("nextBlock")
+)
+
+// pictureRecord holds the fields of a chaincode picture the read model needs to know about.
+// The full JSON document is stored as written by the chaincode.
+type pictureRecord struct {
+	ObjectType string   `json:"docType"`
+	Name       string   `json:"name"`
+	Generation string   `json:"generation"`
+	Owner      identity `json:"owner"`
+}
+
+type identity struct {
+	MSPID   string `json:"mspId"`
+	Subject string `json:"subject"`
+}
+
+// ownershipChange records a picture changing owner, including its creation (no previous
+// owner) and deletion (no new owner)
+type ownershipChange struct {
+	Picture     string    `json:"picture"`
+	BlockNumber uint64    `json:"blockNumber"`
+	TxID        string    `json:"txId"`
+	Timestamp   string    `json:"timestamp"` //RFC3339 timestamp of the transaction
+	From        *identity `json:"from,omitempty"`
+	To          *identity `json:"to,omitempty"`
+}
+
+type store struct {
+	db *bolt.DB
+}
+
+func openStore(path string) (*store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{picturesBucket, generationsBucket, ownershipBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise store %s: %s", path, err)
+	}
+	return &store{db: db}, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// NextBlock returns the number of the first block that has not been applied yet
+func (s *store) NextBlock() (uint64, error) {
+	var next uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(metaBucket).Get(checkpointKey)
+		if value != nil {
+			next = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return next, err
+}
+
+// Apply writes the changes of a block and moves the checkpoint past it, atomically
+func (s *store) Apply(update *blockUpdate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		pictures := tx.Bucket(picturesBucket)
+		generations := tx.Bucket(generationsBucket)
+		ownership := tx.Bucket(ownershipBucket)
+
+		for _, write := range update.Writes {
+			var err error
+			switch write.Kind {
+			case writePicture:
+				err = applyPictureWrite(pictures, ownership, update.Number, write)
+			case writeGenerationIndex:
+				key := generationKey(write.Generation, write.Name)
+				if write.IsDelete {
+					err = generations.Delete(key)
+				} else {
+					err = generations.Put(key, []byte{})
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		checkpoint := make([]byte, 8)
+		binary.BigEndian.PutUint64(checkpoint, update.Number+1)
+		return tx.Bucket(metaBucket).Put(checkpointKey, checkpoint)
+	})
+}
+
+func applyPictureWrite(pictures, ownership *bolt.Bucket, blockNumber uint64, write *stateWrite) error {
+	change := &ownershipChange{
+		Picture:     write.Name,
+		BlockNumber: blockNumber,
+		TxID:        write.TxID,
+		Timestamp:   write.Timestamp,
+	}
+	if previous := pictures.Get([]byte(write.Name)); previous != nil {
+		record := &pictureRecord{}
+		if err := json.Unmarshal(previous, record); err == nil {
+			change.From = &record.Owner
+		}
+	}
+
+	if write.IsDelete {
+		if err := pictures.Delete([]byte(write.Name)); err != nil {
+			return err
+		}
+	} else {
+		record := &pictureRecord{}
+		if err := json.Unmarshal(write.Value, record); err != nil {
+			return fmt.Errorf("failed to decode picture %s in tx %s: %s", write.Name, write.TxID, err)
+		}
+		if err := pictures.Put([]byte(write.Name), write.Value); err != nil {
+			return err
+		}
+		change.To = &record.Owner
+	}
+
+	// most picture writes do not change the owner, only keep those that do
+	if change.From != nil && change.To != nil && *change.From == *change.To {
+		return nil
+	}
+	changeJSON, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return ownership.Put(ownershipKey(write.Name, blockNumber, write.TxIndex), changeJSON)
+}
+
+func generationKey(generation, name string) []byte {
+	return []byte(generation + "\x00" + name)
+}
+
+// ownershipKey sorts the changes of a picture in ledger order
+func ownershipKey(name string, blockNumber uint64, txIndex int) []byte {
+	key := make([]byte, 0, len(name)+1+8+1+4)
+	key = append(key, name...)
+	key = append(key, 0)
+	key = append(key, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[len(key)-8:], blockNumber)
+	key = append(key, 0)
+	key = append(key, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(key[len(key)-4:], uint32(txIndex))
+	return key
+}
+
+// Picture returns the current JSON document of a picture, or nil if it does not exist
+func (s *store) Picture(name string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(picturesBucket).Get([]byte(name)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Pictures returns the current pictures, optionally only those owned by an organisation
+func (s *store) Pictures(ownerMSPID string) ([]json.RawMessage, error) {
+	results := []json.RawMessage{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(picturesBucket).ForEach(func(k, v []byte) error {
+			if ownerMSPID != "" {
+				record := &pictureRecord{}
+				if err := json.Unmarshal(v, record); err != nil || record.Owner.MSPID != ownerMSPID {
+					return nil
+				}
+			}
+			results = append(results, append(json.RawMessage{}, v...))
+			return nil
+		})
+	})
+	return results, err
+}
+
+// PicturesByGeneration returns the names of the pictures of a generation, from the index
+func (s *store) PicturesByGeneration(generation string) ([]string, error) {
+	names := []string{}
+	prefix := []byte(generation + "\x00")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(generationsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			names = append(names, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// OwnershipChanges returns the ownership changes of a picture in ledger order
+func (s *store) OwnershipChanges(name string) ([]ownershipChange, error) {
+	changes := []ownershipChange{}
+	prefix := append([]byte(name), 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(ownershipBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			change := ownershipChange{}
+			if err := json.Unmarshal(v, &change); err != nil {
+				return err
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	return changes, err
+}
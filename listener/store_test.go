@@ -0,0 +1,236 @@
+/*
+ SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+func openTestStore(t *testing.T, dir string) *store {
+	t.Helper()
+	s, err := openStore(filepath.Join(dir, "pictures.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// applyBlockFiles applies the recorded blocks from the checkpoint on, like the listener does
+func applyBlockFiles(t *testing.T, s *store, dir string) {
+	t.Helper()
+	err := follow(s, testChaincode, dir, peerConfig{})
+	if err != nil {
+		t.Fatal("Failed to apply recorded blocks:", err)
+	}
+}
+
+func checkNextBlock(t *testing.T, s *store, expected uint64) {
+	t.Helper()
+	next, err := s.NextBlock()
+	if err != nil || next != expected {
+		t.Fatalf("Expected next block %d, got %d (%v)", expected, next, err)
+	}
+}
+
+func checkGeneration(t *testing.T, s *store, generation string, expected string) {
+	t.Helper()
+	names, err := s.PicturesByGeneration(generation)
+	if err != nil || strings.Join(names, ",") != expected {
+		t.Fatalf("Expected generation %s to be [%s], got %v (%v)", generation, expected, names, err)
+	}
+}
+
+// ownershipSummary describes ownership changes as block:from>to, with - for no owner
+func ownershipSummary(changes []ownershipChange) string {
+	summary := []string{}
+	for _, change := range changes {
+		from, to := "-", "-"
+		if change.From != nil {
+			from = change.From.MSPID
+		}
+		if change.To != nil {
+			to = change.To.MSPID
+		}
+		summary = append(summary, fmt.Sprintf("%d:%s>%s", change.BlockNumber, from, to))
+	}
+	return strings.Join(summary, ",")
+}
+
+// testLedger is the recorded history of two pictures:
+//
+//	block 0  genesis configuration
+//	block 1  picture1 and picture2 created by the Louvre
+//	block 2  picture1 transferred to the Guggenheim, then updated without changing owner
+//	block 3  picture1 transferred back to the Louvre, picture2 moved to generation 3
+//	block 4  picture2 deleted
+func testLedger(t *testing.T) []*cb.Block {
+	generationKey := func(generation, name string) string {
+		return testCompositeKey("generation~name", generation, name)
+	}
+	return []*cb.Block{
+		testBlock(0, nil, testEnvelope(t, cb.HeaderType_CONFIG, "", nil)),
+		testBlock(1, nil,
+			testPictureTransaction(t, "create1",
+				&kvrwset.KVWrite{Key: "picture1", Value: testPicture(t, "picture1", "1", "LouvreMSP")},
+				&kvrwset.KVWrite{Key: generationKey("1", "picture1"), Value: []byte{0x00}}),
+			testPictureTransaction(t, "create2",
+				&kvrwset.KVWrite{Key: "picture2", Value: testPicture(t, "picture2", "2", "LouvreMSP")},
+				&kvrwset.KVWrite{Key: generationKey("2", "picture2"), Value: []byte{0x00}}),
+		),
+		testBlock(2, nil,
+			testPictureTransaction(t, "transfer1",
+				&kvrwset.KVWrite{Key: "picture1", Value: testPicture(t, "picture1", "1", "GuggenheimMSP")},
+				&kvrwset.KVWrite{Key: testCompositeKey("transfer", "picture1"), IsDelete: true}),
+			testPictureTransaction(t, "update1",
+				&kvrwset.KVWrite{Key: "picture1", Value: testPicture(t, "picture1", "1", "GuggenheimMSP")}),
+		),
+		testBlock(3, nil,
+			testPictureTransaction(t, "generation2",
+				&kvrwset.KVWrite{Key: "picture2", Value: testPicture(t, "picture2", "3", "LouvreMSP")},
+				&kvrwset.KVWrite{Key: generationKey("2", "picture2"), IsDelete: true},
+				&kvrwset.KVWrite{Key: generationKey("3", "picture2"), Value: []byte{0x00}}),
+			testPictureTransaction(t, "transfer2",
+				&kvrwset.KVWrite{Key: "picture1", Value: testPicture(t, "picture1", "1", "LouvreMSP")}),
+		),
+		testBlock(4, nil,
+			testPictureTransaction(t, "delete2",
+				&kvrwset.KVWrite{Key: "picture2", IsDelete: true},
+				&kvrwset.KVWrite{Key: generationKey("3", "picture2"), IsDelete: true}),
+		),
+	}
+}
+
+func TestApplyBlocks(t *testing.T) {
+	dir := writeBlockFiles(t, testLedger(t)...)
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	defer s.Close()
+
+	source, err := newFileSource(dir, 0)
+	if err != nil {
+		t.Fatal("Failed to open recorded blocks:", err)
+	}
+	apply := func(expectedBlock uint64) {
+		t.Helper()
+		block, err := source.Next()
+		if err != nil {
+			t.Fatal("Failed to read block:", err)
+		}
+		update, err := extractUpdate(block, testChaincode)
+		if err != nil {
+			t.Fatal("extractUpdate failed:", err)
+		}
+		err = s.Apply(update)
+		if err != nil {
+			t.Fatal("Apply failed:", err)
+		}
+		checkNextBlock(t, s, expectedBlock+1)
+	}
+
+	// creations
+	apply(0)
+	apply(1)
+	pictures, err := s.Pictures("")
+	if err != nil || len(pictures) != 2 {
+		t.Fatalf("Unexpected pictures %s (%v)", pictures, err)
+	}
+	checkGeneration(t, s, "1", "picture1")
+	checkGeneration(t, s, "2", "picture2")
+	if changes, _ := s.OwnershipChanges("picture1"); ownershipSummary(changes) != "1:->LouvreMSP" || changes[0].TxID != "create1" || changes[0].Timestamp != "2018-09-01T10:00:00Z" {
+		t.Fatalf("Unexpected ownership changes %+v", changes)
+	}
+
+	// owner changes, the update keeping the owner is not one
+	apply(2)
+	apply(3)
+	changes, err := s.OwnershipChanges("picture1")
+	if err != nil || ownershipSummary(changes) != "1:->LouvreMSP,2:LouvreMSP>GuggenheimMSP,3:GuggenheimMSP>LouvreMSP" || changes[1].TxID != "transfer1" || changes[2].TxID != "transfer2" {
+		t.Fatalf("Unexpected ownership changes %+v (%v)", changes, err)
+	}
+	if pictures, _ := s.Pictures("GuggenheimMSP"); len(pictures) != 0 {
+		t.Fatalf("Unexpected Guggenheim pictures %s", pictures)
+	}
+	checkGeneration(t, s, "2", "")
+	checkGeneration(t, s, "3", "picture2")
+
+	// deletion
+	apply(4)
+	if pictureJSON, err := s.Picture("picture2"); err != nil || pictureJSON != nil {
+		t.Fatalf("Unexpected picture2 %s (%v)", pictureJSON, err)
+	}
+	checkGeneration(t, s, "3", "")
+	if changes, _ := s.OwnershipChanges("picture2"); ownershipSummary(changes) != "1:->LouvreMSP,4:LouvreMSP>-" {
+		t.Fatalf("Unexpected ownership changes %+v", changes)
+	}
+	pictureJSON, err := s.Picture("picture1")
+	record := pictureRecord{}
+	if err != nil || json.Unmarshal(pictureJSON, &record) != nil || record.Owner.MSPID != "LouvreMSP" || record.Generation != "1" {
+		t.Fatalf("Unexpected picture1 %s (%v)", pictureJSON, err)
+	}
+}
+
+func TestApplyIsAtomic(t *testing.T) {
+	dir := writeBlockFiles(t, testLedger(t)[:2]...)
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	defer s.Close()
+	applyBlockFiles(t, s, dir)
+
+	// a block failing half way leaves the store and the checkpoint as they were
+	update := &blockUpdate{Number: 2, Writes: []*stateWrite{
+		{Kind: writeGenerationIndex, Generation: "9", Name: "picture1"},
+		{Kind: writePicture, Name: "picture1", TxID: "broken", Value: []byte("not json")},
+	}}
+	if err := s.Apply(update); err == nil || !strings.Contains(err.Error(), "failed to decode picture picture1 in tx broken") {
+		t.Fatalf("Expected a decoding error, got %v", err)
+	}
+	checkNextBlock(t, s, 2)
+	checkGeneration(t, s, "9", "")
+}
+
+func TestCheckpointResume(t *testing.T) {
+	ledger := testLedger(t)
+	dir := writeBlockFiles(t, ledger[:3]...)
+	defer os.RemoveAll(dir)
+	s := openTestStore(t, dir)
+	applyBlockFiles(t, s, dir)
+	checkNextBlock(t, s, 3)
+	s.Close()
+
+	// the listener restarts once newer blocks are recorded, the older ones are gone: it must
+	// start from the checkpoint rather than from the genesis block
+	for _, block := range ledger[:3] {
+		err := os.Remove(filepath.Join(dir, fmt.Sprintf("artgallerieschannel_%d.block", block.Header.Number)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	addBlockFiles(t, dir, ledger[3:]...)
+	s = openTestStore(t, dir)
+	defer s.Close()
+	checkNextBlock(t, s, 3)
+	applyBlockFiles(t, s, dir)
+	checkNextBlock(t, s, 5)
+
+	changes, err := s.OwnershipChanges("picture1")
+	if err != nil || ownershipSummary(changes) != "1:->LouvreMSP,2:LouvreMSP>GuggenheimMSP,3:GuggenheimMSP>LouvreMSP" {
+		t.Fatalf("Unexpected ownership changes %+v (%v)", changes, err)
+	}
+	if pictures, _ := s.Pictures(""); len(pictures) != 1 {
+		t.Fatalf("Unexpected pictures %s", pictures)
+	}
+
+	// nothing new: a further restart applies nothing
+	applyBlockFiles(t, s, dir)
+	checkNextBlock(t, s, 5)
+}